@@ -0,0 +1,385 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"httpfromtcp/internal/config"
+	"httpfromtcp/internal/handlers"
+	"httpfromtcp/internal/middleware"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"httpfromtcp/internal/server"
+	"io"
+	"log"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// app owns the server and the resources built from the current config, so a
+// reload can swap them without dropping connections.
+type app struct {
+	config    *config.Config
+	server    *server.Server
+	listeners map[string]*appListener
+	current   atomic.Pointer[generation]
+}
+
+// appListener is a listener opened for a configured address. A TLS listener
+// takes its certificates from tlsConfig, which a reload replaces without
+// reopening the socket; it is nil for plain listeners.
+type appListener struct {
+	addr      net.Addr
+	tlsConfig *atomic.Pointer[tls.Config]
+}
+
+// generation is the handler built from one config together with the access
+// log it writes to. Once a reload replaces it, the log is closed when the
+// last request it is serving is done.
+type generation struct {
+	handler   server.Handler
+	accessLog io.Closer
+
+	mu      sync.Mutex
+	active  int
+	retired bool
+	closed  bool
+}
+
+// acquire counts a request served by g, reporting false if g's log has been
+// closed already.
+func (g *generation) acquire() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.closed {
+		return false
+	}
+	g.active++
+	return true
+}
+
+func (g *generation) release() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.active--
+	g.closeIfDoneLocked()
+}
+
+// retire closes g's access log as soon as no request is using it.
+func (g *generation) retire() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.retired = true
+	g.closeIfDoneLocked()
+}
+
+func (g *generation) closeIfDoneLocked() {
+	if g.retired && g.active == 0 && !g.closed {
+		g.closed = true
+		g.accessLog.Close()
+	}
+}
+
+func startApp(cfg *config.Config) (*app, error) {
+	opts := []server.Option{
+		server.WithRequestTimeout(time.Duration(cfg.Timeouts.Request)),
+		server.WithMaxRequestBytes(cfg.Limits.MaxRequestBytes),
+		server.WithMaxConnections(cfg.Limits.MaxConnections),
+		server.WithMaxConnectionsPerIP(cfg.Limits.MaxConnectionsPerIP),
+	}
+	if cfg.Log.MetricsPath != "" {
+		opts = append(opts, server.WithMetrics(cfg.Log.MetricsPath))
+	}
+	if cfg.HTTP2 {
+		opts = append(opts, server.WithHTTP2())
+	}
+
+	handler, accessLog, err := buildHandler(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	a := &app{
+		config:    cfg,
+		listeners: map[string]*appListener{},
+	}
+	a.current.Store(&generation{handler: handler, accessLog: accessLog})
+	a.server = server.New(a.serve, opts...)
+
+	if err := a.syncListeners(cfg); err != nil {
+		a.close()
+		return nil, err
+	}
+	return a, nil
+}
+
+// reload applies cfg to the running server. Listeners are opened and closed
+// as needed while established connections carry on. Timeouts, limits, the
+// metrics path and HTTP/2 are fixed when the server is created, so a config
+// changing them is refused and the current one kept.
+func (a *app) reload(cfg *config.Config) error {
+	if cfg.Timeouts != a.config.Timeouts || cfg.Limits != a.config.Limits || cfg.Log.MetricsPath != a.config.Log.MetricsPath || cfg.HTTP2 != a.config.HTTP2 {
+		return errors.New("Timeouts, limits, log.metrics_path and http2 can only change on restart")
+	}
+
+	handler, accessLog, err := buildHandler(cfg)
+	if err != nil {
+		return err
+	}
+
+	if err := a.syncListeners(cfg); err != nil {
+		accessLog.Close()
+		return err
+	}
+
+	previous := a.current.Swap(&generation{handler: handler, accessLog: accessLog})
+	previous.retire()
+	a.config = cfg
+
+	return nil
+}
+
+// serve hands req to the current generation's handler. A request that picked
+// up a generation whose log was closed in the meantime takes the one that
+// replaced it.
+func (a *app) serve(w *response.Writer, req *request.Request) {
+	g := a.current.Load()
+	for !g.acquire() {
+		g = a.current.Load()
+	}
+	defer g.release()
+
+	g.handler(w, req)
+}
+
+// syncListeners makes the listeners match cfg, keyed by address. New
+// addresses are opened and dropped ones closed; a TLS listener gets its new
+// certificates swapped in without reopening the socket. Only a listener that
+// switches between plain and TLS is closed and opened again. If one can't be
+// opened, the listeners are put back as they were as far as possible.
+func (a *app) syncListeners(cfg *config.Config) error {
+	// Certificates are loaded first, so a bad key pair fails the reload
+	// before any listener has changed.
+	tlsConfigs := map[string]*tls.Config{}
+	for _, listener := range cfg.Listeners {
+		if listener.TLS == nil {
+			continue
+		}
+		tlsConfig, err := newTLSConfig(listener.TLS)
+		if err != nil {
+			return err
+		}
+		tlsConfigs[listener.Address] = tlsConfig
+	}
+
+	var opened []string
+	replaced := map[string]*appListener{}
+	for _, listener := range cfg.Listeners {
+		tlsConfig := tlsConfigs[listener.Address]
+		current, ok := a.listeners[listener.Address]
+		if ok && (current.tlsConfig != nil) == (tlsConfig != nil) {
+			continue
+		}
+		if ok {
+			a.removeListener(listener.Address)
+			replaced[listener.Address] = current
+		}
+
+		if err := a.openListener(listener.Address, tlsConfig); err != nil {
+			for _, address := range opened {
+				a.removeListener(address)
+			}
+			for address, previous := range replaced {
+				var previousTLS *tls.Config
+				if previous.tlsConfig != nil {
+					previousTLS = previous.tlsConfig.Load()
+				}
+				if err := a.openListener(address, previousTLS); err != nil {
+					log.Printf("Error reopening listener %s: %s", address, err)
+				}
+			}
+			return err
+		}
+		opened = append(opened, listener.Address)
+	}
+
+	for address, tlsConfig := range tlsConfigs {
+		a.listeners[address].tlsConfig.Store(tlsConfig)
+	}
+	wanted := map[string]bool{}
+	for _, listener := range cfg.Listeners {
+		wanted[listener.Address] = true
+	}
+	for address := range a.listeners {
+		if !wanted[address] {
+			a.removeListener(address)
+		}
+	}
+
+	return nil
+}
+
+// openListener listens on address, speaking TLS with tlsConfig's
+// certificates unless it is nil.
+func (a *app) openListener(address string, tlsConfig *tls.Config) error {
+	listener := &appListener{}
+	var err error
+	if tlsConfig == nil {
+		listener.addr, err = a.server.Listen(address)
+	} else {
+		listener.tlsConfig = &atomic.Pointer[tls.Config]{}
+		listener.tlsConfig.Store(tlsConfig)
+		listener.addr, err = a.server.ListenTLS(address, &tls.Config{
+			MinVersion: tls.VersionTLS12,
+			GetCertificate: func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+				return listener.tlsConfig.Load().GetCertificate(hello)
+			},
+		})
+	}
+	if err != nil {
+		return err
+	}
+
+	a.listeners[address] = listener
+	log.Println("Listening on", listener.addr)
+	return nil
+}
+
+func (a *app) removeListener(address string) {
+	addr := a.listeners[address].addr
+	if err := a.server.RemoveListener(addr); err != nil {
+		log.Printf("Error closing listener %s: %s", addr, err)
+	}
+	delete(a.listeners, address)
+	log.Println("Stopped listening on", addr)
+}
+
+func newTLSConfig(cfg *config.TLS) (*tls.Config, error) {
+	pairs := make([]server.KeyPair, 0, len(cfg.Certificates))
+	for _, pair := range cfg.Certificates {
+		pairs = append(pairs, server.KeyPair{CertFile: pair.CertFile, KeyFile: pair.KeyFile})
+	}
+	return server.NewTLSConfig(pairs)
+}
+
+func (a *app) close() {
+	a.server.Close()
+	a.current.Load().retire()
+}
+
+type route struct {
+	prefix  string
+	handler server.Handler
+	// methods is nil for proxy routes, whose upstream decides.
+	methods []string
+}
+
+// buildHandler routes the configured static roots and proxy routes by
+// longest prefix, falls back to handlers.NewHandler and wraps it all in
+// OPTIONS answers, compression and the access log.
+func buildHandler(cfg *config.Config) (server.Handler, io.Closer, error) {
+	routes := []route{}
+	for _, root := range cfg.Static {
+		routes = append(routes, route{root.Prefix, handlers.Static(root.Prefix, root.Dir), []string{"GET"}})
+	}
+	for _, proxy := range cfg.Proxy {
+		routes = append(routes, route{proxy.Prefix, handlers.Proxy(proxy.Prefix, proxy.Target), nil})
+	}
+	sort.SliceStable(routes, func(i, j int) bool {
+		return len(routes[i].prefix) > len(routes[j].prefix)
+	})
+
+	var forwardProxy server.Handler
+	if cfg.ForwardProxy != nil {
+		var err error
+		forwardProxy, err = handlers.ForwardProxy(handlers.ForwardProxyConfig{
+			Allow: cfg.ForwardProxy.Allow,
+			Deny:  cfg.ForwardProxy.Deny,
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	var handler server.Handler = func(w *response.Writer, req *request.Request) {
+		if forwardProxy != nil && handlers.IsProxyRequest(req) {
+			forwardProxy(w, req)
+			return
+		}
+		for _, route := range routes {
+			if strings.HasPrefix(req.RequestLine.RequestTarget, route.prefix) {
+				route.handler(w, req)
+				return
+			}
+		}
+		handlers.NewHandler(w, req)
+	}
+	// OPTIONS * lists every method some route takes; proxied requests can
+	// have any method the upstream supports.
+	serverMethods := []string{"GET"}
+	if len(cfg.Proxy) > 0 || forwardProxy != nil {
+		serverMethods = append(serverMethods, "POST", "PUT", "PATCH", "DELETE")
+	}
+	if forwardProxy != nil {
+		serverMethods = append(serverMethods, "CONNECT")
+	}
+	allowed := func(req *request.Request) []string {
+		if forwardProxy != nil && handlers.IsProxyRequest(req) {
+			return nil
+		}
+		if req.RequestLine.RequestTarget == "*" {
+			return serverMethods
+		}
+		for _, route := range routes {
+			if strings.HasPrefix(req.RequestLine.RequestTarget, route.prefix) {
+				return route.methods
+			}
+		}
+		return handlers.NewHandlerMethods(req)
+	}
+	handler = middleware.AllowMethods(allowed, handler)
+	handler = middleware.Compress(middleware.DefaultCompressMinSize, handler)
+
+	if cfg.Log.AccessFormat == "off" {
+		return handler, nopCloser{}, nil
+	}
+
+	out, closer, err := openAccessLog(cfg.Log.AccessFile)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	format := map[string]middleware.LogFormat{
+		"common":   middleware.CommonLogFormat,
+		"combined": middleware.CombinedLogFormat,
+		"json":     middleware.JSONLogFormat,
+	}[cfg.Log.AccessFormat]
+
+	return middleware.AccessLog(out, format, handler), closer, nil
+}
+
+func openAccessLog(path string) (io.Writer, io.Closer, error) {
+	if path == "" || path == "-" {
+		return os.Stdout, nopCloser{}, nil
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Error opening access log %s: %w", path, err)
+	}
+	return file, file, nil
+}
+
+type nopCloser struct{}
+
+func (nopCloser) Close() error {
+	return nil
+}
@@ -1,26 +1,70 @@
 package main
 
 import (
-	"httpfromtcp/internal/handlers"
-	"httpfromtcp/internal/server"
+	"flag"
+	"httpfromtcp/internal/config"
 	"log"
 	"os"
 	"os/signal"
 	"syscall"
 )
 
-const port = 42069
-
 func main() {
-	server, err := server.Serve(port, handlers.NewHandler)
+	configPath := flag.String("config", "", "path to a YAML, JSON or TOML config file")
+	address := flag.String("addr", "", "listen address, replacing the listeners from the config file")
+	accessFormat := flag.String("access-log", "", "access log format: common, combined, json or off")
+	flag.Parse()
+
+	load := func() (*config.Config, error) {
+		cfg := config.Default()
+		if *configPath != "" {
+			var err error
+			cfg, err = config.Load(*configPath)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		if *address != "" {
+			cfg.Listeners = []config.Listener{{Address: *address}}
+		}
+		if *accessFormat != "" {
+			cfg.Log.AccessFormat = *accessFormat
+		}
+
+		return cfg, cfg.Validate()
+	}
+
+	cfg, err := load()
+	if err != nil {
+		log.Fatalf("Invalid configuration:\n%v", err)
+	}
+
+	app, err := startApp(cfg)
 	if err != nil {
 		log.Fatalf("Error starting server: %v", err)
 	}
-	defer server.Close()
-	log.Println("Server started on port", port)
+	defer app.close()
+	log.Println("Server started")
 
 	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
-	<-sigChan
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+
+	for sig := range sigChan {
+		if sig != syscall.SIGHUP {
+			break
+		}
+
+		cfg, err := load()
+		if err != nil {
+			log.Printf("Keeping current configuration, new one is invalid:\n%v", err)
+			continue
+		}
+		if err := app.reload(cfg); err != nil {
+			log.Printf("Error reloading configuration: %v", err)
+			continue
+		}
+		log.Println("Configuration reloaded")
+	}
 	log.Println("Server gracefully stopped")
 }
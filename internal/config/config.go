@@ -0,0 +1,246 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// Config describes everything cmd/httpserver sets up at startup and on reload.
+type Config struct {
+	Listeners []Listener   `json:"listeners" yaml:"listeners"`
+	Timeouts  Timeouts     `json:"timeouts" yaml:"timeouts"`
+	Limits    Limits       `json:"limits" yaml:"limits"`
+	Static    []StaticRoot `json:"static" yaml:"static"`
+	Proxy     []ProxyRoute `json:"proxy" yaml:"proxy"`
+	// ForwardProxy turns on forward proxying of CONNECT and absolute-form
+	// requests when set.
+	ForwardProxy *ForwardProxy `json:"forward_proxy" yaml:"forward_proxy"`
+	// HTTP2 serves HTTP/2 beside HTTP/1.1 on every listener.
+	HTTP2 bool `json:"http2" yaml:"http2"`
+	Log   Log  `json:"log" yaml:"log"`
+}
+
+type Listener struct {
+	// Address is anything server.ParseAddress accepts, e.g. ":42069",
+	// "tcp6:[::1]:8443" or "unix:/run/httpfromtcp.sock".
+	Address string `json:"address" yaml:"address"`
+	TLS     *TLS   `json:"tls" yaml:"tls"`
+}
+
+type TLS struct {
+	Certificates []KeyPair `json:"certificates" yaml:"certificates"`
+}
+
+type KeyPair struct {
+	CertFile string `json:"cert_file" yaml:"cert_file"`
+	KeyFile  string `json:"key_file" yaml:"key_file"`
+}
+
+type Timeouts struct {
+	Request Duration `json:"request" yaml:"request"`
+}
+
+type Limits struct {
+	MaxRequestBytes     int64 `json:"max_request_bytes" yaml:"max_request_bytes"`
+	MaxConnections      int   `json:"max_connections" yaml:"max_connections"`
+	MaxConnectionsPerIP int   `json:"max_connections_per_ip" yaml:"max_connections_per_ip"`
+}
+
+type StaticRoot struct {
+	Prefix string `json:"prefix" yaml:"prefix"`
+	Dir    string `json:"dir" yaml:"dir"`
+}
+
+type ProxyRoute struct {
+	Prefix string `json:"prefix" yaml:"prefix"`
+	Target string `json:"target" yaml:"target"`
+}
+
+// ForwardProxy restricts the destinations a forward proxy may reach; see
+// handlers.ForwardProxyConfig for the pattern syntax.
+type ForwardProxy struct {
+	Allow []string `json:"allow" yaml:"allow"`
+	Deny  []string `json:"deny" yaml:"deny"`
+}
+
+type Log struct {
+	// AccessFormat is one of "common", "combined", "json" or "off".
+	AccessFormat string `json:"access_format" yaml:"access_format"`
+	// AccessFile is the access log path, or "-" for stdout.
+	AccessFile  string `json:"access_file" yaml:"access_file"`
+	MetricsPath string `json:"metrics_path" yaml:"metrics_path"`
+}
+
+// Duration is a time.Duration written as a string such as "30s" in files.
+type Duration time.Duration
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	parsed, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+func Default() *Config {
+	return &Config{
+		Listeners: []Listener{{Address: ":42069"}},
+		Log: Log{
+			AccessFormat: "combined",
+			AccessFile:   "-",
+			MetricsPath:  "/metrics",
+		},
+	}
+}
+
+// Load reads the file at path, picking the format from its extension:
+// .yaml/.yml, .json or .toml. Settings missing from the file keep their
+// defaults.
+func Load(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading config %s: %w", path, err)
+	}
+
+	format := strings.TrimPrefix(filepath.Ext(path), ".")
+	config, err := Parse(data, format)
+	if err != nil {
+		return nil, fmt.Errorf("Error parsing config %s: %w", path, err)
+	}
+	return config, nil
+}
+
+// Parse decodes data in the given format ("yaml", "yml", "json" or "toml")
+// on top of the defaults.
+func Parse(data []byte, format string) (*Config, error) {
+	config := Default()
+
+	switch strings.ToLower(format) {
+	case "yaml", "yml":
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		if err := decoder.Decode(config); err != nil && !errors.Is(err, io.EOF) {
+			return nil, err
+		}
+	case "json":
+		if err := decodeJSON(data, config); err != nil {
+			return nil, err
+		}
+	case "toml":
+		// Decoding into a map and then through JSON rejects unknown fields
+		// and parses durations the same way for every format.
+		var table map[string]any
+		if err := toml.Unmarshal(data, &table); err != nil {
+			return nil, err
+		}
+		data, err := json.Marshal(table)
+		if err != nil {
+			return nil, err
+		}
+		if err := decodeJSON(data, config); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("Unknown config format %q", format)
+	}
+
+	return config, nil
+}
+
+func decodeJSON(data []byte, config *Config) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	return decoder.Decode(config)
+}
+
+// Validate reports every problem in the config at once.
+func (c *Config) Validate() error {
+	var errs []error
+
+	if len(c.Listeners) == 0 {
+		errs = append(errs, errors.New("at least one listener is required"))
+	}
+	seen := map[string]bool{}
+	for i, listener := range c.Listeners {
+		if listener.Address == "" {
+			errs = append(errs, fmt.Errorf("listeners[%d]: address is required", i))
+		}
+		if seen[listener.Address] {
+			errs = append(errs, fmt.Errorf("listeners[%d]: duplicate address %q", i, listener.Address))
+		}
+		seen[listener.Address] = true
+
+		if listener.TLS == nil {
+			continue
+		}
+		if len(listener.TLS.Certificates) == 0 {
+			errs = append(errs, fmt.Errorf("listeners[%d]: tls needs at least one certificate", i))
+		}
+		for j, pair := range listener.TLS.Certificates {
+			for _, file := range []string{pair.CertFile, pair.KeyFile} {
+				if _, err := os.Stat(file); err != nil {
+					errs = append(errs, fmt.Errorf("listeners[%d].tls.certificates[%d]: %w", i, j, err))
+				}
+			}
+		}
+	}
+
+	if c.Timeouts.Request < 0 {
+		errs = append(errs, errors.New("timeouts.request must not be negative"))
+	}
+	if c.Limits.MaxRequestBytes < 0 {
+		errs = append(errs, errors.New("limits.max_request_bytes must not be negative"))
+	}
+	if c.Limits.MaxConnections < 0 {
+		errs = append(errs, errors.New("limits.max_connections must not be negative"))
+	}
+	if c.Limits.MaxConnectionsPerIP < 0 {
+		errs = append(errs, errors.New("limits.max_connections_per_ip must not be negative"))
+	}
+
+	for i, root := range c.Static {
+		if !strings.HasPrefix(root.Prefix, "/") {
+			errs = append(errs, fmt.Errorf("static[%d]: prefix must start with /", i))
+		}
+		if info, err := os.Stat(root.Dir); err != nil {
+			errs = append(errs, fmt.Errorf("static[%d]: %w", i, err))
+		} else if !info.IsDir() {
+			errs = append(errs, fmt.Errorf("static[%d]: %s is not a directory", i, root.Dir))
+		}
+	}
+
+	for i, route := range c.Proxy {
+		if !strings.HasPrefix(route.Prefix, "/") {
+			errs = append(errs, fmt.Errorf("proxy[%d]: prefix must start with /", i))
+		}
+		if !strings.HasPrefix(route.Target, "http://") && !strings.HasPrefix(route.Target, "https://") {
+			errs = append(errs, fmt.Errorf("proxy[%d]: target must be an http or https URL", i))
+		}
+	}
+
+	switch c.Log.AccessFormat {
+	case "common", "combined", "json", "off":
+	default:
+		errs = append(errs, fmt.Errorf("log.access_format: unknown format %q", c.Log.AccessFormat))
+	}
+	if c.Log.MetricsPath != "" && !strings.HasPrefix(c.Log.MetricsPath, "/") {
+		errs = append(errs, errors.New("log.metrics_path must start with /"))
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,174 @@
+package config
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	dir := t.TempDir()
+
+	expected := &Config{
+		Listeners: []Listener{
+			{Address: ":8080"},
+			{Address: "unix:/tmp/httpfromtcp.sock"},
+		},
+		HTTP2:    true,
+		Timeouts: Timeouts{Request: Duration(30 * time.Second)},
+		Limits:   Limits{MaxRequestBytes: 1048576, MaxConnections: 512},
+		Static:   []StaticRoot{{Prefix: "/assets/", Dir: dir}},
+		Proxy:    []ProxyRoute{{Prefix: "/httpbin/", Target: "https://httpbin.org"}},
+		ForwardProxy: &ForwardProxy{
+			Allow: []string{"*.example.com:443"},
+			Deny:  []string{"10.0.0.0/8"},
+		},
+		Log: Log{
+			AccessFormat: "json",
+			AccessFile:   "-",
+			MetricsPath:  "/metrics",
+		},
+	}
+
+	// Test: YAML
+	config, err := Parse([]byte(`
+listeners:
+  - address: ":8080"
+  - address: "unix:/tmp/httpfromtcp.sock"
+http2: true
+timeouts:
+  request: 30s
+limits:
+  max_request_bytes: 1048576
+  max_connections: 512
+static:
+  - prefix: /assets/
+    dir: `+dir+`
+proxy:
+  - prefix: /httpbin/
+    target: https://httpbin.org
+forward_proxy:
+  allow: ["*.example.com:443"]
+  deny: [10.0.0.0/8]
+log:
+  access_format: json
+`), "yaml")
+	require.NoError(t, err)
+	assert.Equal(t, expected, config)
+	assert.NoError(t, config.Validate())
+
+	// Test: JSON
+	config, err = Parse([]byte(`{
+  "listeners": [{"address": ":8080"}, {"address": "unix:/tmp/httpfromtcp.sock"}],
+  "http2": true,
+  "timeouts": {"request": "30s"},
+  "limits": {"max_request_bytes": 1048576, "max_connections": 512},
+  "static": [{"prefix": "/assets/", "dir": "`+dir+`"}],
+  "proxy": [{"prefix": "/httpbin/", "target": "https://httpbin.org"}],
+  "forward_proxy": {"allow": ["*.example.com:443"], "deny": ["10.0.0.0/8"]},
+  "log": {"access_format": "json"}
+}`), "json")
+	require.NoError(t, err)
+	assert.Equal(t, expected, config)
+
+	// Test: TOML
+	config, err = Parse([]byte(`
+http2 = true
+
+# listeners
+[[listeners]]
+address = ":8080"
+
+[[listeners]]
+address = 'unix:/tmp/httpfromtcp.sock' # socket
+
+[timeouts]
+request = "30s"
+
+[limits]
+max_request_bytes = 1_048_576
+max_connections = 512
+
+[[static]]
+prefix = "/assets/"
+dir = "`+dir+`"
+
+[[proxy]]
+prefix = "/httpbin/"
+target = "https://httpbin.org"
+
+[forward_proxy]
+allow = ["*.example.com:443"]
+deny = ["10.0.0.0/8"]
+
+[log]
+access_format = "json"
+`), "toml")
+	require.NoError(t, err)
+	assert.Equal(t, expected, config)
+
+	// Test: TOML inline tables and multi-line arrays
+	config, err = Parse([]byte(`
+listeners = [
+  { address = ":8443", tls = { certificates = [{ cert_file = "a.crt", key_file = "a.key" }] } },
+]
+`), "toml")
+	require.NoError(t, err)
+	assert.Equal(t, []Listener{{Address: ":8443", TLS: &TLS{Certificates: []KeyPair{{CertFile: "a.crt", KeyFile: "a.key"}}}}}, config.Listeners)
+
+	// Test: Unknown fields are rejected
+	_, err = Parse([]byte("listners: []\n"), "yaml")
+	require.Error(t, err)
+	_, err = Parse([]byte(`{"listners": []}`), "json")
+	require.Error(t, err)
+
+	// Test: Invalid TOML is rejected rather than reinterpreted
+	for _, input := range []string{
+		"[forward_proxy]\nallow = [\"a\" \"b\"]\n",
+		"[limits]\nmax_connections = 010\n",
+	} {
+		_, err = Parse([]byte(input), "toml")
+		assert.Error(t, err, input)
+	}
+
+	// Test: Invalid duration
+	_, err = Parse([]byte("timeouts:\n  request: soon\n"), "yaml")
+	require.Error(t, err)
+
+	// Test: Unknown format
+	_, err = Parse([]byte(""), "ini")
+	require.Error(t, err)
+
+	// Test: Empty file keeps the defaults
+	config, err = Parse([]byte(""), "yaml")
+	require.NoError(t, err)
+	assert.Equal(t, Default(), config)
+}
+
+func TestValidate(t *testing.T) {
+
+	// Test: Defaults are valid
+	require.NoError(t, Default().Validate())
+
+	// Test: Every problem is reported
+	config := Default()
+	config.Listeners = append(config.Listeners, Listener{Address: ":42069", TLS: &TLS{}})
+	config.Static = []StaticRoot{{Prefix: "assets", Dir: "/does/not/exist"}}
+	config.Proxy = []ProxyRoute{{Prefix: "/p/", Target: "ftp://example.com"}}
+	config.Log.AccessFormat = "xml"
+	config.Timeouts.Request = Duration(-time.Second)
+	config.Limits.MaxConnectionsPerIP = -1
+
+	err := config.Validate()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `listeners[1]: duplicate address ":42069"`)
+	assert.Contains(t, err.Error(), "listeners[1]: tls needs at least one certificate")
+	assert.Contains(t, err.Error(), "static[0]: prefix must start with /")
+	assert.Contains(t, err.Error(), "static[0]: stat /does/not/exist")
+	assert.Contains(t, err.Error(), "proxy[0]: target must be an http or https URL")
+	assert.Contains(t, err.Error(), `log.access_format: unknown format "xml"`)
+	assert.Contains(t, err.Error(), "timeouts.request must not be negative")
+	assert.Contains(t, err.Error(), "limits.max_connections_per_ip must not be negative")
+}
@@ -0,0 +1,180 @@
+// Package cookie parses the Cookie request header and builds Set-Cookie
+// values as described in RFC 6265.
+package cookie
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrInvalid is returned for cookies that can't be sent as they are.
+var ErrInvalid = errors.New("invalid cookie")
+
+// timeFormat is the IMF-fixdate format of the Expires attribute.
+const timeFormat = "Mon, 02 Jan 2006 15:04:05 GMT"
+
+type SameSite int
+
+const (
+	// SameSiteDefault leaves the attribute out and the choice to the browser.
+	SameSiteDefault SameSite = iota
+	SameSiteLax
+	SameSiteStrict
+	SameSiteNone
+)
+
+// Cookie is a cookie as received in a Cookie header, where only Name and
+// Value are set, or as sent in a Set-Cookie header.
+type Cookie struct {
+	Name  string
+	Value string
+
+	Path    string
+	Domain  string
+	Expires time.Time
+	// MaxAge is sent when non-zero; a negative MaxAge deletes the cookie
+	// right away and is sent as Max-Age=0.
+	MaxAge int
+
+	Secure   bool
+	HttpOnly bool
+	SameSite SameSite
+	// Partitioned cookies are kept apart per top-level site (CHIPS).
+	Partitioned bool
+}
+
+// Parse returns the cookies in a Cookie header value in order, skipping
+// pairs that aren't valid.
+func Parse(header string) []*Cookie {
+	var cookies []*Cookie
+	for pair := range strings.SplitSeq(header, ";") {
+		name, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok || !validName(name) {
+			continue
+		}
+		if len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"' {
+			value = value[1 : len(value)-1]
+		}
+		if !validValue(value) {
+			continue
+		}
+		cookies = append(cookies, &Cookie{Name: name, Value: value})
+	}
+	return cookies
+}
+
+// Valid reports why c can't be sent in a Set-Cookie header, if it can't.
+func (c *Cookie) Valid() error {
+	if !validName(c.Name) {
+		return fmt.Errorf("%w: name %q", ErrInvalid, c.Name)
+	}
+	if !validValue(c.Value) {
+		return fmt.Errorf("%w: value %q", ErrInvalid, c.Value)
+	}
+	if strings.ContainsAny(c.Path, ";\x7f") || strings.IndexFunc(c.Path, isControl) >= 0 {
+		return fmt.Errorf("%w: path %q", ErrInvalid, c.Path)
+	}
+	if !validDomain(strings.TrimPrefix(c.Domain, ".")) {
+		return fmt.Errorf("%w: domain %q", ErrInvalid, c.Domain)
+	}
+	if !c.Expires.IsZero() && c.Expires.Year() < 1601 {
+		return fmt.Errorf("%w: expires %s", ErrInvalid, c.Expires)
+	}
+	if (c.SameSite == SameSiteNone || c.Partitioned) && !c.Secure {
+		return fmt.Errorf("%w: SameSite=None and Partitioned need Secure", ErrInvalid)
+	}
+	return nil
+}
+
+// String returns the Set-Cookie header value for c. Check Valid first; an
+// invalid cookie gives a value browsers will ignore or misread.
+func (c *Cookie) String() string {
+	var b strings.Builder
+	b.WriteString(c.Name)
+	b.WriteByte('=')
+	if strings.ContainsAny(c.Value, " ,") {
+		b.WriteString(strconv.Quote(c.Value))
+	} else {
+		b.WriteString(c.Value)
+	}
+
+	if c.Path != "" {
+		b.WriteString("; Path=" + c.Path)
+	}
+	if c.Domain != "" {
+		b.WriteString("; Domain=" + strings.TrimPrefix(c.Domain, "."))
+	}
+	if !c.Expires.IsZero() {
+		b.WriteString("; Expires=" + c.Expires.UTC().Format(timeFormat))
+	}
+	switch {
+	case c.MaxAge > 0:
+		b.WriteString("; Max-Age=" + strconv.Itoa(c.MaxAge))
+	case c.MaxAge < 0:
+		b.WriteString("; Max-Age=0")
+	}
+	if c.Secure {
+		b.WriteString("; Secure")
+	}
+	if c.HttpOnly {
+		b.WriteString("; HttpOnly")
+	}
+	switch c.SameSite {
+	case SameSiteLax:
+		b.WriteString("; SameSite=Lax")
+	case SameSiteStrict:
+		b.WriteString("; SameSite=Strict")
+	case SameSiteNone:
+		b.WriteString("; SameSite=None")
+	}
+	if c.Partitioned {
+		b.WriteString("; Partitioned")
+	}
+	return b.String()
+}
+
+// validName reports whether name is an RFC 7230 token.
+func validName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		if c <= ' ' || c >= 0x7f || strings.IndexByte("()<>@,;:\\\"/[]?={}", c) >= 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// validValue reports whether value consists of cookie-octets. Spaces and
+// commas are allowed too, since String quotes values containing them.
+func validValue(value string) bool {
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		if c < ' ' || c >= 0x7f || c == '"' || c == ';' || c == '\\' {
+			return false
+		}
+	}
+	return true
+}
+
+func validDomain(domain string) bool {
+	if len(domain) > 255 {
+		return false
+	}
+	for i := 0; i < len(domain); i++ {
+		c := domain[i]
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '.' || c == ':') {
+			return false
+		}
+	}
+	return true
+}
+
+func isControl(r rune) bool {
+	return r < ' '
+}
@@ -0,0 +1,64 @@
+package cookie
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParse(t *testing.T) {
+	// Test: Pairs are returned in order with quotes removed
+	cookies := Parse(`session=abc123; theme="dark"; empty=; lang=en-US`)
+	assert.Equal(t, []*Cookie{
+		{Name: "session", Value: "abc123"},
+		{Name: "theme", Value: "dark"},
+		{Name: "empty", Value: ""},
+		{Name: "lang", Value: "en-US"},
+	}, cookies)
+
+	// Test: Invalid pairs are skipped
+	cookies = Parse(`noequals; bad name=1; ok=1; quote=a"b; =nameless;;`)
+	assert.Equal(t, []*Cookie{{Name: "ok", Value: "1"}}, cookies)
+
+	assert.Empty(t, Parse(""))
+}
+
+func TestString(t *testing.T) {
+	// Test: Every attribute
+	c := &Cookie{
+		Name:        "id",
+		Value:       "a3fWa",
+		Path:        "/app",
+		Domain:      ".example.com",
+		Expires:     time.Date(2015, 10, 21, 9, 28, 0, 0, time.FixedZone("CEST", 2*60*60)),
+		MaxAge:      3600,
+		Secure:      true,
+		HttpOnly:    true,
+		SameSite:    SameSiteNone,
+		Partitioned: true,
+	}
+	assert.NoError(t, c.Valid())
+	assert.Equal(t, "id=a3fWa; Path=/app; Domain=example.com; Expires=Wed, 21 Oct 2015 07:28:00 GMT; Max-Age=3600; Secure; HttpOnly; SameSite=None; Partitioned", c.String())
+
+	// Test: Minimal cookie, deletion and quoting
+	assert.Equal(t, "a=b", (&Cookie{Name: "a", Value: "b"}).String())
+	assert.Equal(t, "a=; Max-Age=0", (&Cookie{Name: "a", MaxAge: -1}).String())
+	assert.Equal(t, `a="x y,z"; SameSite=Lax`, (&Cookie{Name: "a", Value: "x y,z", SameSite: SameSiteLax}).String())
+	assert.Equal(t, "a=b; SameSite=Strict", (&Cookie{Name: "a", Value: "b", SameSite: SameSiteStrict}).String())
+
+	// Test: Invalid cookies
+	for _, c := range []*Cookie{
+		{Name: "", Value: "x"},
+		{Name: "a b", Value: "x"},
+		{Name: "a", Value: "x;y"},
+		{Name: "a", Value: "\"x"},
+		{Name: "a", Value: "x", Path: "/a;b"},
+		{Name: "a", Value: "x", Domain: "exa mple.com"},
+		{Name: "a", Value: "x", Expires: time.Date(1500, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{Name: "a", Value: "x", SameSite: SameSiteNone},
+		{Name: "a", Value: "x", Partitioned: true},
+	} {
+		assert.ErrorIs(t, c.Valid(), ErrInvalid, c.String())
+	}
+}
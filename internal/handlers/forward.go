@@ -0,0 +1,301 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"httpfromtcp/internal/headers"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const dialTimeout = 10 * time.Second
+
+var errDestinationDenied = errors.New("destination not allowed")
+
+// DialFunc opens a connection to a forward proxy destination.
+type DialFunc func(ctx context.Context, network, address string) (net.Conn, error)
+
+type ForwardProxyConfig struct {
+	// Allow lists the destinations clients may reach; when empty, every
+	// destination that isn't denied is. Entries are a host name, a
+	// "*.example.com" wildcard, an IP address or a CIDR block, each optionally
+	// followed by ":port". Host names are resolved first, and every address
+	// they resolve to is checked. Loopback, private and link-local addresses
+	// are refused unless an address or block in Allow covers them.
+	Allow []string
+	// Deny lists destinations refused even when Allow matches them.
+	Deny []string
+	// Dial defaults to a net.Dialer with a 10 second timeout.
+	Dial DialFunc
+}
+
+// IsProxyRequest reports whether req is addressed to a forward proxy: a
+// CONNECT or a request with an absolute-form target.
+func IsProxyRequest(req *request.Request) bool {
+	return req.RequestLine.Method == "CONNECT" || isAbsoluteHTTP(req.RequestLine.RequestTarget)
+}
+
+// isAbsoluteHTTP reports whether target starts with the http scheme, which
+// is case-insensitive.
+func isAbsoluteHTTP(target string) bool {
+	return len(target) >= len("http://") && strings.EqualFold(target[:len("http://")], "http://")
+}
+
+// ForwardProxy acts as a forward proxy: CONNECT requests get a tunnel to the
+// requested host:port, and requests with an absolute http:// target are
+// forwarded there. Destinations outside the allow and deny lists get 403, and
+// destinations that can't be reached get 502.
+func ForwardProxy(config ForwardProxyConfig) (func(w *response.Writer, req *request.Request), error) {
+	p := &forwardProxy{dial: config.Dial}
+	if p.dial == nil {
+		p.dial = (&net.Dialer{Timeout: dialTimeout}).DialContext
+	}
+
+	var err error
+	if p.allow, err = parseDestinations(config.Allow); err != nil {
+		return nil, err
+	}
+	if p.deny, err = parseDestinations(config.Deny); err != nil {
+		return nil, err
+	}
+
+	p.client = &http.Client{
+		Transport: &http.Transport{
+			Proxy:       nil,
+			DialContext: p.dialChecked,
+		},
+		// Redirects are the client's business.
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	return p.serve, nil
+}
+
+type forwardProxy struct {
+	allow  []destination
+	deny   []destination
+	dial   DialFunc
+	client *http.Client
+}
+
+func (p *forwardProxy) serve(w *response.Writer, req *request.Request) {
+	switch {
+	case req.RequestLine.Method == "CONNECT":
+		p.tunnel(w, req)
+	case isAbsoluteHTTP(req.RequestLine.RequestTarget):
+		target, err := url.Parse(req.RequestLine.RequestTarget)
+		if err != nil || target.Host == "" {
+			writeText(w, response.BadRequest, "text/plain", []byte("Bad Request"))
+			return
+		}
+		forward(w, req, p.client, target.String())
+	default:
+		writeText(w, response.BadRequest, "text/plain", []byte("Not a proxy request"))
+	}
+}
+
+// tunnel answers a CONNECT with 200 and copies bytes between the client and
+// the destination until both sides are done.
+func (p *forwardProxy) tunnel(w *response.Writer, req *request.Request) {
+	address := req.RequestLine.RequestTarget
+	if _, port, err := net.SplitHostPort(address); err != nil || port == "" {
+		writeText(w, response.BadRequest, "text/plain", []byte("CONNECT target must be host:port"))
+		return
+	}
+
+	upstream, err := p.dialChecked(req.Context(), "tcp", address)
+	if errors.Is(err, errDestinationDenied) {
+		writeText(w, response.Forbidden, "text/plain", []byte("Forbidden"))
+		return
+	}
+	if err != nil {
+		log.Printf("error connecting to %s: %s", address, err)
+		writeText(w, response.BadGateway, "text/plain", []byte("Bad Gateway"))
+		return
+	}
+
+	if err := w.WriteStatusLine(response.OK); err != nil {
+		upstream.Close()
+		return
+	}
+	if err := w.WriteHeaders(headers.NewHeaders()); err != nil {
+		upstream.Close()
+		return
+	}
+
+	client, buffered, err := w.Hijack()
+	if err != nil {
+		log.Printf("error taking over connection for %s: %s", address, err)
+		upstream.Close()
+		return
+	}
+
+	splice(client, buffered, upstream)
+}
+
+func splice(client net.Conn, buffered []byte, upstream net.Conn) {
+	defer client.Close()
+	defer upstream.Close()
+
+	done := make(chan struct{}, 2)
+	go func() {
+		if _, err := upstream.Write(buffered); err == nil {
+			io.Copy(upstream, client)
+		}
+		closeWrite(upstream)
+		done <- struct{}{}
+	}()
+	go func() {
+		io.Copy(client, upstream)
+		closeWrite(client)
+		done <- struct{}{}
+	}()
+	<-done
+	<-done
+}
+
+// closeWrite signals the end of one direction while the other keeps going.
+func closeWrite(conn net.Conn) {
+	if cw, ok := conn.(interface{ CloseWrite() error }); ok {
+		cw.CloseWrite()
+	}
+}
+
+// dialChecked connects to address if the lists allow it. A host name is
+// resolved first and refused if any of its addresses is; the checked
+// addresses are then dialed in turn, so the name isn't resolved again.
+func (p *forwardProxy) dialChecked(ctx context.Context, network, address string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	if matchesAny(p.deny, host, port, netip.Addr{}) {
+		return nil, fmt.Errorf("%w: %s", errDestinationDenied, address)
+	}
+
+	addrs, err := resolve(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	for _, addr := range addrs {
+		if !p.permitted(host, port, addr) {
+			return nil, fmt.Errorf("%w: %s (%s)", errDestinationDenied, address, addr)
+		}
+	}
+
+	var errs []error
+	for _, addr := range addrs {
+		conn, err := p.dial(ctx, network, net.JoinHostPort(addr.String(), port))
+		if err == nil {
+			return conn, nil
+		}
+		errs = append(errs, err)
+	}
+	return nil, errors.Join(errs...)
+}
+
+// resolve returns the addresses of host, which may be an IP address itself.
+func resolve(ctx context.Context, host string) ([]netip.Addr, error) {
+	if addr, err := netip.ParseAddr(host); err == nil {
+		return []netip.Addr{addr.Unmap()}, nil
+	}
+	addrs, err := net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+	if err != nil {
+		return nil, err
+	}
+	for i, addr := range addrs {
+		addrs[i] = addr.Unmap()
+	}
+	return addrs, nil
+}
+
+func (p *forwardProxy) permitted(host, port string, addr netip.Addr) bool {
+	if matchesAny(p.deny, host, port, addr) {
+		return false
+	}
+	if isInternal(addr) {
+		// Only an address or block can let these through: with an empty
+		// host, no name pattern matches.
+		return matchesAny(p.allow, "", port, addr)
+	}
+	return len(p.allow) == 0 || matchesAny(p.allow, host, port, addr)
+}
+
+// isInternal reports whether addr belongs to this host or its local network.
+func isInternal(addr netip.Addr) bool {
+	return addr.IsLoopback() || addr.IsPrivate() || addr.IsUnspecified() ||
+		addr.IsLinkLocalUnicast() || addr.IsLinkLocalMulticast() || addr.IsInterfaceLocalMulticast()
+}
+
+type destination struct {
+	host   string
+	prefix netip.Prefix
+	port   string
+}
+
+func parseDestinations(patterns []string) ([]destination, error) {
+	destinations := make([]destination, 0, len(patterns))
+
+	for _, pattern := range patterns {
+		var d destination
+		host := strings.ToLower(strings.TrimSpace(pattern))
+		if strings.HasPrefix(host, "[") || strings.Count(host, ":") == 1 {
+			var err error
+			if host, d.port, err = net.SplitHostPort(host); err != nil {
+				return nil, fmt.Errorf("Error parsing destination %q: %s", pattern, err)
+			}
+		}
+
+		switch {
+		case strings.Contains(host, "/"):
+			prefix, err := netip.ParsePrefix(host)
+			if err != nil {
+				return nil, fmt.Errorf("Error parsing destination %q: %s", pattern, err)
+			}
+			d.prefix = prefix.Masked()
+		default:
+			if addr, err := netip.ParseAddr(host); err == nil {
+				d.prefix = netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen())
+			} else {
+				d.host = host
+			}
+		}
+		destinations = append(destinations, d)
+	}
+
+	return destinations, nil
+}
+
+func (d destination) matches(host, port string, addr netip.Addr) bool {
+	if d.port != "" && d.port != port {
+		return false
+	}
+	if d.prefix.IsValid() {
+		return addr.IsValid() && d.prefix.Contains(addr.Unmap())
+	}
+	if wildcard, ok := strings.CutPrefix(d.host, "*."); ok {
+		return strings.HasSuffix(host, "."+wildcard)
+	}
+	return host == d.host
+}
+
+func matchesAny(destinations []destination, host, port string, addr netip.Addr) bool {
+	for _, d := range destinations {
+		if d.matches(host, port, addr) {
+			return true
+		}
+	}
+	return false
+}
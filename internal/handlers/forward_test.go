@@ -0,0 +1,156 @@
+package handlers
+
+import (
+	"bufio"
+	"fmt"
+	"httpfromtcp/internal/server"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func echoListener(t *testing.T) net.Listener {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return listener
+}
+
+func TestForwardProxy(t *testing.T) {
+	echo := echoListener(t)
+	_, echoPort, _ := net.SplitHostPort(echo.Addr().String())
+
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/moved":
+			w.Header().Set("Set-Cookie", "seen=1")
+			http.Redirect(w, r, "/hello", http.StatusFound)
+		case "/created":
+			w.WriteHeader(http.StatusCreated)
+		default:
+			fmt.Fprintf(w, "origin saw %s %s", r.Method, r.URL.Path)
+		}
+	}))
+	defer origin.Close()
+	_, originPort, _ := net.SplitHostPort(origin.Listener.Addr().String())
+
+	proxy, err := ForwardProxy(ForwardProxyConfig{
+		Allow: []string{"127.0.0.0/8:" + echoPort, "127.0.0.1:" + originPort},
+		Deny:  []string{"localhost"},
+	})
+	require.NoError(t, err)
+	s := server.New(proxy)
+	defer s.Close()
+	addr, err := s.Listen("127.0.0.1:0")
+	require.NoError(t, err)
+
+	// Test: CONNECT opens a tunnel, bytes sent with the request included
+	conn, err := net.Dial("tcp", addr.String())
+	require.NoError(t, err)
+	defer conn.Close()
+	fmt.Fprintf(conn, "CONNECT %s HTTP/1.1\r\nHost: %s\r\n\r\nping\n", echo.Addr(), echo.Addr())
+
+	reader := bufio.NewReader(conn)
+	res, err := http.ReadResponse(reader, &http.Request{Method: "CONNECT"})
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+
+	line, err := reader.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "ping\n", line)
+	fmt.Fprint(conn, "pong\n")
+	line, err = reader.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "pong\n", line)
+
+	// Test: Destinations outside the allow list get 403
+	assert.Equal(t, http.StatusForbidden, proxyStatus(t, addr, "CONNECT 127.0.0.1:1 HTTP/1.1\r\nHost: 127.0.0.1:1\r\n\r\n"))
+
+	// Test: Denied names get 403 even when their address is allowed
+	target := "localhost:" + echoPort
+	assert.Equal(t, http.StatusForbidden, proxyStatus(t, addr, "CONNECT "+target+" HTTP/1.1\r\nHost: "+target+"\r\n\r\n"))
+
+	// Test: CONNECT without a port gets 400
+	assert.Equal(t, http.StatusBadRequest, proxyStatus(t, addr, "CONNECT 127.0.0.1 HTTP/1.1\r\nHost: 127.0.0.1\r\n\r\n"))
+
+	// Test: Absolute-form requests are forwarded
+	conn2, err := net.Dial("tcp", addr.String())
+	require.NoError(t, err)
+	defer conn2.Close()
+	fmt.Fprintf(conn2, "GET %s/hello HTTP/1.1\r\nHost: %s\r\nProxy-Connection: keep-alive\r\n\r\n", origin.URL, origin.Listener.Addr())
+	res, err = http.ReadResponse(bufio.NewReader(conn2), nil)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	body, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "origin saw GET /hello", string(body))
+
+	// Test: Upstream statuses and headers are passed on and redirects not followed
+	for path, status := range map[string]int{"/moved": http.StatusFound, "/created": http.StatusCreated} {
+		conn, err := net.Dial("tcp", addr.String())
+		require.NoError(t, err)
+		defer conn.Close()
+		fmt.Fprintf(conn, "GET %s%s HTTP/1.1\r\nHost: %s\r\n\r\n", origin.URL, path, origin.Listener.Addr())
+		res, err := http.ReadResponse(bufio.NewReader(conn), nil)
+		require.NoError(t, err)
+		assert.Equal(t, status, res.StatusCode, path)
+		if status == http.StatusFound {
+			assert.Equal(t, "/hello", res.Header.Get("Location"))
+			assert.Equal(t, "seen=1", res.Header.Get("Set-Cookie"))
+		}
+	}
+
+	// Test: Absolute-form requests to other destinations get 403
+	assert.Equal(t, http.StatusForbidden, proxyStatus(t, addr, "GET http://127.0.0.1:1/ HTTP/1.1\r\nHost: 127.0.0.1:1\r\n\r\n"))
+
+	// Test: The scheme of absolute-form targets is case-insensitive
+	target = "HTTP://" + origin.Listener.Addr().String() + "/hello"
+	assert.Equal(t, http.StatusOK, proxyStatus(t, addr, "GET "+target+" HTTP/1.1\r\nHost: "+origin.Listener.Addr().String()+"\r\n\r\n"))
+
+	// Test: Without an allow list, internal addresses are refused
+	open, err := ForwardProxy(ForwardProxyConfig{})
+	require.NoError(t, err)
+	s2 := server.New(open)
+	defer s2.Close()
+	addr2, err := s2.Listen("127.0.0.1:0")
+	require.NoError(t, err)
+	for _, target := range []string{echo.Addr().String(), "localhost:" + echoPort, "169.254.169.254:80", "10.0.0.1:80", "[::1]:" + echoPort} {
+		assert.Equal(t, http.StatusForbidden, proxyStatus(t, addr2, "CONNECT "+target+" HTTP/1.1\r\nHost: "+target+"\r\n\r\n"), target)
+	}
+	target = origin.URL + "/hello"
+	assert.Equal(t, http.StatusForbidden, proxyStatus(t, addr2, "GET "+target+" HTTP/1.1\r\nHost: "+origin.Listener.Addr().String()+"\r\n\r\n"))
+
+	// Test: Invalid patterns are reported
+	_, err = ForwardProxy(ForwardProxyConfig{Deny: []string{"10.0.0.0/99"}})
+	assert.Error(t, err)
+}
+
+func proxyStatus(t *testing.T, addr net.Addr, raw string) int {
+	conn, err := net.Dial("tcp", addr.String())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	_, err = conn.Write([]byte(raw))
+	require.NoError(t, err)
+	res, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	require.NoError(t, err)
+	return res.StatusCode
+}
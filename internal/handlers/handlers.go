@@ -10,6 +10,7 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"slices"
 	"strings"
 )
 
@@ -46,36 +47,92 @@ const banger = `<html>
 </html>
 `
 
-func NewHandler(w *response.Writer, req *request.Request) {
+// route is one of NewHandler's targets with the methods it supports, so
+// dispatch and the Allow header come from the same table.
+type route struct {
+	target string
+	// prefix matches every target starting with target.
+	prefix  bool
+	methods []string
+	handler func(w *response.Writer, req *request.Request)
+}
 
-	switch {
-	case req.RequestLine.RequestTarget == "/yourproblem":
-		handler400(w, req)
-		return
-	case req.RequestLine.RequestTarget == "/myproblem":
-		handler500(w, req)
-		return
-	case req.RequestLine.RequestTarget == "/":
-		handler200(w, req)
-		return
-	case req.RequestLine.RequestTarget == "/video":
-		handlervideo(w, req)
+var routes = []route{
+	{"/yourproblem", false, []string{"GET"}, handler400},
+	{"/myproblem", false, []string{"GET"}, handler500},
+	{"/", false, []string{"GET"}, handler200},
+	{"/video", false, []string{"GET"}, handlervideo},
+	{"/httpbin/", true, []string{"GET"}, handlerbin},
+}
+
+func findRoute(target string) *route {
+	for i, r := range routes {
+		if target == r.target || r.prefix && strings.HasPrefix(target, r.target) {
+			return &routes[i]
+		}
+	}
+	return nil
+}
+
+// allows reports whether the route takes method. HEAD goes wherever GET
+// does.
+func (r *route) allows(method string) bool {
+	return slices.Contains(r.methods, method) || method == "HEAD" && slices.Contains(r.methods, "GET")
+}
+
+// allowHeader lists the route's methods for the Allow header, with HEAD after
+// GET.
+func (r *route) allowHeader() string {
+	methods := make([]string, 0, len(r.methods)+1)
+	for _, method := range r.methods {
+		methods = append(methods, method)
+		if method == "GET" && !slices.Contains(r.methods, "HEAD") {
+			methods = append(methods, "HEAD")
+		}
+	}
+	return strings.Join(methods, ", ")
+}
+
+// NewHandler serves the routes above. A method a route doesn't take gets 405
+// Method Not Allowed with an Allow header listing the ones it does.
+func NewHandler(w *response.Writer, req *request.Request) {
+	r := findRoute(req.RequestLine.RequestTarget)
+	if r == nil {
 		return
-	case strings.HasPrefix(req.RequestLine.RequestTarget, "/httpbin/"):
-		handlerbin(w, req)
+	}
+	if !r.allows(req.RequestLine.Method) {
+		w.Header().Set("Allow", r.allowHeader())
+		writeText(w, response.MethodNotAllowed, "text/plain", []byte("Method Not Allowed"))
 		return
 	}
+	r.handler(w, req)
+}
+
+// NewHandlerMethods returns the methods NewHandler's routes support, or nil
+// for targets it has no route for.
+func NewHandlerMethods(req *request.Request) []string {
+	if r := findRoute(req.RequestLine.RequestTarget); r != nil {
+		return r.methods
+	}
+	return nil
 }
 
 func handlerbin(w *response.Writer, req *request.Request) {
 	targetSuffix := strings.TrimPrefix(req.RequestLine.RequestTarget, "/httpbin/")
 	url := fmt.Sprintf("https://httpbin.org/%s", targetSuffix)
 
-	res, err := http.Get(url)
+	outReq, err := http.NewRequestWithContext(req.Context(), http.MethodGet, url, nil)
+	if err != nil {
+		log.Printf("error creating request for %s: %s", url, err)
+		return
+	}
+
+	res, err := http.DefaultClient.Do(outReq)
 	if err != nil {
 		log.Printf("error retrieving data from %s", url)
 		return
 	}
+	defer res.Body.Close()
 
 	err = w.WriteStatusLine(response.OK)
 	if err != nil {
@@ -102,7 +159,7 @@ func handlerbin(w *response.Writer, req *request.Request) {
 
 		if err != nil {
 			if !errors.Is(err, io.EOF) {
-				log.Printf("error reading data from body")
+				log.Printf("error reading data from body: %s", err)
 				return
 			}
 		}
@@ -0,0 +1,35 @@
+package handlers
+
+import (
+	"httpfromtcp/internal/server"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewHandler(t *testing.T) {
+	s := server.New(NewHandler)
+	defer s.Close()
+	addr, err := s.Listen("127.0.0.1:0")
+	require.NoError(t, err)
+
+	// Test: Methods a route takes are served
+	res, body := proxyRequest(t, addr, "GET", "/")
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Contains(t, body, "Success!")
+
+	// Test: HEAD goes wherever GET does
+	res, _ = proxyRequest(t, addr, "HEAD", "/")
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+
+	// Test: Other methods get 405 with the methods the route takes
+	res, _ = proxyRequest(t, addr, "POST", "/")
+	assert.Equal(t, http.StatusMethodNotAllowed, res.StatusCode)
+	assert.Equal(t, "GET, HEAD", res.Header.Get("Allow"))
+
+	res, _ = proxyRequest(t, addr, "DELETE", "/httpbin/anything")
+	assert.Equal(t, http.StatusMethodNotAllowed, res.StatusCode)
+	assert.Equal(t, "GET, HEAD", res.Header.Get("Allow"))
+}
@@ -0,0 +1,154 @@
+package handlers
+
+import (
+	"bytes"
+	"errors"
+	"httpfromtcp/internal/headers"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Proxy forwards requests for targets starting with prefix to target, with
+// the prefix replaced, and passes the upstream status and headers back,
+// streaming the body chunked.
+func Proxy(prefix, target string) func(w *response.Writer, req *request.Request) {
+	target = strings.TrimSuffix(target, "/")
+
+	return func(w *response.Writer, req *request.Request) {
+		url := target + "/" + strings.TrimPrefix(strings.TrimPrefix(req.RequestLine.RequestTarget, prefix), "/")
+		forward(w, req, proxyClient, url)
+	}
+}
+
+// proxyClient is http.DefaultClient without following redirects, which are
+// the client's business.
+var proxyClient = &http.Client{
+	CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+// hopByHop lists the headers that describe a single connection and are never
+// passed on by a proxy, plus content-length, which the client recomputes.
+var hopByHop = map[string]bool{
+	"host":                true,
+	"connection":          true,
+	"content-length":      true,
+	"keep-alive":          true,
+	"proxy-connection":    true,
+	"proxy-authorization": true,
+	"te":                  true,
+	"trailer":             true,
+	"transfer-encoding":   true,
+	"upgrade":             true,
+}
+
+// connectionHeaders returns the headers a Connection header names, which are
+// hop-by-hop as well.
+func connectionHeaders(connection string) map[string]bool {
+	named := map[string]bool{}
+	for name := range strings.SplitSeq(connection, ",") {
+		named[strings.ToLower(strings.TrimSpace(name))] = true
+	}
+	return named
+}
+
+// forward sends req to url with client and passes the response back: the
+// status and every header that isn't hop-by-hop as they are, and the body
+// chunked. Responses that have no body, those to HEAD and with a 1xx, 204 or
+// 304 status, are sent without one.
+func forward(w *response.Writer, req *request.Request, client *http.Client, url string) {
+	outReq, err := http.NewRequestWithContext(req.Context(), req.RequestLine.Method, url, bytes.NewReader(req.Body))
+	if err != nil {
+		log.Printf("error creating request for %s: %s", url, err)
+		writeText(w, response.BadGateway, "text/plain", []byte("Bad Gateway"))
+		return
+	}
+	connection, _ := req.Headers.Get("Connection")
+	named := connectionHeaders(connection)
+	for key, value := range req.Headers {
+		if hopByHop[key] || named[key] {
+			continue
+		}
+		outReq.Header.Set(key, value)
+	}
+
+	res, err := client.Do(outReq)
+	if errors.Is(err, errDestinationDenied) {
+		writeText(w, response.Forbidden, "text/plain", []byte("Forbidden"))
+		return
+	}
+	if err != nil {
+		log.Printf("error retrieving data from %s: %s", url, err)
+		writeText(w, response.BadGateway, "text/plain", []byte("Bad Gateway"))
+		return
+	}
+	defer res.Body.Close()
+
+	err = w.WriteStatusLine(response.StatusCode(res.StatusCode))
+	if err != nil {
+		err = w.WriteStatusLine(response.BadGateway)
+	}
+	if err != nil {
+		log.Printf("error sending response status line: %s", err)
+		return
+	}
+
+	h := headers.NewHeaders()
+	named = connectionHeaders(res.Header.Get("Connection"))
+	for key, values := range res.Header {
+		key = strings.ToLower(key)
+		if hopByHop[key] || named[key] {
+			continue
+		}
+		for _, value := range values {
+			h.Set(key, value)
+		}
+	}
+
+	noBody := res.StatusCode < 200 || res.StatusCode == int(response.NoContent) || res.StatusCode == int(response.NotModified)
+	if noBody || req.RequestLine.Method == "HEAD" {
+		if req.RequestLine.Method == "HEAD" && res.ContentLength >= 0 {
+			h.Set("Content-Length", strconv.FormatInt(res.ContentLength, 10))
+		}
+		if err := w.WriteHeaders(h); err != nil {
+			log.Printf("error sending headers: %s", err)
+			return
+		}
+		w.WriteBody(nil)
+		return
+	}
+
+	h.Set("Transfer-Encoding", "chunked")
+	err = w.WriteHeaders(h)
+	if err != nil {
+		log.Printf("error sending headers: %s", err)
+		return
+	}
+
+	body := make([]byte, 1024)
+	for {
+		n, err := res.Body.Read(body)
+		if n > 0 {
+			if _, err := w.WriteChunkedBody(body[:n]); err != nil {
+				log.Printf("error writing body: %s", err)
+				return
+			}
+		}
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				log.Printf("error reading data from body: %s", err)
+				return
+			}
+			break
+		}
+	}
+
+	w.WriteChunkedBodyDone()
+	w.WriteTrailers(response.GetTrailersFromHeader(h))
+}
@@ -0,0 +1,89 @@
+package handlers
+
+import (
+	"bufio"
+	"fmt"
+	"httpfromtcp/internal/server"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProxy(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/login":
+			http.SetCookie(w, &http.Cookie{Name: "session", Value: "abc", Path: "/", Expires: time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)})
+			http.SetCookie(w, &http.Cookie{Name: "theme", Value: "dark"})
+			w.Header().Set("Connection", "X-Hop")
+			w.Header().Set("X-Hop", "drop me")
+			http.Redirect(w, r, "/home", http.StatusFound)
+		case "/created":
+			w.WriteHeader(http.StatusCreated)
+			fmt.Fprint(w, "made")
+		case "/empty":
+			w.Header().Set("ETag", `"v1"`)
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			fmt.Fprintf(w, "origin saw %s %s", r.Method, r.URL.Path)
+		}
+	}))
+	defer origin.Close()
+
+	s := server.New(Proxy("/api", origin.URL))
+	defer s.Close()
+	addr, err := s.Listen("127.0.0.1:0")
+	require.NoError(t, err)
+
+	// Test: Redirects are passed on with Location and every Set-Cookie line
+	res, _ := proxyRequest(t, addr, "GET", "/api/login")
+	assert.Equal(t, http.StatusFound, res.StatusCode)
+	assert.Equal(t, "/home", res.Header.Get("Location"))
+	assert.Equal(t, []string{
+		"session=abc; Path=/; Expires=Wed, 02 Jan 2030 03:04:05 GMT",
+		"theme=dark",
+	}, res.Header.Values("Set-Cookie"))
+	assert.Empty(t, res.Header.Get("X-Hop"))
+
+	// Test: Statuses without a name of their own are passed on
+	res, body := proxyRequest(t, addr, "POST", "/api/created")
+	assert.Equal(t, http.StatusCreated, res.StatusCode)
+	assert.Equal(t, "made", body)
+
+	// Test: 204 responses keep their headers and get no body
+	res, body = proxyRequest(t, addr, "GET", "/api/empty")
+	assert.Equal(t, http.StatusNoContent, res.StatusCode)
+	assert.Equal(t, `"v1"`, res.Header.Get("ETag"))
+	assert.Empty(t, res.TransferEncoding)
+	assert.Empty(t, body)
+
+	// Test: Bodies are streamed back
+	res, body = proxyRequest(t, addr, "GET", "/api/hello")
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, "origin saw GET /hello", body)
+
+	// Test: HEAD gets the upstream Content-Length and no body
+	res, body = proxyRequest(t, addr, "HEAD", "/api/hello")
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, int64(len("origin saw HEAD /hello")), res.ContentLength)
+	assert.Empty(t, body)
+}
+
+func proxyRequest(t *testing.T, addr net.Addr, method, target string) (*http.Response, string) {
+	conn, err := net.Dial("tcp", addr.String())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	fmt.Fprintf(conn, "%s %s HTTP/1.1\r\nHost: %s\r\nContent-Length: 0\r\n\r\n", method, target, addr)
+	res, err := http.ReadResponse(bufio.NewReader(conn), &http.Request{Method: method})
+	require.NoError(t, err)
+	body, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+	return res, string(body)
+}
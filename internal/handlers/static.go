@@ -0,0 +1,64 @@
+package handlers
+
+import (
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Static serves the files below dir for request targets starting with
+// prefix. Directories are served through their index.html.
+func Static(prefix, dir string) func(w *response.Writer, req *request.Request) {
+	return func(w *response.Writer, req *request.Request) {
+		target, _, _ := strings.Cut(req.RequestLine.RequestTarget, "?")
+		name := path.Clean("/" + strings.TrimPrefix(target, prefix))
+
+		file := filepath.Join(dir, filepath.FromSlash(name))
+		if info, err := os.Stat(file); err == nil && info.IsDir() {
+			file = filepath.Join(file, "index.html")
+		}
+
+		body, err := os.ReadFile(file)
+		if err != nil {
+			writeText(w, response.NotFound, "text/plain", []byte("Not Found"))
+			return
+		}
+
+		contentType := mime.TypeByExtension(filepath.Ext(file))
+		if contentType == "" {
+			contentType = http.DetectContentType(body)
+		}
+
+		writeText(w, response.OK, contentType, body)
+	}
+}
+
+// writeText writes a complete response with a fixed-length body.
+func writeText(w *response.Writer, status response.StatusCode, contentType string, body []byte) {
+	err := w.WriteStatusLine(status)
+	if err != nil {
+		log.Printf("error sending response status line: %s", err)
+		return
+	}
+
+	headers := response.GetDefaultHeaders(len(body))
+	headers.Update("content-type", contentType)
+
+	err = w.WriteHeaders(headers)
+	if err != nil {
+		log.Printf("error sending headers: %s", err)
+		return
+	}
+
+	_, err = w.WriteBody(body)
+	if err != nil {
+		log.Printf("error writing body: %s", err)
+		return
+	}
+}
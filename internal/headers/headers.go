@@ -45,6 +45,9 @@ func headerFromString(str string) (string, string, error) {
 	}
 
 	key := strings.TrimLeft(fieldLineParts[0], " ")
+	if key == "" {
+		return "", "", fmt.Errorf("Header key cannot be empty: %s", str)
+	}
 
 	if last := key[len(key)-1:]; last == " " || last == "\t" {
 		return "", "", fmt.Errorf("Header key cannot have whitespace before ':' : %s", key)
@@ -64,6 +67,9 @@ func headerFromString(str string) (string, string, error) {
 	}
 
 	value := strings.Trim(fieldLineParts[1], " ")
+	if strings.ContainsAny(value, "\r\n") {
+		return "", "", fmt.Errorf("Header value contains CR or LF: %q", value)
+	}
 
 	return key, value, nil
 }
@@ -75,14 +81,45 @@ func (h Headers) Get(key string) (string, bool) {
 	return value, exists
 }
 
+// separators lists the headers whose repeated values are not joined with a
+// comma. Cookie pairs are joined the way a single Cookie header lists them.
+// Set-Cookie values contain commas in their dates and can't be folded at all,
+// so they are kept apart by a newline, which never occurs in a header value,
+// and written out as separate lines.
+var separators = map[string]string{
+	"cookie":     "; ",
+	"set-cookie": "\n",
+}
+
 func (h Headers) Set(key, value string) {
-	key = strings.ToLower(key)
+	h.SetValues(key, []string{value})
+}
 
+// SetValues adds several values to a header at once, joined as repeated Set
+// calls would join them, without building the combined value for each one.
+func (h Headers) SetValues(key string, values []string) {
+	key = strings.ToLower(key)
+	separator, ok := separators[key]
+	if !ok {
+		separator = ", "
+	}
 	if current, exists := h[key]; exists {
-		h[key] = fmt.Sprintf("%s, %s", current, value)
-	} else {
-		h[key] = value
+		values = append([]string{current}, values...)
+	}
+	h[key] = strings.Join(values, separator)
+}
+
+// Values returns the lines a header is written as: one per Set-Cookie value,
+// and a single one with the combined value for any other header.
+func (h Headers) Values(key string) []string {
+	value, ok := h.Get(key)
+	if !ok {
+		return nil
+	}
+	if strings.ToLower(key) != "set-cookie" {
+		return []string{value}
 	}
+	return strings.Split(value, "\n")
 }
 
 func (h Headers) Delete(key string) {
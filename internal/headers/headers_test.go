@@ -73,6 +73,13 @@ func TestHeaders(t *testing.T) {
 	assert.Equal(t, 0, n)
 	assert.False(t, done)
 
+	// Test: Bare CR or LF in a value
+	for _, line := range []string{"X-Test: a\nSet-Cookie: b=1\r\n\r\n", "X-Test: a\rb\r\n\r\n"} {
+		headers = NewHeaders()
+		_, _, err = headers.Parse([]byte(line))
+		require.Error(t, err, line)
+	}
+
 	// Test: Valid header with multiple occurences
 	headers = NewHeaders()
 	data = []byte("Set-Person: lane loves go\r\n")
@@ -98,4 +105,27 @@ func TestHeaders(t *testing.T) {
 	assert.Equal(t, "lane loves go, prime loves zig, tj loves ocaml", headers["set-person"])
 	assert.Equal(t, 28, n)
 	assert.False(t, done)
+
+	// Test: Cookie pairs are joined with semicolons
+	headers = NewHeaders()
+	headers.Set("Cookie", "a=1")
+	headers.Set("Cookie", "b=2")
+	assert.Equal(t, "a=1; b=2", headers["cookie"])
+	assert.Equal(t, []string{"a=1; b=2"}, headers.Values("Cookie"))
+
+	// Test: Set-Cookie values stay separate lines
+	headers.Set("Set-Cookie", "a=1; Expires=Wed, 21 Oct 2015 07:28:00 GMT")
+	headers.Set("Set-Cookie", "b=2")
+	assert.Equal(t, []string{"a=1; Expires=Wed, 21 Oct 2015 07:28:00 GMT", "b=2"}, headers.Values("set-cookie"))
+	assert.Nil(t, headers.Values("Missing"))
+
+	// Test: Only Set-Cookie is split into lines
+	headers.Update("X-Test", "a\nb")
+	assert.Equal(t, []string{"a\nb"}, headers.Values("X-Test"))
+
+	// Test: SetValues joins like repeated Set calls
+	headers.SetValues("Cookie", []string{"c=3", "d=4"})
+	assert.Equal(t, "a=1; b=2; c=3; d=4", headers["cookie"])
+	headers.SetValues("Accept", []string{"text/html", "*/*"})
+	assert.Equal(t, "text/html, */*", headers["accept"])
 }
@@ -0,0 +1,185 @@
+package http2
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// ClientPreface is what every HTTP/2 client sends before its first frame.
+const ClientPreface = "PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n"
+
+type FrameType uint8
+
+const (
+	FrameData         FrameType = 0x0
+	FrameHeaders      FrameType = 0x1
+	FramePriority     FrameType = 0x2
+	FrameRSTStream    FrameType = 0x3
+	FrameSettings     FrameType = 0x4
+	FramePushPromise  FrameType = 0x5
+	FramePing         FrameType = 0x6
+	FrameGoAway       FrameType = 0x7
+	FrameWindowUpdate FrameType = 0x8
+	FrameContinuation FrameType = 0x9
+)
+
+// Frame flags; which ones apply depends on the frame type.
+const (
+	FlagEndStream  = 0x1
+	FlagAck        = 0x1
+	FlagEndHeaders = 0x4
+	FlagPadded     = 0x8
+	FlagPriority   = 0x20
+)
+
+type SettingID uint16
+
+const (
+	SettingHeaderTableSize      SettingID = 0x1
+	SettingEnablePush           SettingID = 0x2
+	SettingMaxConcurrentStreams SettingID = 0x3
+	SettingInitialWindowSize    SettingID = 0x4
+	SettingMaxFrameSize         SettingID = 0x5
+	SettingMaxHeaderListSize    SettingID = 0x6
+)
+
+type ErrorCode uint32
+
+const (
+	ErrCodeNo                 ErrorCode = 0x0
+	ErrCodeProtocol           ErrorCode = 0x1
+	ErrCodeInternal           ErrorCode = 0x2
+	ErrCodeFlowControl        ErrorCode = 0x3
+	ErrCodeSettingsTimeout    ErrorCode = 0x4
+	ErrCodeStreamClosed       ErrorCode = 0x5
+	ErrCodeFrameSize          ErrorCode = 0x6
+	ErrCodeRefusedStream      ErrorCode = 0x7
+	ErrCodeCancel             ErrorCode = 0x8
+	ErrCodeCompression        ErrorCode = 0x9
+	ErrCodeConnect            ErrorCode = 0xa
+	ErrCodeEnhanceYourCalm    ErrorCode = 0xb
+	ErrCodeInadequateSecurity ErrorCode = 0xc
+	ErrCodeHTTP11Required     ErrorCode = 0xd
+)
+
+const (
+	frameHeaderLen     = 9
+	defaultMaxFrame    = 16384
+	maxAllowedFrame    = 1<<24 - 1
+	defaultWindowSize  = 65535
+	maxWindowSize      = 1<<31 - 1
+	defaultHeaderTable = 4096
+)
+
+// ConnError is a connection error: the connection is closed with GOAWAY
+// carrying Code.
+type ConnError struct {
+	Code   ErrorCode
+	Reason string
+}
+
+func (e ConnError) Error() string {
+	return fmt.Sprintf("http2: connection error %d: %s", e.Code, e.Reason)
+}
+
+// StreamError only ends one stream, with RST_STREAM carrying Code.
+type StreamError struct {
+	StreamID uint32
+	Code     ErrorCode
+	Reason   string
+}
+
+func (e StreamError) Error() string {
+	return fmt.Sprintf("http2: stream %d error %d: %s", e.StreamID, e.Code, e.Reason)
+}
+
+type Frame struct {
+	Type     FrameType
+	Flags    uint8
+	StreamID uint32
+	Payload  []byte
+}
+
+func (f *Frame) Has(flag uint8) bool {
+	return f.Flags&flag != 0
+}
+
+// ReadFrame reads one frame, rejecting payloads over maxSize.
+func ReadFrame(r io.Reader, maxSize uint32) (*Frame, error) {
+	var header [frameHeaderLen]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+
+	length := uint32(header[0])<<16 | uint32(header[1])<<8 | uint32(header[2])
+	f := &Frame{
+		Type:     FrameType(header[3]),
+		Flags:    header[4],
+		StreamID: binary.BigEndian.Uint32(header[5:]) & maxWindowSize,
+	}
+	if length > maxSize {
+		return nil, ConnError{ErrCodeFrameSize, fmt.Sprintf("frame of %d bytes over limit %d", length, maxSize)}
+	}
+
+	f.Payload = make([]byte, length)
+	if _, err := io.ReadFull(r, f.Payload); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// WriteFrame writes a frame with a single Write call.
+func WriteFrame(w io.Writer, typ FrameType, flags uint8, streamID uint32, payload []byte) error {
+	buf := make([]byte, frameHeaderLen, frameHeaderLen+len(payload))
+	buf[0] = byte(len(payload) >> 16)
+	buf[1] = byte(len(payload) >> 8)
+	buf[2] = byte(len(payload))
+	buf[3] = byte(typ)
+	buf[4] = flags
+	binary.BigEndian.PutUint32(buf[5:], streamID&maxWindowSize)
+	buf = append(buf, payload...)
+
+	_, err := w.Write(buf)
+	return err
+}
+
+// stripPadding removes the pad length octet and padding of a PADDED frame.
+func stripPadding(f *Frame) ([]byte, error) {
+	payload := f.Payload
+	if !f.Has(FlagPadded) {
+		return payload, nil
+	}
+	if len(payload) == 0 || int(payload[0]) >= len(payload) {
+		return nil, ConnError{ErrCodeProtocol, "padding longer than payload"}
+	}
+	return payload[1 : len(payload)-int(payload[0])], nil
+}
+
+type Setting struct {
+	ID    SettingID
+	Value uint32
+}
+
+func encodeSettings(settings []Setting) []byte {
+	payload := make([]byte, 0, 6*len(settings))
+	for _, s := range settings {
+		payload = binary.BigEndian.AppendUint16(payload, uint16(s.ID))
+		payload = binary.BigEndian.AppendUint32(payload, s.Value)
+	}
+	return payload
+}
+
+func decodeSettings(payload []byte) ([]Setting, error) {
+	if len(payload)%6 != 0 {
+		return nil, ConnError{ErrCodeFrameSize, "SETTINGS length not a multiple of 6"}
+	}
+	settings := make([]Setting, 0, len(payload)/6)
+	for i := 0; i < len(payload); i += 6 {
+		settings = append(settings, Setting{
+			ID:    SettingID(binary.BigEndian.Uint16(payload[i:])),
+			Value: binary.BigEndian.Uint32(payload[i+2:]),
+		})
+	}
+	return settings, nil
+}
@@ -0,0 +1,447 @@
+package http2
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// HeaderField is a single header as carried in an HPACK header block. Names
+// of pseudo-headers start with a colon.
+type HeaderField struct {
+	Name, Value string
+	// Sensitive fields are sent as never-indexed literals.
+	Sensitive bool
+}
+
+func (f HeaderField) size() int {
+	return len(f.Name) + len(f.Value) + 32
+}
+
+var (
+	errHeaderBlock    = errors.New("malformed header block")
+	errHeaderListSize = errors.New("header list too large")
+)
+
+// staticTable is RFC 7541 Appendix A; index 1 is staticTable[0].
+var staticTable = [...]HeaderField{
+	{Name: ":authority"},
+	{Name: ":method", Value: "GET"},
+	{Name: ":method", Value: "POST"},
+	{Name: ":path", Value: "/"},
+	{Name: ":path", Value: "/index.html"},
+	{Name: ":scheme", Value: "http"},
+	{Name: ":scheme", Value: "https"},
+	{Name: ":status", Value: "200"},
+	{Name: ":status", Value: "204"},
+	{Name: ":status", Value: "206"},
+	{Name: ":status", Value: "304"},
+	{Name: ":status", Value: "400"},
+	{Name: ":status", Value: "404"},
+	{Name: ":status", Value: "500"},
+	{Name: "accept-charset"},
+	{Name: "accept-encoding", Value: "gzip, deflate"},
+	{Name: "accept-language"},
+	{Name: "accept-ranges"},
+	{Name: "accept"},
+	{Name: "access-control-allow-origin"},
+	{Name: "age"},
+	{Name: "allow"},
+	{Name: "authorization"},
+	{Name: "cache-control"},
+	{Name: "content-disposition"},
+	{Name: "content-encoding"},
+	{Name: "content-language"},
+	{Name: "content-length"},
+	{Name: "content-location"},
+	{Name: "content-range"},
+	{Name: "content-type"},
+	{Name: "cookie"},
+	{Name: "date"},
+	{Name: "etag"},
+	{Name: "expect"},
+	{Name: "expires"},
+	{Name: "from"},
+	{Name: "host"},
+	{Name: "if-match"},
+	{Name: "if-modified-since"},
+	{Name: "if-none-match"},
+	{Name: "if-range"},
+	{Name: "if-unmodified-since"},
+	{Name: "last-modified"},
+	{Name: "link"},
+	{Name: "location"},
+	{Name: "max-forwards"},
+	{Name: "proxy-authenticate"},
+	{Name: "proxy-authorization"},
+	{Name: "range"},
+	{Name: "referer"},
+	{Name: "refresh"},
+	{Name: "retry-after"},
+	{Name: "server"},
+	{Name: "set-cookie"},
+	{Name: "strict-transport-security"},
+	{Name: "transfer-encoding"},
+	{Name: "user-agent"},
+	{Name: "vary"},
+	{Name: "via"},
+	{Name: "www-authenticate"},
+}
+
+// dynamicTable holds the fields added by literals with incremental indexing,
+// newest first.
+type dynamicTable struct {
+	entries []HeaderField
+	size    int
+	maxSize int
+}
+
+func (t *dynamicTable) add(f HeaderField) {
+	t.entries = append([]HeaderField{f}, t.entries...)
+	t.size += f.size()
+	t.evict()
+}
+
+func (t *dynamicTable) setMaxSize(n int) {
+	t.maxSize = n
+	t.evict()
+}
+
+func (t *dynamicTable) evict() {
+	for t.size > t.maxSize && len(t.entries) > 0 {
+		last := t.entries[len(t.entries)-1]
+		t.entries = t.entries[:len(t.entries)-1]
+		t.size -= last.size()
+	}
+}
+
+// Decoder turns header blocks back into fields. It keeps the dynamic table
+// between blocks, so one Decoder serves one connection.
+type Decoder struct {
+	// MaxListSize caps the size of a decoded block, counted as in
+	// SETTINGS_MAX_HEADER_LIST_SIZE; zero means no limit.
+	MaxListSize int
+
+	table dynamicTable
+	// limit is the largest table size the peer may ask for, i.e. the
+	// SETTINGS_HEADER_TABLE_SIZE we advertised.
+	limit int
+}
+
+func NewDecoder(maxTableSize int) *Decoder {
+	return &Decoder{
+		table: dynamicTable{maxSize: maxTableSize},
+		limit: maxTableSize,
+	}
+}
+
+// Decode decodes a complete header block. A block over MaxListSize is still
+// decoded to the end to keep the dynamic table in step, but its fields are
+// dropped and errHeaderListSize is returned.
+func (d *Decoder) Decode(block []byte) ([]HeaderField, error) {
+	var fields []HeaderField
+	listSize := 0
+	emit := func(f HeaderField) {
+		listSize += f.size()
+		if d.MaxListSize > 0 && listSize > d.MaxListSize {
+			fields = nil
+			return
+		}
+		fields = append(fields, f)
+	}
+
+	for len(block) > 0 {
+		b := block[0]
+		switch {
+		case b&0x80 != 0:
+			index, rest, err := readInt(block, 7)
+			if err != nil {
+				return nil, err
+			}
+			f, err := d.field(index)
+			if err != nil {
+				return nil, err
+			}
+			emit(f)
+			block = rest
+
+		case b&0xc0 == 0x40:
+			f, rest, err := d.literal(block, 6)
+			if err != nil {
+				return nil, err
+			}
+			d.table.add(f)
+			emit(f)
+			block = rest
+
+		case b&0xe0 == 0x20:
+			if listSize > 0 {
+				return nil, fmt.Errorf("%w: table size update after a field", errHeaderBlock)
+			}
+			size, rest, err := readInt(block, 5)
+			if err != nil {
+				return nil, err
+			}
+			if size > uint64(d.limit) {
+				return nil, fmt.Errorf("%w: table size %d over limit %d", errHeaderBlock, size, d.limit)
+			}
+			d.table.setMaxSize(int(size))
+			block = rest
+
+		default:
+			// Literal without indexing (0000) or never indexed (0001).
+			f, rest, err := d.literal(block, 4)
+			if err != nil {
+				return nil, err
+			}
+			f.Sensitive = b&0x10 != 0
+			emit(f)
+			block = rest
+		}
+	}
+
+	if d.MaxListSize > 0 && listSize > d.MaxListSize {
+		return nil, fmt.Errorf("%w: over %d bytes", errHeaderListSize, d.MaxListSize)
+	}
+	return fields, nil
+}
+
+func (d *Decoder) field(index uint64) (HeaderField, error) {
+	switch {
+	case index == 0:
+		return HeaderField{}, fmt.Errorf("%w: index 0", errHeaderBlock)
+	case index <= uint64(len(staticTable)):
+		return staticTable[index-1], nil
+	case index-uint64(len(staticTable)) <= uint64(len(d.table.entries)):
+		return d.table.entries[index-uint64(len(staticTable))-1], nil
+	default:
+		return HeaderField{}, fmt.Errorf("%w: index %d out of range", errHeaderBlock, index)
+	}
+}
+
+func (d *Decoder) literal(block []byte, prefix int) (HeaderField, []byte, error) {
+	index, rest, err := readInt(block, prefix)
+	if err != nil {
+		return HeaderField{}, nil, err
+	}
+
+	var f HeaderField
+	if index > 0 {
+		named, err := d.field(index)
+		if err != nil {
+			return HeaderField{}, nil, err
+		}
+		f.Name = named.Name
+	} else {
+		if f.Name, rest, err = readString(rest); err != nil {
+			return HeaderField{}, nil, err
+		}
+	}
+
+	if f.Value, rest, err = readString(rest); err != nil {
+		return HeaderField{}, nil, err
+	}
+	return f, rest, nil
+}
+
+// readInt decodes an integer whose first octet has prefix usable bits.
+func readInt(block []byte, prefix int) (uint64, []byte, error) {
+	if len(block) == 0 {
+		return 0, nil, fmt.Errorf("%w: truncated integer", errHeaderBlock)
+	}
+
+	mask := uint64(1)<<prefix - 1
+	value := uint64(block[0]) & mask
+	block = block[1:]
+	if value < mask {
+		return value, block, nil
+	}
+
+	for shift := 0; ; shift += 7 {
+		if len(block) == 0 || shift > 56 {
+			return 0, nil, fmt.Errorf("%w: truncated or oversized integer", errHeaderBlock)
+		}
+		b := block[0]
+		block = block[1:]
+		value += uint64(b&0x7f) << shift
+		if b&0x80 == 0 {
+			return value, block, nil
+		}
+	}
+}
+
+func readString(block []byte) (string, []byte, error) {
+	if len(block) == 0 {
+		return "", nil, fmt.Errorf("%w: truncated string", errHeaderBlock)
+	}
+	huffman := block[0]&0x80 != 0
+
+	length, rest, err := readInt(block, 7)
+	if err != nil {
+		return "", nil, err
+	}
+	if uint64(len(rest)) < length {
+		return "", nil, fmt.Errorf("%w: truncated string", errHeaderBlock)
+	}
+
+	data, rest := rest[:length], rest[length:]
+	if !huffman {
+		return string(data), rest, nil
+	}
+	decoded, err := huffmanDecode(data)
+	if err != nil {
+		return "", nil, err
+	}
+	return decoded, rest, nil
+}
+
+// Encoder writes header blocks. It never adds to the dynamic table, so
+// blocks don't depend on each other and can be encoded in any order.
+type Encoder struct{}
+
+// Encode appends the header block for fields to dst.
+func (Encoder) Encode(dst []byte, fields []HeaderField) []byte {
+	for _, f := range fields {
+		nameIndex := 0
+		fullIndex := 0
+		for i, s := range staticTable {
+			if s.Name != f.Name {
+				continue
+			}
+			if nameIndex == 0 {
+				nameIndex = i + 1
+			}
+			if s.Value == f.Value && !f.Sensitive {
+				fullIndex = i + 1
+				break
+			}
+		}
+
+		if fullIndex > 0 {
+			dst = appendInt(dst, 0x80, 7, uint64(fullIndex))
+			continue
+		}
+
+		first := byte(0x00)
+		if f.Sensitive {
+			first = 0x10
+		}
+		dst = appendInt(dst, first, 4, uint64(nameIndex))
+		if nameIndex == 0 {
+			dst = appendString(dst, f.Name)
+		}
+		dst = appendString(dst, f.Value)
+	}
+	return dst
+}
+
+func appendInt(dst []byte, first byte, prefix int, value uint64) []byte {
+	mask := uint64(1)<<prefix - 1
+	if value < mask {
+		return append(dst, first|byte(value))
+	}
+
+	dst = append(dst, first|byte(mask))
+	value -= mask
+	for value >= 0x80 {
+		dst = append(dst, byte(value&0x7f)|0x80)
+		value >>= 7
+	}
+	return append(dst, byte(value))
+}
+
+// appendString uses the Huffman code when it is shorter than the raw string.
+func appendString(dst []byte, s string) []byte {
+	if n := huffmanLength(s); n < len(s) {
+		dst = appendInt(dst, 0x80, 7, uint64(n))
+		return huffmanEncode(dst, s)
+	}
+	dst = appendInt(dst, 0x00, 7, uint64(len(s)))
+	return append(dst, s...)
+}
+
+func huffmanLength(s string) int {
+	bits := 0
+	for i := 0; i < len(s); i++ {
+		bits += int(huffmanCodes[s[i]].length)
+	}
+	return (bits + 7) / 8
+}
+
+func huffmanEncode(dst []byte, s string) []byte {
+	var acc uint64
+	bits := 0
+	for i := 0; i < len(s); i++ {
+		code := huffmanCodes[s[i]]
+		acc = acc<<code.length | uint64(code.code)
+		bits += int(code.length)
+		for bits >= 8 {
+			bits -= 8
+			dst = append(dst, byte(acc>>bits))
+		}
+	}
+	if bits > 0 {
+		// Pad with the most significant bits of EOS, which are all ones.
+		dst = append(dst, byte(acc<<(8-bits))|byte(0xff>>bits))
+	}
+	return dst
+}
+
+// huffmanNode is a node of the decoding tree; leaves have no children.
+type huffmanNode struct {
+	children [2]*huffmanNode
+	symbol   int
+}
+
+var huffmanRoot = buildHuffmanTree()
+
+func buildHuffmanTree() *huffmanNode {
+	root := &huffmanNode{}
+	for symbol, code := range huffmanCodes {
+		node := root
+		for i := int(code.length) - 1; i >= 0; i-- {
+			bit := (code.code >> i) & 1
+			if node.children[bit] == nil {
+				node.children[bit] = &huffmanNode{}
+			}
+			node = node.children[bit]
+		}
+		node.symbol = symbol
+	}
+	return root
+}
+
+func huffmanDecode(data []byte) (string, error) {
+	var b strings.Builder
+	node := huffmanRoot
+	depth := 0
+	allOnes := true
+
+	for _, octet := range data {
+		for i := 7; i >= 0; i-- {
+			bit := (octet >> i) & 1
+			node = node.children[bit]
+			if node == nil {
+				return "", fmt.Errorf("%w: invalid Huffman code", errHeaderBlock)
+			}
+			depth++
+			allOnes = allOnes && bit == 1
+
+			if node.children[0] == nil && node.children[1] == nil {
+				if node.symbol == 256 {
+					return "", fmt.Errorf("%w: EOS in Huffman string", errHeaderBlock)
+				}
+				b.WriteByte(byte(node.symbol))
+				node = huffmanRoot
+				depth = 0
+				allOnes = true
+			}
+		}
+	}
+
+	// Whatever is left must be padding: fewer than 8 bits of EOS.
+	if depth > 7 || !allOnes {
+		return "", fmt.Errorf("%w: invalid Huffman padding", errHeaderBlock)
+	}
+	return b.String(), nil
+}
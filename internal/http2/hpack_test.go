@@ -0,0 +1,96 @@
+package http2
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func decodeHex(t *testing.T, s string) []byte {
+	b, err := hex.DecodeString(strings.ReplaceAll(s, " ", ""))
+	require.NoError(t, err)
+	return b
+}
+
+func TestHPACK(t *testing.T) {
+	// Test: RFC 7541 C.4, requests with Huffman coding sharing a dynamic table
+	d := NewDecoder(defaultHeaderTable)
+	fields, err := d.Decode(decodeHex(t, "8286 8441 8cf1 e3c2 e5f2 3a6b a0ab 90f4 ff"))
+	require.NoError(t, err)
+	assert.Equal(t, []HeaderField{
+		{Name: ":method", Value: "GET"},
+		{Name: ":scheme", Value: "http"},
+		{Name: ":path", Value: "/"},
+		{Name: ":authority", Value: "www.example.com"},
+	}, fields)
+
+	fields, err = d.Decode(decodeHex(t, "8286 84be 5886 a8eb 1064 9cbf"))
+	require.NoError(t, err)
+	assert.Equal(t, []HeaderField{
+		{Name: ":method", Value: "GET"},
+		{Name: ":scheme", Value: "http"},
+		{Name: ":path", Value: "/"},
+		{Name: ":authority", Value: "www.example.com"},
+		{Name: "cache-control", Value: "no-cache"},
+	}, fields)
+
+	fields, err = d.Decode(decodeHex(t, "8287 85bf 4088 25a8 49e9 5ba9 7d7f 8925 a849 e95b b8e8 b4bf"))
+	require.NoError(t, err)
+	assert.Equal(t, []HeaderField{
+		{Name: ":method", Value: "GET"},
+		{Name: ":scheme", Value: "https"},
+		{Name: ":path", Value: "/index.html"},
+		{Name: ":authority", Value: "www.example.com"},
+		{Name: "custom-key", Value: "custom-value"},
+	}, fields)
+	assert.Equal(t, 164, d.table.size)
+
+	// Test: Huffman encoding matches the RFC
+	assert.Equal(t, decodeHex(t, "f1e3 c2e5 f23a 6ba0 ab90 f4ff"), huffmanEncode(nil, "www.example.com"))
+
+	// Test: Encoded blocks decode to the same fields
+	in := []HeaderField{
+		{Name: ":status", Value: "200"},
+		{Name: "content-type", Value: "text/plain"},
+		{Name: "x-long", Value: strings.Repeat("abc", 100)},
+		{Name: "authorization", Value: "secret", Sensitive: true},
+		{Name: "x-bytes", Value: "\x00\xff~"},
+	}
+	block := Encoder{}.Encode(nil, in)
+	out, err := NewDecoder(defaultHeaderTable).Decode(block)
+	require.NoError(t, err)
+	assert.Equal(t, in, out)
+
+	// Test: Malformed blocks are rejected
+	for _, block := range []string{
+		"80",       // index 0
+		"ff00",     // index out of range
+		"0f",       // truncated integer
+		"0085f2b2", // truncated string
+		"0081ff",   // Huffman padding over 7 bits
+		"3fe21f",   // table size over the limit
+		"8220",     // table size update after a field
+	} {
+		_, err := NewDecoder(defaultHeaderTable).Decode(decodeHex(t, block))
+		assert.ErrorIs(t, err, errHeaderBlock, block)
+	}
+
+	// Test: Indexed references count towards MaxListSize
+	d = NewDecoder(defaultHeaderTable)
+	d.MaxListSize = 1000
+	big := HeaderField{Name: "x-big", Value: strings.Repeat("a", 100)}
+	block = appendString(appendString([]byte{0x40}, big.Name), big.Value)
+	for range 10 {
+		block = append(block, 0xbe)
+	}
+	_, err = d.Decode(block)
+	assert.ErrorIs(t, err, errHeaderListSize)
+
+	// Test: The dynamic table stays in step after a block over the limit
+	fields, err = d.Decode([]byte{0xbe})
+	require.NoError(t, err)
+	assert.Equal(t, []HeaderField{big}, fields)
+}
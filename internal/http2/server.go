@@ -0,0 +1,788 @@
+// Package http2 serves HTTP/2 (RFC 9113) connections, mapping every stream
+// onto the same handler signature the HTTP/1.1 server uses.
+package http2
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultMaxConcurrentStreams = 100
+	defaultMaxRequestBytes      = 10 << 20
+	defaultMaxBufferedBytes     = 32 << 20
+	maxHeaderBlock              = 1 << 20
+	maxHeaderList               = 1 << 20
+)
+
+// aLongTimeAgo is a non-zero time in the past, used to unblock a pending read.
+var aLongTimeAgo = time.Unix(1, 0)
+
+var (
+	errStreamClosed = errors.New("http2: stream closed")
+	errConnClosed   = errors.New("http2: connection closed")
+)
+
+type Handler func(w *response.Writer, req *request.Request)
+
+type Server struct {
+	Handler Handler
+	// MaxConcurrentStreams defaults to 100. Reset streams count until their
+	// handlers return.
+	MaxConcurrentStreams uint32
+	// MaxRequestBytes caps a request body; larger requests get 413. Zero
+	// means 10 MiB.
+	MaxRequestBytes int64
+	// MaxBufferedBytes caps the request body bytes a connection holds for
+	// all its streams until their handlers return; the stream that goes over
+	// gets 413. Zero means 32 MiB.
+	MaxBufferedBytes int64
+}
+
+// IsUpgrade reports whether req asks to switch to HTTP/2 with Upgrade: h2c
+// and carries valid HTTP2-Settings.
+func IsUpgrade(req *request.Request) bool {
+	upgrade, _ := req.Headers.Get("Upgrade")
+	connection, _ := req.Headers.Get("Connection")
+	encoded, ok := req.Headers.Get("HTTP2-Settings")
+	if !ok || !strings.EqualFold(strings.TrimSpace(upgrade), "h2c") {
+		return false
+	}
+	if !strings.Contains(strings.ToLower(connection), "upgrade") || !strings.Contains(strings.ToLower(connection), "http2-settings") {
+		return false
+	}
+	_, err := upgradeSettings(encoded)
+	return err == nil
+}
+
+func upgradeSettings(encoded string) ([]Setting, error) {
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(strings.TrimSpace(encoded), "="))
+	if err != nil {
+		return nil, err
+	}
+	return decodeSettings(payload)
+}
+
+// ServeConn speaks HTTP/2 on conn until the client goes away, a connection
+// error occurs or ctx is cancelled, which sends GOAWAY and lets open streams
+// finish. Frames are read from and written to rw, which carries conn's bytes.
+// For a connection upgraded from HTTP/1.1, upgrade is the request that asked
+// for it and becomes stream 1. The caller closes conn afterwards.
+func (s *Server) ServeConn(ctx context.Context, conn net.Conn, rw io.ReadWriter, upgrade *request.Request) error {
+	sc := &serverConn{
+		srv:               s,
+		ctx:               ctx,
+		conn:              conn,
+		reader:            bufio.NewReader(rw),
+		writer:            rw,
+		decoder:           NewDecoder(defaultHeaderTable),
+		streams:           map[uint32]*stream{},
+		sendWindow:        defaultWindowSize,
+		peerInitialWindow: defaultWindowSize,
+		peerMaxFrame:      defaultMaxFrame,
+		maxStreams:        s.MaxConcurrentStreams,
+		maxRequestBytes:   s.MaxRequestBytes,
+		maxBufferedBytes:  s.MaxBufferedBytes,
+		done:              make(chan struct{}),
+	}
+	if sc.maxStreams == 0 {
+		sc.maxStreams = defaultMaxConcurrentStreams
+	}
+	if sc.maxRequestBytes == 0 {
+		sc.maxRequestBytes = defaultMaxRequestBytes
+	}
+	if sc.maxBufferedBytes == 0 {
+		sc.maxBufferedBytes = defaultMaxBufferedBytes
+	}
+	sc.decoder.MaxListSize = maxHeaderList
+	sc.cond = sync.NewCond(&sc.mu)
+
+	return sc.serve(upgrade)
+}
+
+type serverConn struct {
+	srv    *Server
+	ctx    context.Context
+	conn   net.Conn
+	reader *bufio.Reader
+	writer io.Writer
+
+	// decoder is only used by the reading goroutine.
+	decoder *Decoder
+
+	// wmu serializes frame writes; a header block and its continuations
+	// must not be interleaved with other frames.
+	wmu     sync.Mutex
+	encoder Encoder
+
+	mu                sync.Mutex
+	cond              *sync.Cond
+	streams           map[uint32]*stream
+	lastStreamID      uint32
+	sendWindow        int64
+	peerInitialWindow int64
+	peerMaxFrame      uint32
+	maxStreams        uint32
+	activeStreams     uint32
+	maxRequestBytes   int64
+	maxBufferedBytes  int64
+	bufferedBytes     int64
+	goingAway         bool
+	closed            bool
+
+	handlers sync.WaitGroup
+	done     chan struct{}
+}
+
+type stream struct {
+	id     uint32
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	fields []HeaderField
+	body   []byte
+
+	// Guarded by serverConn.mu.
+	sendWindow int64
+	recvClosed bool
+	reset      bool
+	running    bool
+}
+
+func (sc *serverConn) serve(upgrade *request.Request) (err error) {
+	defer sc.shutdown()
+
+	settings := []Setting{
+		{SettingMaxConcurrentStreams, sc.maxStreams},
+		{SettingMaxHeaderListSize, maxHeaderList},
+	}
+	if err := sc.writeFrame(FrameSettings, 0, 0, encodeSettings(settings)); err != nil {
+		return err
+	}
+
+	if upgrade != nil {
+		encoded, _ := upgrade.Headers.Get("HTTP2-Settings")
+		peerSettings, err := upgradeSettings(encoded)
+		if err != nil {
+			return err
+		}
+		if err := sc.applySettings(peerSettings); err != nil {
+			return err
+		}
+		sc.startUpgradeStream(upgrade)
+	}
+
+	preface := make([]byte, len(ClientPreface))
+	if _, err := io.ReadFull(sc.reader, preface); err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		return err
+	}
+	if string(preface) != ClientPreface {
+		return sc.connError(ConnError{ErrCodeProtocol, "invalid connection preface"})
+	}
+
+	go func() {
+		select {
+		case <-sc.ctx.Done():
+			sc.goAway(ErrCodeNo)
+		case <-sc.done:
+		}
+	}()
+
+	for first := true; ; first = false {
+		f, err := ReadFrame(sc.reader, defaultMaxFrame)
+		if err != nil {
+			var connErr ConnError
+			if errors.As(err, &connErr) {
+				return sc.connError(connErr)
+			}
+			if sc.finished() || errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+
+		if first && (f.Type != FrameSettings || f.Has(FlagAck)) {
+			return sc.connError(ConnError{ErrCodeProtocol, "first frame must be SETTINGS"})
+		}
+
+		if err := sc.processFrame(f); err != nil {
+			var streamErr StreamError
+			var connErr ConnError
+			switch {
+			case errors.As(err, &streamErr):
+				sc.resetStream(streamErr)
+			case errors.As(err, &connErr):
+				return sc.connError(connErr)
+			default:
+				return err
+			}
+		}
+	}
+}
+
+// finished reports whether the connection was wound down on purpose.
+func (sc *serverConn) finished() bool {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	return sc.goingAway && len(sc.streams) == 0
+}
+
+// shutdown cancels the remaining streams and waits for their handlers.
+func (sc *serverConn) shutdown() {
+	sc.mu.Lock()
+	sc.closed = true
+	for _, st := range sc.streams {
+		st.cancel()
+	}
+	sc.cond.Broadcast()
+	sc.mu.Unlock()
+
+	close(sc.done)
+	sc.conn.SetReadDeadline(aLongTimeAgo)
+	sc.handlers.Wait()
+}
+
+func (sc *serverConn) connError(err ConnError) error {
+	sc.mu.Lock()
+	lastStreamID := sc.lastStreamID
+	sc.goingAway = true
+	sc.mu.Unlock()
+
+	sc.writeGoAway(lastStreamID, err.Code, err.Reason)
+	return err
+}
+
+// goAway stops new streams and closes the connection once the open ones are
+// done.
+func (sc *serverConn) goAway(code ErrorCode) {
+	sc.mu.Lock()
+	if sc.goingAway {
+		sc.mu.Unlock()
+		return
+	}
+	sc.goingAway = true
+	lastStreamID := sc.lastStreamID
+	idle := len(sc.streams) == 0
+	sc.mu.Unlock()
+
+	sc.writeGoAway(lastStreamID, code, "")
+	if idle {
+		sc.conn.SetReadDeadline(aLongTimeAgo)
+	}
+}
+
+func (sc *serverConn) writeGoAway(lastStreamID uint32, code ErrorCode, reason string) {
+	payload := binary.BigEndian.AppendUint32(nil, lastStreamID)
+	payload = binary.BigEndian.AppendUint32(payload, uint32(code))
+	payload = append(payload, reason...)
+	sc.writeFrame(FrameGoAway, 0, 0, payload)
+}
+
+func (sc *serverConn) resetStream(err StreamError) {
+	sc.writeFrame(FrameRSTStream, 0, err.StreamID, binary.BigEndian.AppendUint32(nil, uint32(err.Code)))
+
+	sc.mu.Lock()
+	if st, ok := sc.streams[err.StreamID]; ok {
+		st.reset = true
+		sc.removeStreamLocked(st)
+	}
+	sc.mu.Unlock()
+}
+
+func (sc *serverConn) processFrame(f *Frame) error {
+	switch f.Type {
+	case FrameSettings:
+		return sc.processSettings(f)
+	case FramePing:
+		return sc.processPing(f)
+	case FrameGoAway:
+		if f.StreamID != 0 {
+			return ConnError{ErrCodeProtocol, "GOAWAY on a stream"}
+		}
+		sc.goAway(ErrCodeNo)
+		return nil
+	case FrameWindowUpdate:
+		return sc.processWindowUpdate(f)
+	case FrameRSTStream:
+		return sc.processRSTStream(f)
+	case FramePriority:
+		if f.StreamID == 0 {
+			return ConnError{ErrCodeProtocol, "PRIORITY on stream 0"}
+		}
+		if len(f.Payload) != 5 {
+			return StreamError{f.StreamID, ErrCodeFrameSize, "PRIORITY length"}
+		}
+		return nil
+	case FrameHeaders:
+		return sc.processHeaders(f)
+	case FrameData:
+		return sc.processData(f)
+	case FrameContinuation:
+		return ConnError{ErrCodeProtocol, "unexpected CONTINUATION"}
+	case FramePushPromise:
+		return ConnError{ErrCodeProtocol, "PUSH_PROMISE from client"}
+	default:
+		// Unknown frame types are ignored.
+		return nil
+	}
+}
+
+func (sc *serverConn) processSettings(f *Frame) error {
+	if f.StreamID != 0 {
+		return ConnError{ErrCodeProtocol, "SETTINGS on a stream"}
+	}
+	if f.Has(FlagAck) {
+		if len(f.Payload) != 0 {
+			return ConnError{ErrCodeFrameSize, "SETTINGS ack with payload"}
+		}
+		return nil
+	}
+
+	settings, err := decodeSettings(f.Payload)
+	if err != nil {
+		return err
+	}
+	if err := sc.applySettings(settings); err != nil {
+		return err
+	}
+	return sc.writeFrame(FrameSettings, FlagAck, 0, nil)
+}
+
+func (sc *serverConn) applySettings(settings []Setting) error {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	for _, s := range settings {
+		switch s.ID {
+		case SettingEnablePush:
+			// Server push is never used; 103 Early Hints serve its purpose.
+			if s.Value > 1 {
+				return ConnError{ErrCodeProtocol, "invalid SETTINGS_ENABLE_PUSH"}
+			}
+		case SettingInitialWindowSize:
+			if s.Value > maxWindowSize {
+				return ConnError{ErrCodeFlowControl, "invalid SETTINGS_INITIAL_WINDOW_SIZE"}
+			}
+			delta := int64(s.Value) - sc.peerInitialWindow
+			sc.peerInitialWindow = int64(s.Value)
+			for _, st := range sc.streams {
+				st.sendWindow += delta
+				if st.sendWindow > maxWindowSize {
+					return ConnError{ErrCodeFlowControl, "stream window overflow"}
+				}
+			}
+			sc.cond.Broadcast()
+		case SettingMaxFrameSize:
+			if s.Value < defaultMaxFrame || s.Value > maxAllowedFrame {
+				return ConnError{ErrCodeProtocol, "invalid SETTINGS_MAX_FRAME_SIZE"}
+			}
+			sc.peerMaxFrame = s.Value
+		}
+		// The encoder never uses the dynamic table, so the peer's
+		// SETTINGS_HEADER_TABLE_SIZE needs no action; the remaining settings
+		// are advisory.
+	}
+	return nil
+}
+
+func (sc *serverConn) processPing(f *Frame) error {
+	if f.StreamID != 0 {
+		return ConnError{ErrCodeProtocol, "PING on a stream"}
+	}
+	if len(f.Payload) != 8 {
+		return ConnError{ErrCodeFrameSize, "PING length"}
+	}
+	if f.Has(FlagAck) {
+		return nil
+	}
+	return sc.writeFrame(FramePing, FlagAck, 0, f.Payload)
+}
+
+func (sc *serverConn) processWindowUpdate(f *Frame) error {
+	if len(f.Payload) != 4 {
+		return ConnError{ErrCodeFrameSize, "WINDOW_UPDATE length"}
+	}
+	increment := int64(binary.BigEndian.Uint32(f.Payload) & maxWindowSize)
+
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	if f.StreamID == 0 {
+		if increment == 0 {
+			return ConnError{ErrCodeProtocol, "zero WINDOW_UPDATE"}
+		}
+		sc.sendWindow += increment
+		if sc.sendWindow > maxWindowSize {
+			return ConnError{ErrCodeFlowControl, "connection window overflow"}
+		}
+		sc.cond.Broadcast()
+		return nil
+	}
+
+	if f.StreamID > sc.lastStreamID {
+		return ConnError{ErrCodeProtocol, "WINDOW_UPDATE on idle stream"}
+	}
+	st, ok := sc.streams[f.StreamID]
+	if !ok {
+		return nil
+	}
+	if increment == 0 {
+		return StreamError{f.StreamID, ErrCodeProtocol, "zero WINDOW_UPDATE"}
+	}
+	st.sendWindow += increment
+	if st.sendWindow > maxWindowSize {
+		return StreamError{f.StreamID, ErrCodeFlowControl, "stream window overflow"}
+	}
+	sc.cond.Broadcast()
+	return nil
+}
+
+func (sc *serverConn) processRSTStream(f *Frame) error {
+	if f.StreamID == 0 {
+		return ConnError{ErrCodeProtocol, "RST_STREAM on stream 0"}
+	}
+	if len(f.Payload) != 4 {
+		return ConnError{ErrCodeFrameSize, "RST_STREAM length"}
+	}
+
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	if f.StreamID > sc.lastStreamID {
+		return ConnError{ErrCodeProtocol, "RST_STREAM on idle stream"}
+	}
+	if st, ok := sc.streams[f.StreamID]; ok {
+		st.reset = true
+		sc.removeStreamLocked(st)
+	}
+	return nil
+}
+
+func (sc *serverConn) processHeaders(f *Frame) error {
+	if f.StreamID == 0 || f.StreamID%2 == 0 {
+		return ConnError{ErrCodeProtocol, fmt.Sprintf("HEADERS on stream %d", f.StreamID)}
+	}
+
+	payload, err := stripPadding(f)
+	if err != nil {
+		return err
+	}
+	if f.Has(FlagPriority) {
+		if len(payload) < 5 {
+			return ConnError{ErrCodeProtocol, "HEADERS priority truncated"}
+		}
+		payload = payload[5:]
+	}
+
+	block := append([]byte(nil), payload...)
+	for endHeaders := f.Has(FlagEndHeaders); !endHeaders; {
+		next, err := ReadFrame(sc.reader, defaultMaxFrame)
+		if err != nil {
+			return err
+		}
+		if next.Type != FrameContinuation || next.StreamID != f.StreamID {
+			return ConnError{ErrCodeProtocol, "expected CONTINUATION"}
+		}
+		block = append(block, next.Payload...)
+		if len(block) > maxHeaderBlock {
+			return ConnError{ErrCodeEnhanceYourCalm, "header block too large"}
+		}
+		endHeaders = next.Has(FlagEndHeaders)
+	}
+
+	// The block must be decoded even if the stream is refused, to keep the
+	// decoder's table in step with the client's encoder.
+	fields, err := sc.decoder.Decode(block)
+	tooLarge := errors.Is(err, errHeaderListSize)
+	if err != nil && !tooLarge {
+		return ConnError{ErrCodeCompression, err.Error()}
+	}
+
+	sc.mu.Lock()
+	if st, ok := sc.streams[f.StreamID]; ok {
+		if tooLarge && !st.recvClosed {
+			sc.removeStreamLocked(st)
+			sc.mu.Unlock()
+			sc.reject(st.id, "431")
+			return nil
+		}
+		defer sc.mu.Unlock()
+		// Trailers end the request.
+		if st.recvClosed {
+			return StreamError{f.StreamID, ErrCodeStreamClosed, "HEADERS after end of stream"}
+		}
+		if !f.Has(FlagEndStream) {
+			return StreamError{f.StreamID, ErrCodeProtocol, "trailers without END_STREAM"}
+		}
+		st.fields = append(st.fields, fields...)
+		st.recvClosed = true
+		return sc.dispatchLocked(st)
+	}
+
+	if f.StreamID <= sc.lastStreamID {
+		sc.mu.Unlock()
+		return ConnError{ErrCodeStreamClosed, fmt.Sprintf("HEADERS on closed stream %d", f.StreamID)}
+	}
+	sc.lastStreamID = f.StreamID
+
+	if sc.goingAway {
+		sc.mu.Unlock()
+		return nil
+	}
+	if tooLarge {
+		sc.mu.Unlock()
+		sc.reject(f.StreamID, "431")
+		return nil
+	}
+	if sc.activeStreams >= sc.maxStreams {
+		sc.mu.Unlock()
+		return StreamError{f.StreamID, ErrCodeRefusedStream, "too many concurrent streams"}
+	}
+
+	st := sc.newStreamLocked(f.StreamID)
+	st.fields = fields
+	defer sc.mu.Unlock()
+
+	if f.Has(FlagEndStream) {
+		st.recvClosed = true
+		return sc.dispatchLocked(st)
+	}
+	return nil
+}
+
+func (sc *serverConn) processData(f *Frame) error {
+	if f.StreamID == 0 {
+		return ConnError{ErrCodeProtocol, "DATA on stream 0"}
+	}
+	data, err := stripPadding(f)
+	if err != nil {
+		return err
+	}
+
+	// Received data is handed to handlers in full, so the windows are
+	// replenished right away; the request and buffered byte limits bound
+	// what is held instead.
+	if len(f.Payload) > 0 {
+		sc.writeWindowUpdate(0, len(f.Payload))
+	}
+
+	sc.mu.Lock()
+	st, ok := sc.streams[f.StreamID]
+	if !ok || st.recvClosed {
+		idle := f.StreamID > sc.lastStreamID
+		sc.mu.Unlock()
+		if idle {
+			return ConnError{ErrCodeProtocol, "DATA on idle stream"}
+		}
+		return StreamError{f.StreamID, ErrCodeStreamClosed, "DATA on closed stream"}
+	}
+
+	st.body = append(st.body, data...)
+	sc.bufferedBytes += int64(len(data))
+	if int64(len(st.body)) > sc.maxRequestBytes || sc.bufferedBytes > sc.maxBufferedBytes {
+		sc.removeStreamLocked(st)
+		sc.mu.Unlock()
+		sc.reject(st.id, "413")
+		return nil
+	}
+
+	if f.Has(FlagEndStream) {
+		st.recvClosed = true
+		defer sc.mu.Unlock()
+		return sc.dispatchLocked(st)
+	}
+	sc.mu.Unlock()
+
+	if len(f.Payload) > 0 {
+		sc.writeWindowUpdate(f.StreamID, len(f.Payload))
+	}
+	return nil
+}
+
+// reject answers a stream whose headers or body are over a limit with status
+// and asks the client to stop sending it.
+func (sc *serverConn) reject(streamID uint32, status string) {
+	sc.writeHeaders(streamID, []HeaderField{{Name: ":status", Value: status}, {Name: "content-length", Value: "0"}}, true)
+	sc.writeFrame(FrameRSTStream, 0, streamID, binary.BigEndian.AppendUint32(nil, uint32(ErrCodeNo)))
+}
+
+func (sc *serverConn) writeWindowUpdate(streamID uint32, n int) {
+	sc.writeFrame(FrameWindowUpdate, 0, streamID, binary.BigEndian.AppendUint32(nil, uint32(n)))
+}
+
+func (sc *serverConn) newStreamLocked(id uint32) *stream {
+	ctx, cancel := context.WithCancel(sc.ctx)
+	st := &stream{
+		id:         id,
+		ctx:        ctx,
+		cancel:     cancel,
+		sendWindow: sc.peerInitialWindow,
+	}
+	sc.streams[id] = st
+	sc.activeStreams++
+	return st
+}
+
+// removeStreamLocked forgets a stream that is done or reset, and lets the
+// connection close if it is going away and this was the last stream. A
+// stream whose handler is still running is released when it returns.
+func (sc *serverConn) removeStreamLocked(st *stream) {
+	if _, ok := sc.streams[st.id]; !ok {
+		return
+	}
+	delete(sc.streams, st.id)
+	if !st.running {
+		sc.releaseStreamLocked(st)
+	}
+	st.cancel()
+	sc.cond.Broadcast()
+
+	if sc.goingAway && len(sc.streams) == 0 {
+		sc.conn.SetReadDeadline(aLongTimeAgo)
+	}
+}
+
+// releaseStreamLocked stops counting a stream and its body against the
+// connection's limits.
+func (sc *serverConn) releaseStreamLocked(st *stream) {
+	sc.activeStreams--
+	sc.bufferedBytes -= int64(len(st.body))
+}
+
+func (sc *serverConn) startUpgradeStream(upgrade *request.Request) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	sc.lastStreamID = 1
+	st := sc.newStreamLocked(1)
+	st.recvClosed = true
+	st.running = true
+	sc.handlers.Add(1)
+	go sc.runHandler(st, upgrade.WithContext(st.ctx))
+}
+
+// dispatchLocked runs the handler for a stream whose request is complete.
+func (sc *serverConn) dispatchLocked(st *stream) error {
+	req, err := buildRequest(st.id, st.fields, st.body)
+	if err != nil {
+		return err
+	}
+
+	st.running = true
+	sc.handlers.Add(1)
+	go sc.runHandler(st, req.WithContext(st.ctx))
+	return nil
+}
+
+func (sc *serverConn) runHandler(st *stream, req *request.Request) {
+	defer sc.handlers.Done()
+
+	sw := &streamWriter{sc: sc, st: st, head: req.RequestLine.Method == "HEAD"}
+	defer func() {
+		sc.mu.Lock()
+		st.running = false
+		if _, ok := sc.streams[st.id]; ok {
+			sc.removeStreamLocked(st)
+		} else {
+			sc.releaseStreamLocked(st)
+		}
+		sc.mu.Unlock()
+	}()
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			sc.resetStream(StreamError{st.id, ErrCodeInternal, fmt.Sprint(recovered)})
+		}
+	}()
+
+	sc.srv.Handler(response.NewWriter(sw), req)
+	sw.finish()
+}
+
+func (sc *serverConn) writeFrame(typ FrameType, flags uint8, streamID uint32, payload []byte) error {
+	sc.wmu.Lock()
+	defer sc.wmu.Unlock()
+	return WriteFrame(sc.writer, typ, flags, streamID, payload)
+}
+
+// writeHeaders sends a header block split into HEADERS and CONTINUATION
+// frames no larger than the peer accepts.
+func (sc *serverConn) writeHeaders(streamID uint32, fields []HeaderField, endStream bool) error {
+	sc.mu.Lock()
+	maxFrame := int(sc.peerMaxFrame)
+	sc.mu.Unlock()
+
+	sc.wmu.Lock()
+	defer sc.wmu.Unlock()
+
+	block := sc.encoder.Encode(nil, fields)
+	typ := FrameHeaders
+	var flags uint8
+	if endStream {
+		flags = FlagEndStream
+	}
+	for {
+		n := min(len(block), maxFrame)
+		if n == len(block) {
+			flags |= FlagEndHeaders
+		}
+		if err := WriteFrame(sc.writer, typ, flags, streamID, block[:n]); err != nil {
+			return err
+		}
+		block = block[n:]
+		if len(block) == 0 {
+			return nil
+		}
+		typ = FrameContinuation
+		flags = 0
+	}
+}
+
+// writeData sends p on a stream as the flow control windows allow, waiting
+// for WINDOW_UPDATE when they are exhausted.
+func (sc *serverConn) writeData(st *stream, p []byte, endStream bool) error {
+	for {
+		sc.mu.Lock()
+		for len(p) > 0 && !st.reset && !sc.closed && (st.sendWindow <= 0 || sc.sendWindow <= 0) {
+			sc.cond.Wait()
+		}
+		if st.reset {
+			sc.mu.Unlock()
+			return errStreamClosed
+		}
+		if sc.closed {
+			sc.mu.Unlock()
+			return errConnClosed
+		}
+		n := min(int64(len(p)), st.sendWindow, sc.sendWindow, int64(sc.peerMaxFrame))
+		st.sendWindow -= n
+		sc.sendWindow -= n
+		sc.mu.Unlock()
+
+		last := n == int64(len(p))
+		var flags uint8
+		if last && endStream {
+			flags = FlagEndStream
+		}
+		if err := sc.writeFrame(FrameData, flags, st.id, p[:n]); err != nil {
+			return err
+		}
+		p = p[n:]
+		if last {
+			return nil
+		}
+	}
+}
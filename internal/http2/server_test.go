@@ -0,0 +1,298 @@
+package http2
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testClient struct {
+	t      *testing.T
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// dial starts s on one end of a loopback connection and returns the other
+// end, after the preface and SETTINGS exchange.
+func dial(t *testing.T, s *Server) *testClient {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		s.ServeConn(context.Background(), conn, conn, nil)
+	}()
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	c := &testClient{t: t, conn: conn, reader: bufio.NewReader(conn)}
+	_, err = conn.Write([]byte(ClientPreface))
+	require.NoError(t, err)
+	c.write(FrameSettings, 0, 0, nil)
+
+	f := c.read()
+	require.Equal(t, FrameSettings, f.Type)
+	c.write(FrameSettings, FlagAck, 0, nil)
+	return c
+}
+
+func (c *testClient) write(typ FrameType, flags uint8, streamID uint32, payload []byte) {
+	require.NoError(c.t, WriteFrame(c.conn, typ, flags, streamID, payload))
+}
+
+// read returns the next frame, skipping SETTINGS acknowledgements.
+func (c *testClient) read() *Frame {
+	for {
+		f, err := ReadFrame(c.reader, maxAllowedFrame)
+		require.NoError(c.t, err)
+		if f.Type == FrameSettings && f.Has(FlagAck) {
+			continue
+		}
+		return f
+	}
+}
+
+func (c *testClient) request(streamID uint32, flags uint8, fields ...HeaderField) {
+	c.write(FrameHeaders, flags|FlagEndHeaders, streamID, Encoder{}.Encode(nil, fields))
+}
+
+func get(path string) []HeaderField {
+	return []HeaderField{
+		{Name: ":method", Value: "GET"},
+		{Name: ":scheme", Value: "http"},
+		{Name: ":path", Value: path},
+		{Name: ":authority", Value: "localhost"},
+	}
+}
+
+func errorCode(payload []byte) ErrorCode {
+	return ErrorCode(binary.BigEndian.Uint32(payload))
+}
+
+func TestServer(t *testing.T) {
+	s := &Server{Handler: func(w *response.Writer, req *request.Request) {
+		host, _ := req.Headers.Get("Host")
+		body := []byte(req.RequestLine.Method + " " + req.RequestLine.RequestTarget + " " + host + " " + string(req.Body))
+		w.WriteStatusLine(response.OK)
+		w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+		w.WriteBody(body)
+	}}
+
+	// Test: A GET becomes HEADERS and DATA ending the stream
+	c := dial(t, s)
+	c.request(1, FlagEndStream, get("/hello")...)
+	f := c.read()
+	require.Equal(t, FrameHeaders, f.Type)
+	assert.True(t, f.Has(FlagEndHeaders))
+	assert.False(t, f.Has(FlagEndStream))
+	fields, err := NewDecoder(defaultHeaderTable).Decode(f.Payload)
+	require.NoError(t, err)
+	require.NotEmpty(t, fields)
+	assert.Equal(t, HeaderField{Name: ":status", Value: "200"}, fields[0])
+	assert.ElementsMatch(t, []HeaderField{
+		{Name: "content-length", Value: "21"},
+		{Name: "content-type", Value: "text/plain"},
+	}, fields[1:])
+	f = c.read()
+	require.Equal(t, FrameData, f.Type)
+	assert.True(t, f.Has(FlagEndStream))
+	assert.Equal(t, "GET /hello localhost ", string(f.Payload))
+
+	// Test: A body arrives in DATA frames and is acknowledged with WINDOW_UPDATE
+	post := get("/echo")
+	post[0].Value = "POST"
+	c.request(3, 0, post...)
+	c.write(FrameData, 0, 3, []byte("ab"))
+	c.write(FrameData, FlagEndStream, 3, []byte("c"))
+	var data []byte
+	for data == nil {
+		f = c.read()
+		if f.Type == FrameData {
+			data = f.Payload
+		}
+	}
+	assert.Equal(t, "POST /echo localhost abc", string(data))
+
+	// Test: PING is answered with an acknowledgement carrying the same data
+	c.write(FramePing, 0, 0, []byte("12345678"))
+	for f = c.read(); f.Type == FrameWindowUpdate; f = c.read() {
+	}
+	require.Equal(t, FramePing, f.Type)
+	assert.True(t, f.Has(FlagAck))
+	assert.Equal(t, "12345678", string(f.Payload))
+
+	// Test: A malformed request resets only its stream
+	c.request(5, FlagEndStream, append(get("/"), HeaderField{Name: "Upper", Value: "x"})...)
+	f = c.read()
+	require.Equal(t, FrameRSTStream, f.Type)
+	assert.Equal(t, uint32(5), f.StreamID)
+	assert.Equal(t, ErrCodeProtocol, errorCode(f.Payload))
+	c.request(7, FlagEndStream, append(get("/"), HeaderField{Name: "x-test", Value: "a\nset-cookie: b=1"})...)
+	f = c.read()
+	require.Equal(t, FrameRSTStream, f.Type)
+	assert.Equal(t, uint32(7), f.StreamID)
+
+	// Test: Header lists over the limit get 431, however small the block
+	block := Encoder{}.Encode(nil, get("/"))
+	block = appendString(appendString(append(block, 0x40), "x-big"), strings.Repeat("a", 4000))
+	for range 300 {
+		block = append(block, 0xbe)
+	}
+	c.write(FrameHeaders, FlagEndHeaders|FlagEndStream, 9, block)
+	f = c.read()
+	require.Equal(t, FrameHeaders, f.Type)
+	assert.Equal(t, uint32(9), f.StreamID)
+	fields, err = NewDecoder(defaultHeaderTable).Decode(f.Payload)
+	require.NoError(t, err)
+	assert.Equal(t, "431", fields[0].Value)
+	f = c.read()
+	require.Equal(t, FrameRSTStream, f.Type)
+
+	// Test: Even-numbered streams are a connection error
+	c.request(10, FlagEndStream, get("/")...)
+	f = c.read()
+	require.Equal(t, FrameGoAway, f.Type)
+	assert.Equal(t, ErrCodeProtocol, errorCode(f.Payload[4:]))
+
+	// Test: A bad preface is answered with GOAWAY
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+	go func() {
+		conn, err := listener.Accept()
+		if err == nil {
+			defer conn.Close()
+			s.ServeConn(context.Background(), conn, conn, nil)
+		}
+	}()
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	_, err = conn.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	reader := bufio.NewReader(conn)
+	f, err = ReadFrame(reader, maxAllowedFrame)
+	require.NoError(t, err)
+	require.Equal(t, FrameSettings, f.Type)
+	f, err = ReadFrame(reader, maxAllowedFrame)
+	require.NoError(t, err)
+	require.Equal(t, FrameGoAway, f.Type)
+	assert.Equal(t, ErrCodeProtocol, errorCode(f.Payload[4:]))
+}
+
+func TestServerBodyLimits(t *testing.T) {
+	release := make(chan struct{})
+	s := &Server{
+		MaxRequestBytes:  4,
+		MaxBufferedBytes: 6,
+		Handler: func(w *response.Writer, req *request.Request) {
+			<-release
+			w.WriteStatusLine(response.OK)
+			w.WriteHeaders(response.GetDefaultHeaders(0))
+			w.WriteBody(nil)
+		},
+	}
+	c := dial(t, s)
+	post := get("/upload")
+	post[0].Value = "POST"
+
+	// readStatus returns the status a stream is answered with, skipping
+	// WINDOW_UPDATE frames.
+	readStatus := func(streamID uint32) string {
+		for {
+			f := c.read()
+			if f.Type != FrameHeaders {
+				continue
+			}
+			require.Equal(t, streamID, f.StreamID)
+			fields, err := NewDecoder(defaultHeaderTable).Decode(f.Payload)
+			require.NoError(t, err)
+			return fields[0].Value
+		}
+	}
+
+	// Test: A body over MaxRequestBytes gets 413
+	c.request(1, 0, post...)
+	c.write(FrameData, 0, 1, []byte("abc"))
+	c.write(FrameData, FlagEndStream, 1, []byte("de"))
+	assert.Equal(t, "413", readStatus(1))
+
+	// Test: Bodies held by running handlers count towards MaxBufferedBytes
+	c.request(3, 0, post...)
+	c.write(FrameData, FlagEndStream, 3, []byte("abcd"))
+	c.request(5, 0, post...)
+	c.write(FrameData, FlagEndStream, 5, []byte("abc"))
+	assert.Equal(t, "413", readStatus(5))
+
+	// Test: Their bytes are released when the handlers return
+	close(release)
+	assert.Equal(t, "200", readStatus(3))
+	// The stream is forgotten just after its response is written.
+	status := ""
+	for id := uint32(7); id < 57 && status != "200"; id += 2 {
+		time.Sleep(10 * time.Millisecond)
+		c.request(id, 0, post...)
+		c.write(FrameData, FlagEndStream, id, []byte("abcd"))
+		status = readStatus(id)
+	}
+	assert.Equal(t, "200", status)
+}
+
+func TestServerStreamLimit(t *testing.T) {
+	release := make(chan struct{})
+	s := &Server{
+		MaxConcurrentStreams: 2,
+		Handler: func(w *response.Writer, req *request.Request) {
+			<-release
+			w.WriteStatusLine(response.OK)
+			w.WriteHeaders(response.GetDefaultHeaders(0))
+			w.WriteBody(nil)
+		},
+	}
+	c := dial(t, s)
+	cancel := binary.BigEndian.AppendUint32(nil, uint32(ErrCodeCancel))
+
+	// Test: Reset streams count until their handlers return
+	c.request(1, FlagEndStream, get("/")...)
+	c.request(3, FlagEndStream, get("/")...)
+	c.write(FrameRSTStream, 0, 1, cancel)
+	c.write(FrameRSTStream, 0, 3, cancel)
+	c.request(5, FlagEndStream, get("/")...)
+	f := c.read()
+	require.Equal(t, FrameRSTStream, f.Type)
+	assert.Equal(t, uint32(5), f.StreamID)
+	assert.Equal(t, ErrCodeRefusedStream, errorCode(f.Payload))
+
+	// Test: New streams are accepted once they have
+	close(release)
+	var status string
+	for id := uint32(7); id < 57 && status != "200"; id += 2 {
+		time.Sleep(10 * time.Millisecond)
+		c.request(id, FlagEndStream, get("/")...)
+		f = c.read()
+		if f.Type == FrameHeaders {
+			fields, err := NewDecoder(defaultHeaderTable).Decode(f.Payload)
+			require.NoError(t, err)
+			status = fields[0].Value
+		}
+	}
+	assert.Equal(t, "200", status)
+}
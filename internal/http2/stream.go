@@ -0,0 +1,318 @@
+package http2
+
+import (
+	"bytes"
+	"fmt"
+	"httpfromtcp/internal/headers"
+	"httpfromtcp/internal/request"
+	"strconv"
+	"strings"
+)
+
+// connectionHeaders only make sense for a single HTTP/1.1 connection and are
+// not allowed in HTTP/2 messages.
+var connectionHeaders = map[string]bool{
+	"connection":        true,
+	"keep-alive":        true,
+	"proxy-connection":  true,
+	"transfer-encoding": true,
+	"upgrade":           true,
+}
+
+// buildRequest turns a stream's header fields and body into a request,
+// rejecting malformed requests with a stream error.
+func buildRequest(streamID uint32, fields []HeaderField, body []byte) (*request.Request, error) {
+	malformed := func(reason string) error {
+		return StreamError{streamID, ErrCodeProtocol, reason}
+	}
+
+	pseudo := map[string]string{}
+	values := map[string][]string{}
+	regular := false
+
+	for _, f := range fields {
+		if strings.ContainsAny(f.Value, "\x00\r\n") {
+			return nil, malformed("CR, LF or NUL in " + f.Name)
+		}
+		if strings.HasPrefix(f.Name, ":") {
+			if regular {
+				return nil, malformed("pseudo-header after regular header")
+			}
+			switch f.Name {
+			case ":method", ":scheme", ":path", ":authority":
+			default:
+				return nil, malformed("unknown pseudo-header " + f.Name)
+			}
+			if _, ok := pseudo[f.Name]; ok {
+				return nil, malformed("duplicate " + f.Name)
+			}
+			pseudo[f.Name] = f.Value
+			continue
+		}
+
+		regular = true
+		if f.Name != strings.ToLower(f.Name) {
+			return nil, malformed("uppercase header name " + f.Name)
+		}
+		if connectionHeaders[f.Name] {
+			return nil, malformed("connection-specific header " + f.Name)
+		}
+		if f.Name == "te" && f.Value != "trailers" {
+			return nil, malformed("te other than trailers")
+		}
+		values[f.Name] = append(values[f.Name], f.Value)
+	}
+
+	// Repeated fields are joined once; cookie pairs sent as separate fields
+	// are joined with "; ".
+	h := headers.NewHeaders()
+	for name, v := range values {
+		h.SetValues(name, v)
+	}
+
+	method := pseudo[":method"]
+	authority := pseudo[":authority"]
+	target := pseudo[":path"]
+	if method == "CONNECT" {
+		if authority == "" || target != "" || pseudo[":scheme"] != "" {
+			return nil, malformed("CONNECT needs :authority only")
+		}
+		target = authority
+	} else if method == "" || target == "" || pseudo[":scheme"] == "" {
+		return nil, malformed("missing :method, :scheme or :path")
+	}
+
+	if _, ok := h.Get("host"); !ok && authority != "" {
+		h.Set("host", authority)
+	}
+	if contentLength, ok := h.Get("content-length"); ok {
+		if n, err := strconv.Atoi(contentLength); err != nil || n != len(body) {
+			return nil, malformed("content-length does not match body")
+		}
+	} else if len(body) > 0 {
+		h.Set("content-length", strconv.Itoa(len(body)))
+	}
+
+	line := request.RequestLine{
+		Method:        method,
+		RequestTarget: target,
+		HttpVersion:   "2",
+	}
+	return request.NewRequest(line, h, body), nil
+}
+
+type writerState int
+
+const (
+	stateHead writerState = iota
+	stateBody
+	stateUntilEnd
+	stateChunkSize
+	stateChunkData
+	stateChunkEnd
+	stateTrailers
+	stateDone
+	stateDiscard
+)
+
+// streamWriter is what a stream's response.Writer writes to. It parses the
+// HTTP/1.1 response the writer produces and sends it on as HEADERS and DATA
+// frames: the status line and headers become a header block, the body with
+// its chunked framing removed becomes DATA, and trailers a final header
+// block.
+type streamWriter struct {
+	sc   *serverConn
+	st   *stream
+	head bool
+
+	state     writerState
+	line      []byte
+	status    string
+	fields    []HeaderField
+	remaining int64
+}
+
+func (sw *streamWriter) Write(p []byte) (int, error) {
+	n := len(p)
+
+	for len(p) > 0 {
+		switch sw.state {
+		case stateBody:
+			k := min(int64(len(p)), sw.remaining)
+			sw.remaining -= k
+			if sw.remaining == 0 {
+				sw.state = stateDone
+			}
+			if err := sw.sc.writeData(sw.st, p[:k], sw.remaining == 0); err != nil {
+				return 0, err
+			}
+			p = p[k:]
+
+		case stateUntilEnd:
+			if err := sw.sc.writeData(sw.st, p, false); err != nil {
+				return 0, err
+			}
+			p = nil
+
+		case stateChunkData:
+			k := min(int64(len(p)), sw.remaining)
+			sw.remaining -= k
+			if sw.remaining == 0 {
+				sw.state = stateChunkEnd
+			}
+			if err := sw.sc.writeData(sw.st, p[:k], false); err != nil {
+				return 0, err
+			}
+			p = p[k:]
+
+		case stateDone:
+			return 0, fmt.Errorf("Error writing response: already complete")
+
+		case stateDiscard:
+			p = nil
+
+		default:
+			i := bytes.IndexByte(p, '\n')
+			if i < 0 {
+				sw.line = append(sw.line, p...)
+				p = nil
+				continue
+			}
+			line := strings.TrimSuffix(string(append(sw.line, p[:i]...)), "\r")
+			sw.line = sw.line[:0]
+			p = p[i+1:]
+			if err := sw.handleLine(line); err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	return n, nil
+}
+
+func (sw *streamWriter) handleLine(line string) error {
+	switch sw.state {
+	case stateHead:
+		if sw.status == "" {
+			parts := strings.SplitN(line, " ", 3)
+			if len(parts) < 2 || len(parts[1]) != 3 {
+				return fmt.Errorf("Error writing response: malformed status line %q", line)
+			}
+			sw.status = parts[1]
+			return nil
+		}
+		if line != "" {
+			return sw.addField(line)
+		}
+		return sw.endHead()
+
+	case stateChunkSize:
+		sizeField, _, _ := strings.Cut(line, ";")
+		size, err := strconv.ParseInt(strings.TrimSpace(sizeField), 16, 64)
+		if err != nil || size < 0 {
+			return fmt.Errorf("Error writing response: malformed chunk size %q", line)
+		}
+		if size == 0 {
+			sw.state = stateTrailers
+			return nil
+		}
+		sw.remaining = size
+		sw.state = stateChunkData
+		return nil
+
+	case stateChunkEnd:
+		if line != "" {
+			return fmt.Errorf("Error writing response: missing CRLF after chunk")
+		}
+		sw.state = stateChunkSize
+		return nil
+
+	case stateTrailers:
+		if line != "" {
+			return sw.addField(line)
+		}
+		sw.state = stateDone
+		if len(sw.fields) > 0 {
+			return sw.sc.writeHeaders(sw.st.id, sw.fields, true)
+		}
+		return sw.sc.writeData(sw.st, nil, true)
+	}
+	return nil
+}
+
+func (sw *streamWriter) addField(line string) error {
+	name, value, ok := strings.Cut(line, ":")
+	if !ok {
+		return fmt.Errorf("Error writing response: malformed header %q", line)
+	}
+	sw.fields = append(sw.fields, HeaderField{
+		Name:  strings.ToLower(strings.TrimSpace(name)),
+		Value: strings.TrimSpace(value),
+	})
+	return nil
+}
+
+// endHead sends the response header block and picks how the body is framed.
+func (sw *streamWriter) endHead() error {
+	status, _ := strconv.Atoi(sw.status)
+	if status == 101 {
+		return fmt.Errorf("Error writing response: 101 Switching Protocols is not allowed in HTTP/2")
+	}
+
+	fields := []HeaderField{{Name: ":status", Value: sw.status}}
+	chunked := false
+	contentLength := int64(-1)
+	for _, f := range sw.fields {
+		switch {
+		case f.Name == "transfer-encoding":
+			chunked = strings.Contains(strings.ToLower(f.Value), "chunked")
+		case connectionHeaders[f.Name]:
+		case f.Name == "content-length":
+			contentLength, _ = strconv.ParseInt(f.Value, 10, 64)
+			fields = append(fields, f)
+		default:
+			fields = append(fields, f)
+		}
+	}
+	sw.fields = nil
+	sw.status = ""
+
+	// Informational responses are followed by the real one.
+	if status >= 100 && status < 200 {
+		return sw.sc.writeHeaders(sw.st.id, fields, false)
+	}
+
+	endStream := false
+	switch {
+	case chunked:
+		sw.state = stateChunkSize
+	case contentLength == 0:
+		endStream = true
+		sw.state = stateDone
+	case contentLength > 0:
+		sw.remaining = contentLength
+		sw.state = stateBody
+	default:
+		sw.state = stateUntilEnd
+	}
+	if sw.head || status == 204 || status == 304 {
+		// Whatever body the handler writes anyway is dropped.
+		endStream = true
+		sw.state = stateDiscard
+	}
+
+	return sw.sc.writeHeaders(sw.st.id, fields, endStream)
+}
+
+// finish ends the stream once the handler has returned. A response that
+// never started or stopped short of its declared length is reset instead,
+// so the client can't mistake it for a complete one.
+func (sw *streamWriter) finish() {
+	switch sw.state {
+	case stateDone, stateDiscard:
+	case stateUntilEnd:
+		sw.sc.writeData(sw.st, nil, true)
+	default:
+		sw.sc.resetStream(StreamError{sw.st.id, ErrCodeInternal, "incomplete response"})
+	}
+}
@@ -0,0 +1,124 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"httpfromtcp/internal/server"
+	"io"
+	"log/slog"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+type LogFormat int
+
+const (
+	CommonLogFormat   LogFormat = iota //0
+	CombinedLogFormat                  //1
+	JSONLogFormat                      //2
+)
+
+const clfTimeFormat = "02/Jan/2006:15:04:05 -0700"
+
+// AccessLog wraps next and writes one line per served request to out, in
+// Apache Common/Combined Log Format or as JSON records via log/slog.
+func AccessLog(out io.Writer, format LogFormat, next server.Handler) server.Handler {
+	var mu sync.Mutex
+	jsonLogger := slog.New(slog.NewJSONHandler(out, nil))
+
+	return func(w *response.Writer, req *request.Request) {
+		start := time.Now()
+		next(w, req)
+		duration := time.Since(start)
+
+		entry := newAccessEntry(w, req, start, duration)
+
+		switch format {
+		case JSONLogFormat:
+			jsonLogger.LogAttrs(req.Context(), slog.LevelInfo, "request",
+				slog.String("remote_addr", entry.remoteAddr),
+				slog.String("method", entry.method),
+				slog.String("target", entry.target),
+				slog.String("protocol", entry.protocol),
+				slog.Int("status", entry.status),
+				slog.Int("bytes", entry.bytes),
+				slog.Duration("duration", entry.duration),
+				slog.String("referer", entry.referer),
+				slog.String("user_agent", entry.userAgent),
+			)
+		default:
+			mu.Lock()
+			defer mu.Unlock()
+			fmt.Fprintln(out, entry.clf(format == CombinedLogFormat))
+		}
+	}
+}
+
+type accessEntry struct {
+	remoteAddr string
+	time       time.Time
+	method     string
+	target     string
+	protocol   string
+	status     int
+	bytes      int
+	duration   time.Duration
+	referer    string
+	userAgent  string
+}
+
+func newAccessEntry(w *response.Writer, req *request.Request, start time.Time, duration time.Duration) accessEntry {
+	referer, _ := req.Headers.Get("Referer")
+	userAgent, _ := req.Headers.Get("User-Agent")
+
+	return accessEntry{
+		remoteAddr: remoteHost(req.Context()),
+		time:       start,
+		method:     req.RequestLine.Method,
+		target:     req.RequestLine.RequestTarget,
+		protocol:   "HTTP/" + req.RequestLine.HttpVersion,
+		status:     int(w.StatusCode()),
+		bytes:      w.BytesWritten(),
+		duration:   duration,
+		referer:    referer,
+		userAgent:  userAgent,
+	}
+}
+
+func (e accessEntry) clf(combined bool) string {
+	bytes := "-"
+	if e.bytes > 0 {
+		bytes = strconv.Itoa(e.bytes)
+	}
+
+	line := fmt.Sprintf("%s - - [%s] \"%s %s %s\" %d %s",
+		orDash(e.remoteAddr), e.time.Format(clfTimeFormat), e.method, e.target, e.protocol, e.status, bytes)
+
+	if combined {
+		line += fmt.Sprintf(" %q %q", orDash(e.referer), orDash(e.userAgent))
+	}
+	return line
+}
+
+func remoteHost(ctx context.Context) string {
+	addr, ok := ctx.Value(server.RemoteAddrContextKey).(net.Addr)
+	if !ok {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
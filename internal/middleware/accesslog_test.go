@@ -0,0 +1,69 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"httpfromtcp/internal/server"
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestRequest(t *testing.T, raw string) *request.Request {
+	req, err := request.RequestFromReader(strings.NewReader(raw))
+	require.NoError(t, err)
+
+	addr := &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 51234}
+	return req.WithContext(context.WithValue(context.Background(), server.RemoteAddrContextKey, addr))
+}
+
+func okHandler(w *response.Writer, _ *request.Request) {
+	body := []byte("hello")
+	w.WriteStatusLine(response.OK)
+	w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+	w.WriteBody(body)
+}
+
+func TestAccessLog(t *testing.T) {
+	raw := "GET /coffee HTTP/1.1\r\nHost: localhost\r\nReferer: http://example.com/\r\nUser-Agent: curl/7.81.0\r\n\r\n"
+
+	// Test: Combined Log Format
+	out := &bytes.Buffer{}
+	handler := AccessLog(out, CombinedLogFormat, okHandler)
+	handler(response.NewWriter(io.Discard), newTestRequest(t, raw))
+
+	line := out.String()
+	assert.Regexp(t, `^192\.0\.2\.1 - - \[\d{2}/\w{3}/\d{4}:\d{2}:\d{2}:\d{2} [+-]\d{4}\] "GET /coffee HTTP/1\.1" 200 5 "http://example\.com/" "curl/7\.81\.0"\n$`, line)
+
+	// Test: Common Log Format omits referer and user agent
+	out.Reset()
+	handler = AccessLog(out, CommonLogFormat, okHandler)
+	handler(response.NewWriter(io.Discard), newTestRequest(t, raw))
+
+	line = out.String()
+	assert.True(t, strings.HasSuffix(line, `"GET /coffee HTTP/1.1" 200 5`+"\n"))
+
+	// Test: JSON
+	out.Reset()
+	handler = AccessLog(out, JSONLogFormat, okHandler)
+	handler(response.NewWriter(io.Discard), newTestRequest(t, raw))
+
+	record := map[string]any{}
+	require.NoError(t, json.Unmarshal(out.Bytes(), &record))
+	assert.Equal(t, "192.0.2.1", record["remote_addr"])
+	assert.Equal(t, "GET", record["method"])
+	assert.Equal(t, "/coffee", record["target"])
+	assert.Equal(t, "HTTP/1.1", record["protocol"])
+	assert.Equal(t, float64(200), record["status"])
+	assert.Equal(t, float64(5), record["bytes"])
+	assert.Equal(t, "http://example.com/", record["referer"])
+	assert.Equal(t, "curl/7.81.0", record["user_agent"])
+	assert.Contains(t, record, "duration")
+}
@@ -0,0 +1,331 @@
+package middleware
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"httpfromtcp/internal/server"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const defaultMaxSignatureSkew = 5 * time.Minute
+
+var (
+	// ErrNoCredentials is returned by an Authenticator for requests that
+	// carry no credentials of its scheme, so the next one gets a try.
+	ErrNoCredentials = errors.New("no credentials")
+	// ErrInvalidCredentials is returned for credentials that were present
+	// but wrong.
+	ErrInvalidCredentials = errors.New("invalid credentials")
+)
+
+// Identity is who a request was authenticated as.
+type Identity struct {
+	// Subject is the user name, the token's subject or the signing key ID.
+	Subject string
+	// Scheme is the authentication scheme that accepted the request, e.g.
+	// "Basic".
+	Scheme string
+	// Claims holds the claims of a JWT, if the request carried one.
+	Claims map[string]any
+}
+
+type identityContextKey struct{}
+
+// IdentityFrom returns the identity of the request whose context is ctx, as
+// set by the Auth middleware.
+func IdentityFrom(ctx context.Context) (*Identity, bool) {
+	identity, ok := ctx.Value(identityContextKey{}).(*Identity)
+	return identity, ok
+}
+
+// Authenticator checks one kind of credentials in the Authorization header.
+type Authenticator interface {
+	// Authenticate returns who sent req, ErrNoCredentials if req carries no
+	// credentials of this scheme, or an error saying why they were refused.
+	Authenticate(req *request.Request) (*Identity, error)
+	// Challenge returns the WWW-Authenticate challenge sent with a 401. err
+	// is the error Authenticate returned.
+	Challenge(err error) string
+}
+
+// Auth wraps next so only requests accepted by one of the authenticators
+// reach it, with their Identity available through IdentityFrom. Other
+// requests get 401 Unauthorized with a challenge from every authenticator.
+func Auth(next server.Handler, authenticators ...Authenticator) server.Handler {
+	return func(w *response.Writer, req *request.Request) {
+		errs := make([]error, len(authenticators))
+		for i, a := range authenticators {
+			identity, err := a.Authenticate(req)
+			if err == nil {
+				next(w, req.WithContext(context.WithValue(req.Context(), identityContextKey{}, identity)))
+				return
+			}
+			errs[i] = err
+		}
+
+		for i, a := range authenticators {
+			w.Header().Set("WWW-Authenticate", a.Challenge(errs[i]))
+		}
+		writeStatus(w, response.Unauthorized, "Unauthorized")
+	}
+}
+
+// credentials returns what follows scheme in the Authorization header.
+func credentials(req *request.Request, scheme string) (string, bool) {
+	authorization, ok := req.Headers.Get("Authorization")
+	if !ok {
+		return "", false
+	}
+	name, rest, _ := strings.Cut(strings.TrimSpace(authorization), " ")
+	if !strings.EqualFold(name, scheme) {
+		return "", false
+	}
+	return strings.TrimSpace(rest), true
+}
+
+type basicAuth struct {
+	realm  string
+	verify func(user, password string) bool
+}
+
+// BasicAuth accepts Basic credentials that verify approves, such as
+// Htpasswd.Verify. verify should take the same time whether or not the user
+// exists.
+func BasicAuth(realm string, verify func(user, password string) bool) Authenticator {
+	return &basicAuth{realm: realm, verify: verify}
+}
+
+func (a *basicAuth) Authenticate(req *request.Request) (*Identity, error) {
+	encoded, ok := credentials(req, "Basic")
+	if !ok {
+		return nil, ErrNoCredentials
+	}
+	decoded, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("%w: malformed Basic credentials", ErrInvalidCredentials)
+	}
+	user, password, ok := strings.Cut(string(decoded), ":")
+	if !ok || !a.verify(user, password) {
+		return nil, ErrInvalidCredentials
+	}
+	return &Identity{Subject: user, Scheme: "Basic"}, nil
+}
+
+func (a *basicAuth) Challenge(error) string {
+	return fmt.Sprintf(`Basic realm=%s, charset="UTF-8"`, strconv.Quote(a.realm))
+}
+
+// Htpasswd holds the users of an htpasswd file with bcrypt hashes, as
+// written by htpasswd -B.
+type Htpasswd struct {
+	hashes map[string][]byte
+	// dummy is compared against for unknown users, so they take as long
+	// as known ones.
+	dummy []byte
+}
+
+// LoadHtpasswd reads an htpasswd file of "user:hash" lines. Blank lines and
+// lines starting with # are skipped; hashes other than bcrypt are refused.
+func LoadHtpasswd(path string) (*Htpasswd, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading htpasswd file: %w", err)
+	}
+
+	h := &Htpasswd{hashes: map[string][]byte{}}
+	cost := bcrypt.MinCost
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		user, hash, ok := strings.Cut(line, ":")
+		if !ok || user == "" {
+			return nil, fmt.Errorf("Error in htpasswd file %s line %d: expected user:hash", path, lineNo)
+		}
+		userCost, err := bcrypt.Cost([]byte(hash))
+		if err != nil {
+			return nil, fmt.Errorf("Error in htpasswd file %s line %d: %w", path, lineNo, err)
+		}
+		cost = max(cost, userCost)
+		h.hashes[user] = []byte(hash)
+	}
+
+	h.dummy, err = bcrypt.GenerateFromPassword([]byte("dummy password"), cost)
+	if err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// Verify reports whether password is the user's.
+func (h *Htpasswd) Verify(user, password string) bool {
+	hash, ok := h.hashes[user]
+	if !ok {
+		bcrypt.CompareHashAndPassword(h.dummy, []byte(password))
+		return false
+	}
+	return bcrypt.CompareHashAndPassword(hash, []byte(password)) == nil
+}
+
+type bearerAuth struct {
+	realm    string
+	validate func(token string) (*Identity, error)
+}
+
+// BearerAuth accepts Bearer tokens that validate returns an identity for,
+// such as JWTValidator.Validate.
+func BearerAuth(realm string, validate func(token string) (*Identity, error)) Authenticator {
+	return &bearerAuth{realm: realm, validate: validate}
+}
+
+func (a *bearerAuth) Authenticate(req *request.Request) (*Identity, error) {
+	token, ok := credentials(req, "Bearer")
+	if !ok {
+		return nil, ErrNoCredentials
+	}
+	if token == "" {
+		return nil, fmt.Errorf("%w: empty Bearer token", ErrInvalidCredentials)
+	}
+	identity, err := a.validate(token)
+	if err != nil {
+		return nil, err
+	}
+	if identity == nil {
+		return nil, fmt.Errorf("%w: Bearer token has no identity", ErrInvalidCredentials)
+	}
+	if identity.Scheme == "" {
+		identity.Scheme = "Bearer"
+	}
+	return identity, nil
+}
+
+// Challenge tells clients whose token was refused to get a new one, as
+// RFC 6750 describes.
+func (a *bearerAuth) Challenge(err error) string {
+	challenge := "Bearer realm=" + strconv.Quote(a.realm)
+	if err != nil && !errors.Is(err, ErrNoCredentials) {
+		challenge += `, error="invalid_token"`
+	}
+	return challenge
+}
+
+type HMACConfig struct {
+	// Secret returns the shared secret of a key ID, or false for unknown IDs.
+	Secret func(keyID string) ([]byte, bool)
+	// MaxSkew is how far the Date header may be from the server's clock,
+	// defaulting to 5 minutes. A signed request can be replayed within it.
+	MaxSkew time.Duration
+	Realm   string
+}
+
+type hmacAuth struct {
+	config HMACConfig
+	now    func() time.Time
+}
+
+// HMACAuth accepts requests signed with a shared secret. Clients send
+//
+//	Authorization: HMAC-SHA256 keyId="<id>", signature="<base64>"
+//
+// where the signature is the HMAC-SHA256 of the method, request target, Host
+// and Date headers and the hex SHA-256 of the body, each on a line of its
+// own without a trailing newline. The Date header is required.
+func HMACAuth(config HMACConfig) Authenticator {
+	if config.MaxSkew <= 0 {
+		config.MaxSkew = defaultMaxSignatureSkew
+	}
+	return &hmacAuth{config: config, now: time.Now}
+}
+
+func (a *hmacAuth) Authenticate(req *request.Request) (*Identity, error) {
+	params, ok := credentials(req, "HMAC-SHA256")
+	if !ok {
+		return nil, ErrNoCredentials
+	}
+	keyID, signature, err := parseSignatureParams(params)
+	if err != nil {
+		return nil, err
+	}
+
+	date, ok := req.Headers.Get("Date")
+	if !ok {
+		return nil, fmt.Errorf("%w: signed request without Date", ErrInvalidCredentials)
+	}
+	signedAt, err := time.Parse(time.RFC1123, date)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid Date %q", ErrInvalidCredentials, date)
+	}
+	if skew := a.now().Sub(signedAt).Abs(); skew > a.config.MaxSkew {
+		return nil, fmt.Errorf("%w: Date off by %s", ErrInvalidCredentials, skew.Round(time.Second))
+	}
+
+	secret, ok := a.config.Secret(keyID)
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown key %q", ErrInvalidCredentials, keyID)
+	}
+	if !hmac.Equal(signature, SignRequest(req, secret)) {
+		return nil, fmt.Errorf("%w: bad signature", ErrInvalidCredentials)
+	}
+	return &Identity{Subject: keyID, Scheme: "HMAC-SHA256"}, nil
+}
+
+func (a *hmacAuth) Challenge(error) string {
+	return "HMAC-SHA256 realm=" + strconv.Quote(a.config.Realm)
+}
+
+// SignRequest returns the HMAC-SHA256 signature HMACAuth expects for req.
+func SignRequest(req *request.Request, secret []byte) []byte {
+	host, _ := req.Headers.Get("Host")
+	date, _ := req.Headers.Get("Date")
+	bodyHash := sha256.Sum256(req.Body)
+
+	h := hmac.New(sha256.New, secret)
+	fmt.Fprintf(h, "%s\n%s\n%s\n%s\n%s", req.RequestLine.Method, req.RequestLine.RequestTarget, host, date, hex.EncodeToString(bodyHash[:]))
+	return h.Sum(nil)
+}
+
+// parseSignatureParams reads the keyId and signature parameters of an
+// HMAC-SHA256 Authorization header.
+func parseSignatureParams(params string) (string, []byte, error) {
+	var keyID, encoded string
+	for param := range strings.SplitSeq(params, ",") {
+		name, value, ok := strings.Cut(strings.TrimSpace(param), "=")
+		if !ok {
+			return "", nil, fmt.Errorf("%w: malformed signature parameter %q", ErrInvalidCredentials, param)
+		}
+		if unquoted, err := strconv.Unquote(value); err == nil {
+			value = unquoted
+		}
+		switch strings.ToLower(name) {
+		case "keyid":
+			keyID = value
+		case "signature":
+			encoded = value
+		}
+	}
+	if keyID == "" || encoded == "" {
+		return "", nil, fmt.Errorf("%w: keyId and signature are required", ErrInvalidCredentials)
+	}
+
+	signature, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(signature) != sha256.Size {
+		return "", nil, fmt.Errorf("%w: malformed signature", ErrInvalidCredentials)
+	}
+	return keyID, signature, nil
+}
@@ -0,0 +1,238 @@
+package middleware
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// whoami answers with the subject of the request's identity.
+func whoami(w *response.Writer, req *request.Request) {
+	identity, ok := IdentityFrom(req.Context())
+	if !ok {
+		panic("no identity")
+	}
+	body := []byte(identity.Scheme + " " + identity.Subject)
+	w.WriteStatusLine(response.OK)
+	w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+	w.WriteBody(body)
+}
+
+func authGet(t *testing.T, handler func(*response.Writer, *request.Request), raw string) string {
+	out := &bytes.Buffer{}
+	handler(response.NewWriter(out), newTestRequest(t, raw))
+	return out.String()
+}
+
+func basicRequest(user, password string) string {
+	credentials := base64.StdEncoding.EncodeToString([]byte(user + ":" + password))
+	return "GET / HTTP/1.1\r\nHost: localhost\r\nAuthorization: Basic " + credentials + "\r\n\r\n"
+}
+
+func TestAuth(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("s3cret"), bcrypt.MinCost)
+	require.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "htpasswd")
+	require.NoError(t, os.WriteFile(path, []byte("# users\nalice:"+string(hash)+"\n\n"), 0o600))
+	htpasswd, err := LoadHtpasswd(path)
+	require.NoError(t, err)
+
+	tokens := func(token string) (*Identity, error) {
+		switch token {
+		case "good-token":
+			return &Identity{Subject: "service"}, nil
+		case "lost-token":
+			return nil, nil
+		}
+		return nil, ErrInvalidCredentials
+	}
+	handler := Auth(whoami, BasicAuth("admin", htpasswd.Verify), BearerAuth("api", tokens))
+
+	// Test: Basic credentials from the htpasswd file are accepted
+	res := authGet(t, handler, basicRequest("alice", "s3cret"))
+	assert.True(t, strings.HasPrefix(res, "HTTP/1.1 200 OK\r\n"))
+	assert.True(t, strings.HasSuffix(res, "Basic alice"))
+
+	// Test: Wrong passwords, unknown users and garbage get 401
+	for _, raw := range []string{
+		basicRequest("alice", "wrong"),
+		basicRequest("bob", "s3cret"),
+		"GET / HTTP/1.1\r\nHost: localhost\r\nAuthorization: Basic !!!\r\n\r\n",
+	} {
+		res = authGet(t, handler, raw)
+		assert.True(t, strings.HasPrefix(res, "HTTP/1.1 401 Unauthorized\r\n"), raw)
+	}
+
+	// Test: Requests without credentials are challenged with every scheme
+	res = authGet(t, handler, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.True(t, strings.HasPrefix(res, "HTTP/1.1 401 Unauthorized\r\n"))
+	assert.Contains(t, res, "www-authenticate: Basic realm=\"admin\", charset=\"UTF-8\", Bearer realm=\"api\"\r\n")
+
+	// Test: Bearer tokens are checked by the callback and bad ones flagged in the challenge
+	res = authGet(t, handler, "GET / HTTP/1.1\r\nHost: localhost\r\nAuthorization: bearer good-token\r\n\r\n")
+	assert.True(t, strings.HasSuffix(res, "Bearer service"))
+	res = authGet(t, handler, "GET / HTTP/1.1\r\nHost: localhost\r\nAuthorization: Bearer bad-token\r\n\r\n")
+	assert.Contains(t, res, `Bearer realm="api", error="invalid_token"`)
+
+	// Test: A validator returning neither identity nor error refuses the token
+	res = authGet(t, handler, "GET / HTTP/1.1\r\nHost: localhost\r\nAuthorization: Bearer lost-token\r\n\r\n")
+	assert.True(t, strings.HasPrefix(res, "HTTP/1.1 401 Unauthorized\r\n"))
+
+	// Test: htpasswd files with other hashes or malformed lines are refused
+	for _, content := range []string{"alice:{SHA}W6ph5Mm5Pz8GgiULbPgzG37mj9g=\n", "alice\n"} {
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+		_, err = LoadHtpasswd(path)
+		assert.Error(t, err, content)
+	}
+}
+
+func TestHMACAuth(t *testing.T) {
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	secret := []byte("shared secret")
+	a := HMACAuth(HMACConfig{Secret: func(keyID string) ([]byte, bool) {
+		return secret, keyID == "client-1"
+	}}).(*hmacAuth)
+	a.now = func() time.Time { return now }
+	handler := Auth(whoami, a)
+
+	signed := func(date, body string, secret []byte) string {
+		raw := "POST /orders?id=1 HTTP/1.1\r\nHost: api.example.com\r\nDate: " + date + "\r\nContent-Length: " + strconv.Itoa(len(body)) + "\r\n\r\n" + body
+		signature := base64.StdEncoding.EncodeToString(SignRequest(newTestRequest(t, raw), secret))
+		return strings.Replace(raw, "\r\n\r\n", "\r\nAuthorization: HMAC-SHA256 keyId=\"client-1\", signature=\""+signature+"\"\r\n\r\n", 1)
+	}
+	date := now.Format(time.RFC1123)
+
+	// Test: Correctly signed requests are accepted
+	res := authGet(t, handler, signed(date, "{}", secret))
+	assert.True(t, strings.HasSuffix(res, "HMAC-SHA256 client-1"), res)
+
+	// Test: A tampered body, a wrong secret or a stale Date are refused
+	tampered := strings.Replace(signed(date, "{}", secret), "\r\n\r\n{}", "\r\n\r\n[]", 1)
+	for _, raw := range []string{
+		tampered,
+		signed(date, "{}", []byte("other secret")),
+		signed(now.Add(-6*time.Minute).Format(time.RFC1123), "{}", secret),
+	} {
+		res = authGet(t, handler, raw)
+		assert.True(t, strings.HasPrefix(res, "HTTP/1.1 401 Unauthorized\r\n"))
+		assert.Contains(t, res, "www-authenticate: HMAC-SHA256 realm=\"\"\r\n")
+	}
+
+	// Test: Missing parameters, unknown keys and unsigned dates are reported
+	_, err := a.Authenticate(newTestRequest(t, "GET / HTTP/1.1\r\nHost: localhost\r\nAuthorization: HMAC-SHA256 keyId=\"client-1\"\r\n\r\n"))
+	assert.ErrorIs(t, err, ErrInvalidCredentials)
+	_, err = a.Authenticate(newTestRequest(t, strings.Replace(signed(date, "", secret), "client-1", "client-2", 1)))
+	assert.ErrorIs(t, err, ErrInvalidCredentials)
+	_, err = a.Authenticate(newTestRequest(t, strings.Replace(signed(date, "", secret), "Date:", "X-Date:", 1)))
+	assert.ErrorIs(t, err, ErrInvalidCredentials)
+	_, err = a.Authenticate(newTestRequest(t, "GET / HTTP/1.1\r\nHost: localhost\r\nAuthorization: Basic abc\r\n\r\n"))
+	assert.ErrorIs(t, err, ErrNoCredentials)
+}
+
+// signJWT builds a token with the given algorithm and claims, signed by key.
+func signJWT(t *testing.T, alg string, claims map[string]any, key any) string {
+	header, err := json.Marshal(map[string]string{"alg": alg, "typ": "JWT"})
+	require.NoError(t, err)
+	payload, err := json.Marshal(claims)
+	require.NoError(t, err)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+
+	var signature []byte
+	switch key := key.(type) {
+	case []byte:
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(signed))
+		signature = mac.Sum(nil)
+	case *rsa.PrivateKey:
+		digest := sha256.Sum256([]byte(signed))
+		signature, err = rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+		require.NoError(t, err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func TestJWTValidator(t *testing.T) {
+	now := time.Unix(1_800_000_000, 0)
+	hmacKey := bytes.Repeat([]byte("k"), 32)
+	v, err := NewJWTValidator(JWTConfig{HMACKey: hmacKey, Issuer: "https://auth.example.com", Audience: "api", Leeway: 30 * time.Second})
+	require.NoError(t, err)
+	v.now = func() time.Time { return now }
+
+	claims := map[string]any{"sub": "user-1", "iss": "https://auth.example.com", "aud": []string{"web", "api"}, "exp": now.Unix() + 60, "scope": "read"}
+
+	// Test: A valid HS256 token gives the subject and claims
+	identity, err := v.Validate(signJWT(t, "HS256", claims, hmacKey))
+	require.NoError(t, err)
+	assert.Equal(t, "user-1", identity.Subject)
+	assert.Equal(t, "read", identity.Claims["scope"])
+
+	// Test: Expiry and not-before are checked with leeway
+	expired := map[string]any{"sub": "user-1", "iss": "https://auth.example.com", "aud": "api", "exp": now.Unix() - 10}
+	_, err = v.Validate(signJWT(t, "HS256", expired, hmacKey))
+	assert.NoError(t, err)
+	expired["exp"] = now.Unix() - 31
+	_, err = v.Validate(signJWT(t, "HS256", expired, hmacKey))
+	assert.ErrorIs(t, err, ErrInvalidCredentials)
+	early := map[string]any{"iss": "https://auth.example.com", "aud": "api", "nbf": now.Unix() + 60}
+	_, err = v.Validate(signJWT(t, "HS256", early, hmacKey))
+	assert.ErrorIs(t, err, ErrInvalidCredentials)
+
+	// Test: Wrong issuer, audience, key or algorithm are refused
+	for name, token := range map[string]string{
+		"issuer":    signJWT(t, "HS256", map[string]any{"iss": "other", "aud": "api"}, hmacKey),
+		"audience":  signJWT(t, "HS256", map[string]any{"iss": "https://auth.example.com", "aud": "web"}, hmacKey),
+		"key":       signJWT(t, "HS256", claims, bytes.Repeat([]byte("x"), 32)),
+		"none":      signJWT(t, "none", claims, nil),
+		"malformed": "a.b",
+	} {
+		_, err = v.Validate(token)
+		assert.ErrorIs(t, err, ErrInvalidCredentials, name)
+	}
+
+	// Test: RS256 tokens are verified with the public key and HS256 ones refused
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	v, err = NewJWTValidator(JWTConfig{RSAKey: &rsaKey.PublicKey})
+	require.NoError(t, err)
+	v.now = func() time.Time { return now }
+	identity, err = v.Validate(signJWT(t, "RS256", claims, rsaKey))
+	require.NoError(t, err)
+	assert.Equal(t, "user-1", identity.Subject)
+	_, err = v.Validate(signJWT(t, "HS256", claims, hmacKey))
+	assert.ErrorIs(t, err, ErrInvalidCredentials)
+
+	// Test: Validators need exactly one good key
+	for _, config := range []JWTConfig{{}, {HMACKey: []byte("short")}, {HMACKey: hmacKey, RSAKey: &rsaKey.PublicKey}} {
+		_, err = NewJWTValidator(config)
+		assert.Error(t, err)
+	}
+
+	// Test: Bearer auth passes tokens to the validator and keeps the claims
+	handler := Auth(func(w *response.Writer, req *request.Request) {
+		identity, _ := IdentityFrom(req.Context())
+		if identity.Claims["scope"] != "read" {
+			panic(errors.New("claims missing"))
+		}
+		whoami(w, req)
+	}, BearerAuth("api", v.Validate))
+	res := authGet(t, handler, "GET / HTTP/1.1\r\nHost: localhost\r\nAuthorization: Bearer "+signJWT(t, "RS256", claims, rsaKey)+"\r\n\r\n")
+	assert.True(t, strings.HasSuffix(res, "Bearer user-1"), res)
+}
@@ -0,0 +1,32 @@
+package middleware
+
+import (
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"httpfromtcp/internal/server"
+)
+
+// DefaultCompressMinSize is a reasonable threshold below which compression
+// saves too little to be worth it.
+const DefaultCompressMinSize = 1024
+
+var supportedEncodings = []string{"gzip", "deflate"}
+
+// Compress wraps next so compressible responses are sent gzip or deflate
+// encoded, whichever the client's Accept-Encoding prefers. Fixed-length
+// bodies shorter than minSize are sent as is; chunked bodies are compressed
+// chunk by chunk. Headers held back for compression are sent as they are if
+// next returns without writing a body.
+func Compress(minSize int, next server.Handler) server.Handler {
+	return func(w *response.Writer, req *request.Request) {
+		w.Header().Set("Vary", "Accept-Encoding")
+
+		acceptEncoding, _ := req.Headers.Get("Accept-Encoding")
+		if encoding := response.NegotiateEncoding(acceptEncoding, supportedEncodings); encoding != "" {
+			w.SetCompression(encoding, minSize)
+		}
+
+		next(w, req)
+		w.FlushHeaders()
+	}
+}
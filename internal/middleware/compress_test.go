@@ -0,0 +1,131 @@
+package middleware
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var page = strings.Repeat("<p>Your request was an absolute banger.</p>\n", 100)
+
+func htmlHandler(w *response.Writer, _ *request.Request) {
+	body := []byte(page)
+	headers := response.GetDefaultHeaders(len(body))
+	headers.Update("content-type", "text/html")
+	w.WriteStatusLine(response.OK)
+	w.WriteHeaders(headers)
+	w.WriteBody(body)
+}
+
+func chunkedHandler(w *response.Writer, _ *request.Request) {
+	headers := response.GetDefaultHeaders(0)
+	headers.Delete("content-length")
+	headers.Set("Transfer-Encoding", "chunked")
+	headers.Update("content-type", "application/json")
+	w.WriteStatusLine(response.OK)
+	w.WriteHeaders(headers)
+	for range 10 {
+		w.WriteChunkedBody([]byte(`{"message": "hello, world"}` + "\n"))
+	}
+	w.WriteChunkedBodyDone()
+	w.WriteTrailers(response.GetTrailersFromHeader(headers))
+}
+
+func serveCompressed(t *testing.T, handler func(*response.Writer, *request.Request), acceptEncoding string) *http.Response {
+	out := &bytes.Buffer{}
+	raw := "GET / HTTP/1.1\r\nHost: localhost\r\n"
+	if acceptEncoding != "" {
+		raw += "Accept-Encoding: " + acceptEncoding + "\r\n"
+	}
+	Compress(DefaultCompressMinSize, handler)(response.NewWriter(out), newTestRequest(t, raw+"\r\n"))
+
+	res, err := http.ReadResponse(bufio.NewReader(out), nil)
+	require.NoError(t, err)
+	return res
+}
+
+func TestCompress(t *testing.T) {
+
+	// Test: Fixed-length body is gzipped with the compressed length
+	res := serveCompressed(t, htmlHandler, "deflate;q=0.5, gzip")
+	assert.Equal(t, "gzip", res.Header.Get("Content-Encoding"))
+	assert.Equal(t, "Accept-Encoding", res.Header.Get("Vary"))
+	assert.Less(t, res.ContentLength, int64(len(page)))
+	reader, err := gzip.NewReader(res.Body)
+	require.NoError(t, err)
+	body, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	assert.Equal(t, page, string(body))
+
+	// Test: Chunked body is deflated
+	res = serveCompressed(t, chunkedHandler, "gzip;q=0.1, deflate")
+	assert.Equal(t, "deflate", res.Header.Get("Content-Encoding"))
+	assert.Equal(t, []string{"chunked"}, res.TransferEncoding)
+	zreader, err := zlib.NewReader(res.Body)
+	require.NoError(t, err)
+	body, err = io.ReadAll(zreader)
+	require.NoError(t, err)
+	assert.Equal(t, strings.Repeat(`{"message": "hello, world"}`+"\n", 10), string(body))
+
+	// Test: No Accept-Encoding sends the body as is
+	res = serveCompressed(t, htmlHandler, "")
+	assert.Empty(t, res.Header.Get("Content-Encoding"))
+	assert.Equal(t, "Accept-Encoding", res.Header.Get("Vary"))
+	body, err = io.ReadAll(res.Body)
+	require.NoError(t, err)
+	assert.Equal(t, page, string(body))
+
+	// Test: Small bodies and incompressible types are sent as is
+	res = serveCompressed(t, okHandler, "gzip")
+	assert.Empty(t, res.Header.Get("Content-Encoding"))
+	body, err = io.ReadAll(res.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(body))
+
+	res = serveCompressed(t, func(w *response.Writer, _ *request.Request) {
+		body := bytes.Repeat([]byte{0}, 4096)
+		headers := response.GetDefaultHeaders(len(body))
+		headers.Update("content-type", "video/mp4")
+		w.WriteStatusLine(response.OK)
+		w.WriteHeaders(headers)
+		w.WriteBody(body)
+	}, "gzip")
+	assert.Empty(t, res.Header.Get("Content-Encoding"))
+	assert.Equal(t, int64(4096), res.ContentLength)
+
+	// Test: Headers are sent when the handler writes no body
+	res = serveCompressed(t, func(w *response.Writer, _ *request.Request) {
+		w.DiscardBody()
+		headers := response.GetDefaultHeaders(len(page))
+		headers.Update("content-type", "text/html")
+		w.WriteStatusLine(response.OK)
+		w.WriteHeaders(headers)
+	}, "gzip")
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Empty(t, res.Header.Get("Content-Encoding"))
+	assert.Equal(t, "text/html", res.Header.Get("Content-Type"))
+	assert.Equal(t, int64(len(page)), res.ContentLength)
+}
+
+func TestNegotiateEncoding(t *testing.T) {
+	supported := []string{"gzip", "deflate"}
+
+	assert.Equal(t, "gzip", response.NegotiateEncoding("gzip, deflate", supported))
+	assert.Equal(t, "deflate", response.NegotiateEncoding("gzip;q=0.5, deflate;q=0.8", supported))
+	assert.Equal(t, "deflate", response.NegotiateEncoding("br, deflate", supported))
+	assert.Equal(t, "gzip", response.NegotiateEncoding("*", supported))
+	assert.Equal(t, "deflate", response.NegotiateEncoding("*;q=0.5, gzip;q=0", supported))
+	assert.Equal(t, "", response.NegotiateEncoding("gzip;q=0, deflate;q=0", supported))
+	assert.Equal(t, "", response.NegotiateEncoding("identity", supported))
+	assert.Equal(t, "", response.NegotiateEncoding("", supported))
+}
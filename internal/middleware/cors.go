@@ -0,0 +1,220 @@
+package middleware
+
+import (
+	"errors"
+	"fmt"
+	"httpfromtcp/internal/headers"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"httpfromtcp/internal/server"
+	"log"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var defaultCORSMethods = []string{"GET", "HEAD", "POST"}
+
+type CORSConfig struct {
+	// AllowedOrigins lists origins such as "https://example.com". A "*" in
+	// place of the leftmost labels, as in "https://*.example.com", matches any
+	// subdomain, and "*" alone matches every origin.
+	AllowedOrigins []string
+	// AllowedOriginPatterns are regular expressions matched against the
+	// whole origin.
+	AllowedOriginPatterns []string
+	// AllowedMethods defaults to GET, HEAD and POST.
+	AllowedMethods []string
+	// AllowedHeaders lists the request headers clients may send; "*"
+	// allows any.
+	AllowedHeaders []string
+	// ExposedHeaders lists the response headers scripts may read besides
+	// the CORS-safelisted ones.
+	ExposedHeaders []string
+	// AllowCredentials lets scripts send cookies and read the responses to
+	// such requests. It can't be combined with the "*" origin.
+	AllowCredentials bool
+	// MaxAge is how long browsers may cache preflight results; zero leaves
+	// it to the browser.
+	MaxAge time.Duration
+}
+
+// CORS wraps next so browsers let scripts from the allowed origins call it.
+// Preflight requests are answered with 204 No Content without reaching next;
+// for other requests the Access-Control headers are added to next's
+// response. Origins that aren't allowed get responses without them, which
+// browsers keep from the script.
+func CORS(config CORSConfig, next server.Handler) (server.Handler, error) {
+	c, err := newCORSPolicy(config)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(w *response.Writer, req *request.Request) {
+		header := w.Header()
+		if !c.anyOrigin {
+			// The response depends on the Origin whether or not this request
+			// has one, so caches must not reuse it for other origins.
+			header.Set("Vary", "Origin")
+		}
+
+		origin, ok := req.Headers.Get("Origin")
+		if !ok {
+			next(w, req)
+			return
+		}
+
+		requestMethod, preflight := req.Headers.Get("Access-Control-Request-Method")
+		if req.RequestLine.Method == "OPTIONS" && preflight {
+			header.Set("Vary", "Access-Control-Request-Method, Access-Control-Request-Headers")
+			requestHeaders, _ := req.Headers.Get("Access-Control-Request-Headers")
+			if c.allowOrigin(origin) && c.allowMethod(requestMethod) && c.allowHeaders(requestHeaders) {
+				c.setOriginHeaders(header, origin)
+				header.Set("Access-Control-Allow-Methods", strings.Join(c.methods, ", "))
+				if requestHeaders != "" {
+					header.Set("Access-Control-Allow-Headers", requestHeaders)
+				}
+				if c.maxAge > 0 {
+					header.Set("Access-Control-Max-Age", strconv.Itoa(c.maxAge))
+				}
+			}
+			writeNoContent(w)
+			return
+		}
+
+		if c.allowOrigin(origin) {
+			c.setOriginHeaders(header, origin)
+			if len(c.exposed) > 0 {
+				header.Set("Access-Control-Expose-Headers", strings.Join(c.exposed, ", "))
+			}
+		}
+		next(w, req)
+	}, nil
+}
+
+type corsPolicy struct {
+	origins     []string
+	patterns    []*regexp.Regexp
+	anyOrigin   bool
+	methods     []string
+	headers     []string
+	anyHeader   bool
+	exposed     []string
+	credentials bool
+	maxAge      int
+}
+
+func newCORSPolicy(config CORSConfig) (*corsPolicy, error) {
+	c := &corsPolicy{
+		methods:     config.AllowedMethods,
+		exposed:     config.ExposedHeaders,
+		credentials: config.AllowCredentials,
+		maxAge:      int(config.MaxAge / time.Second),
+	}
+	if len(c.methods) == 0 {
+		c.methods = defaultCORSMethods
+	}
+
+	for _, origin := range config.AllowedOrigins {
+		if origin == "*" {
+			c.anyOrigin = true
+			continue
+		}
+		c.origins = append(c.origins, strings.ToLower(origin))
+	}
+	if c.anyOrigin && c.credentials {
+		return nil, errors.New("CORS can't allow credentials for any origin")
+	}
+	for _, pattern := range config.AllowedOriginPatterns {
+		re, err := regexp.Compile("^(?:" + pattern + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("Error in CORS origin pattern %q: %w", pattern, err)
+		}
+		c.patterns = append(c.patterns, re)
+	}
+
+	for _, name := range config.AllowedHeaders {
+		if name == "*" {
+			c.anyHeader = true
+			continue
+		}
+		c.headers = append(c.headers, strings.ToLower(name))
+	}
+	return c, nil
+}
+
+func (c *corsPolicy) allowOrigin(origin string) bool {
+	if c.anyOrigin {
+		return true
+	}
+	origin = strings.ToLower(origin)
+	for _, allowed := range c.origins {
+		if matchOrigin(allowed, origin) {
+			return true
+		}
+	}
+	for _, re := range c.patterns {
+		if re.MatchString(origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchOrigin matches origin against an allowed origin that may have a
+// "*." wildcard label after the scheme.
+func matchOrigin(allowed, origin string) bool {
+	scheme, rest, ok := strings.Cut(allowed, "://*.")
+	if !ok {
+		return allowed == origin
+	}
+	prefix, suffix := scheme+"://", "."+rest
+	return len(origin) > len(prefix)+len(suffix) &&
+		strings.HasPrefix(origin, prefix) && strings.HasSuffix(origin, suffix)
+}
+
+func (c *corsPolicy) allowMethod(method string) bool {
+	return slices.Contains(c.methods, method)
+}
+
+// allowHeaders reports whether all headers in an
+// Access-Control-Request-Headers list are allowed.
+func (c *corsPolicy) allowHeaders(list string) bool {
+	if c.anyHeader {
+		return true
+	}
+	for name := range strings.SplitSeq(list, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name != "" && !slices.Contains(c.headers, name) {
+			return false
+		}
+	}
+	return true
+}
+
+func (c *corsPolicy) setOriginHeaders(header headers.Headers, origin string) {
+	if c.anyOrigin {
+		header.Set("Access-Control-Allow-Origin", "*")
+		return
+	}
+	header.Set("Access-Control-Allow-Origin", origin)
+	if c.credentials {
+		header.Set("Access-Control-Allow-Credentials", "true")
+	}
+}
+
+// writeNoContent answers with 204 No Content, which has no body or
+// Content-Length.
+func writeNoContent(w *response.Writer) {
+	if err := w.WriteStatusLine(response.NoContent); err != nil {
+		log.Printf("error sending response status line: %s", err)
+		return
+	}
+	if err := w.WriteHeaders(headers.NewHeaders()); err != nil {
+		log.Printf("error sending headers: %s", err)
+		return
+	}
+	w.WriteBody(nil)
+}
@@ -0,0 +1,97 @@
+package middleware
+
+import (
+	"bytes"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func corsRequest(t *testing.T, handler func(*response.Writer, *request.Request), method string, extra ...string) string {
+	raw := method + " /api HTTP/1.1\r\nHost: api.example.com\r\n"
+	for _, line := range extra {
+		raw += line + "\r\n"
+	}
+	out := &bytes.Buffer{}
+	handler(response.NewWriter(out), newTestRequest(t, raw+"\r\n"))
+	return out.String()
+}
+
+func TestCORS(t *testing.T) {
+	handler, err := CORS(CORSConfig{
+		AllowedOrigins:        []string{"https://app.example.com", "https://*.example.org"},
+		AllowedOriginPatterns: []string{`https://pr-\d+\.preview\.example\.net`},
+		AllowedMethods:        []string{"GET", "PUT", "DELETE"},
+		AllowedHeaders:        []string{"Content-Type", "Authorization"},
+		ExposedHeaders:        []string{"X-Request-Id"},
+		AllowCredentials:      true,
+		MaxAge:                10 * time.Minute,
+	}, okHandler)
+	require.NoError(t, err)
+
+	// Test: Allowed origins get their origin back along with credentials and exposed headers
+	for _, origin := range []string{"https://app.example.com", "https://a.b.example.org", "https://pr-42.preview.example.net"} {
+		res := corsRequest(t, handler, "GET", "Origin: "+origin)
+		assert.True(t, strings.HasPrefix(res, "HTTP/1.1 200 OK\r\n"), origin)
+		assert.Contains(t, res, "access-control-allow-origin: "+origin+"\r\n")
+		assert.Contains(t, res, "access-control-allow-credentials: true\r\n")
+		assert.Contains(t, res, "access-control-expose-headers: X-Request-Id\r\n")
+		assert.Contains(t, res, "vary: Origin\r\n")
+	}
+
+	// Test: Other origins and requests without Origin reach the handler without CORS headers but with Vary
+	for _, origin := range []string{"Origin: https://evil.com", "Origin: https://example.org", "Origin: http://app.example.com", "Origin: https://pr-x.preview.example.net", ""} {
+		res := corsRequest(t, handler, "GET", origin)
+		assert.True(t, strings.HasPrefix(res, "HTTP/1.1 200 OK\r\n"), origin)
+		assert.NotContains(t, res, "access-control-", origin)
+		assert.Contains(t, res, "vary: Origin\r\n")
+	}
+
+	// Test: Preflights are answered with 204 and the allowed methods and headers
+	res := corsRequest(t, handler, "OPTIONS", "Origin: https://app.example.com", "Access-Control-Request-Method: PUT", "Access-Control-Request-Headers: content-type, authorization")
+	assert.True(t, strings.HasPrefix(res, "HTTP/1.1 204 No Content\r\n"))
+	assert.NotContains(t, res, "content-length")
+	assert.NotContains(t, res, "hello")
+	assert.Contains(t, res, "access-control-allow-origin: https://app.example.com\r\n")
+	assert.Contains(t, res, "access-control-allow-methods: GET, PUT, DELETE\r\n")
+	assert.Contains(t, res, "access-control-allow-headers: content-type, authorization\r\n")
+	assert.Contains(t, res, "access-control-max-age: 600\r\n")
+	assert.Contains(t, res, "vary: Origin, Access-Control-Request-Method, Access-Control-Request-Headers\r\n")
+
+	// Test: Preflights for other methods, headers or origins get 204 without CORS headers
+	for _, lines := range [][]string{
+		{"Origin: https://app.example.com", "Access-Control-Request-Method: PATCH"},
+		{"Origin: https://app.example.com", "Access-Control-Request-Method: GET", "Access-Control-Request-Headers: x-custom"},
+		{"Origin: https://evil.com", "Access-Control-Request-Method: GET"},
+	} {
+		res = corsRequest(t, handler, "OPTIONS", lines...)
+		assert.True(t, strings.HasPrefix(res, "HTTP/1.1 204 No Content\r\n"))
+		assert.NotContains(t, res, "access-control-", lines)
+	}
+
+	// Test: OPTIONS requests that aren't preflights reach the handler
+	res = corsRequest(t, handler, "OPTIONS", "Origin: https://app.example.com")
+	assert.True(t, strings.HasSuffix(res, "hello"))
+
+	// Test: Any origin is answered with * and no Vary, and any header is allowed
+	handler, err = CORS(CORSConfig{AllowedOrigins: []string{"*"}, AllowedHeaders: []string{"*"}}, okHandler)
+	require.NoError(t, err)
+	res = corsRequest(t, handler, "GET", "Origin: https://anyone.example")
+	assert.Contains(t, res, "access-control-allow-origin: *\r\n")
+	assert.NotContains(t, res, "vary")
+	assert.NotContains(t, res, "access-control-allow-credentials")
+	res = corsRequest(t, handler, "OPTIONS", "Origin: https://anyone.example", "Access-Control-Request-Method: POST", "Access-Control-Request-Headers: x-anything")
+	assert.Contains(t, res, "access-control-allow-methods: GET, HEAD, POST\r\n")
+	assert.Contains(t, res, "access-control-allow-headers: x-anything\r\n")
+
+	// Test: Credentials for any origin and bad patterns are refused
+	_, err = CORS(CORSConfig{AllowedOrigins: []string{"*"}, AllowCredentials: true}, okHandler)
+	assert.Error(t, err)
+	_, err = CORS(CORSConfig{AllowedOriginPatterns: []string{"("}}, okHandler)
+	assert.Error(t, err)
+}
@@ -0,0 +1,116 @@
+package middleware
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"httpfromtcp/internal/headers"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"httpfromtcp/internal/server"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultMaxDecodedBytes = 10 << 20
+	defaultMaxRatio        = 100
+)
+
+var (
+	errUnsupportedEncoding = errors.New("unsupported content encoding")
+	errDecodedTooLarge     = errors.New("decoded body too large")
+	errMalformedEncoding   = errors.New("malformed encoded body")
+)
+
+type DecompressConfig struct {
+	// MaxDecodedBytes caps the size of the decoded body, defaulting to 10 MiB.
+	MaxDecodedBytes int64
+	// MaxRatio caps decoded size divided by encoded size, defaulting to 100.
+	MaxRatio int64
+}
+
+// Decompress wraps next so request bodies sent with a gzip or deflate
+// Content-Encoding reach it decoded, with Content-Encoding removed and
+// Content-Length updated. Bodies that decode beyond the configured size or
+// ratio are rejected with 413, unknown encodings with 415.
+func Decompress(config DecompressConfig, next server.Handler) server.Handler {
+	if config.MaxDecodedBytes <= 0 {
+		config.MaxDecodedBytes = defaultMaxDecodedBytes
+	}
+	if config.MaxRatio <= 0 {
+		config.MaxRatio = defaultMaxRatio
+	}
+
+	return func(w *response.Writer, req *request.Request) {
+		contentEncoding, ok := req.Headers.Get("Content-Encoding")
+		if !ok {
+			next(w, req)
+			return
+		}
+
+		body, err := decodeBody(req.Body, contentEncoding, config)
+		switch {
+		case errors.Is(err, errUnsupportedEncoding):
+			w.Header().Set("Accept-Encoding", strings.Join(supportedEncodings, ", "))
+			writeStatus(w, response.UnsupportedMediaType, err.Error())
+			return
+		case errors.Is(err, errDecodedTooLarge):
+			writeStatus(w, response.ContentTooLarge, err.Error())
+			return
+		case err != nil:
+			writeStatus(w, response.BadRequest, err.Error())
+			return
+		}
+
+		decoded := *req
+		decoded.Body = body
+		decoded.Headers = headers.NewHeaders()
+		for key, value := range req.Headers {
+			decoded.Headers.Update(key, value)
+		}
+		decoded.Headers.Delete("Content-Encoding")
+		decoded.Headers.Update("Content-Length", strconv.Itoa(len(body)))
+
+		next(w, &decoded)
+	}
+}
+
+// decodeBody undoes the codings listed in contentEncoding, last applied first.
+func decodeBody(body []byte, contentEncoding string, config DecompressConfig) ([]byte, error) {
+	codings := strings.Split(contentEncoding, ",")
+
+	for i := len(codings) - 1; i >= 0; i-- {
+		coding := strings.ToLower(strings.TrimSpace(codings[i]))
+
+		var reader io.Reader
+		var err error
+		switch coding {
+		case "identity", "":
+			continue
+		case "gzip", "x-gzip":
+			reader, err = gzip.NewReader(bytes.NewReader(body))
+		case "deflate":
+			reader, err = zlib.NewReader(bytes.NewReader(body))
+		default:
+			return nil, errUnsupportedEncoding
+		}
+		if err != nil {
+			return nil, errMalformedEncoding
+		}
+
+		limit := min(config.MaxDecodedBytes, int64(len(body))*config.MaxRatio)
+		decoded, err := io.ReadAll(io.LimitReader(reader, limit+1))
+		if err != nil {
+			return nil, errMalformedEncoding
+		}
+		if int64(len(decoded)) > limit {
+			return nil, errDecodedTooLarge
+		}
+		body = decoded
+	}
+
+	return body, nil
+}
@@ -0,0 +1,94 @@
+package middleware
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func gzipped(t *testing.T, data []byte) []byte {
+	buf := &bytes.Buffer{}
+	w := gzip.NewWriter(buf)
+	_, err := w.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	return buf.Bytes()
+}
+
+func deflated(t *testing.T, data []byte) []byte {
+	buf := &bytes.Buffer{}
+	w := zlib.NewWriter(buf)
+	_, err := w.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	return buf.Bytes()
+}
+
+func postEncoded(t *testing.T, handler func(*response.Writer, *request.Request), encoding string, body []byte) string {
+	raw := "POST /upload HTTP/1.1\r\n" +
+		"Host: localhost\r\n" +
+		"Content-Encoding: " + encoding + "\r\n" +
+		"Content-Length: " + strconv.Itoa(len(body)) + "\r\n" +
+		"\r\n" + string(body)
+
+	out := &bytes.Buffer{}
+	handler(response.NewWriter(out), newTestRequest(t, raw))
+	return out.String()
+}
+
+func TestDecompress(t *testing.T) {
+	var received *request.Request
+	echo := func(w *response.Writer, req *request.Request) {
+		received = req
+		okHandler(w, req)
+	}
+	handler := Decompress(DecompressConfig{MaxDecodedBytes: 1024, MaxRatio: 50}, echo)
+
+	// Test: gzip body is decoded
+	res := postEncoded(t, handler, "gzip", gzipped(t, []byte("hello world!\n")))
+	assert.True(t, strings.HasPrefix(res, "HTTP/1.1 200 OK\r\n"))
+	assert.Equal(t, "hello world!\n", string(received.Body))
+	_, ok := received.Headers.Get("Content-Encoding")
+	assert.False(t, ok)
+	contentLength, _ := received.Headers.Get("Content-Length")
+	assert.Equal(t, "13", contentLength)
+
+	// Test: Stacked codings are undone in reverse order
+	res = postEncoded(t, handler, "deflate, gzip", gzipped(t, deflated(t, []byte("stacked"))))
+	assert.True(t, strings.HasPrefix(res, "HTTP/1.1 200 OK\r\n"))
+	assert.Equal(t, "stacked", string(received.Body))
+
+	// Test: Unsupported encoding gets 415 with Accept-Encoding
+	received = nil
+	res = postEncoded(t, handler, "br", []byte("whatever"))
+	assert.True(t, strings.HasPrefix(res, "HTTP/1.1 415 Unsupported Media Type\r\n"))
+	assert.Contains(t, res, "accept-encoding: gzip, deflate\r\n")
+	assert.Nil(t, received)
+
+	// Test: Body over the size limit gets 413
+	res = postEncoded(t, handler, "gzip", gzipped(t, []byte(strings.Repeat("a", 2000))))
+	assert.True(t, strings.HasPrefix(res, "HTTP/1.1 413 Content Too Large\r\n"))
+
+	// Test: Body over the ratio limit gets 413
+	bomb := gzipped(t, bytes.Repeat([]byte{0}, 1000))
+	require.Less(t, len(bomb)*10, 1000)
+	res = postEncoded(t, Decompress(DecompressConfig{MaxRatio: 10}, echo), "gzip", bomb)
+	assert.True(t, strings.HasPrefix(res, "HTTP/1.1 413 Content Too Large\r\n"))
+
+	// Test: Corrupt body gets 400
+	res = postEncoded(t, handler, "gzip", []byte("not gzip at all"))
+	assert.True(t, strings.HasPrefix(res, "HTTP/1.1 400 Bad Request\r\n"))
+
+	// Test: Requests without Content-Encoding pass through
+	out := &bytes.Buffer{}
+	handler(response.NewWriter(out), newTestRequest(t, "POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 5\r\n\r\nplain"))
+	assert.Equal(t, "plain", string(received.Body))
+}
@@ -0,0 +1,158 @@
+package middleware
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+type JWTConfig struct {
+	// HMACKey verifies HS256 tokens and RSAKey RS256 tokens. Exactly one of
+	// them must be set, and tokens signed with the other algorithm are
+	// refused.
+	HMACKey []byte
+	RSAKey  *rsa.PublicKey
+	// Issuer and Audience, when set, must match the iss and aud claims.
+	Issuer   string
+	Audience string
+	// Leeway allows for clock skew when checking exp and nbf.
+	Leeway time.Duration
+}
+
+// JWTValidator verifies JSON Web Tokens signed with a local key, for use
+// with BearerAuth.
+type JWTValidator struct {
+	config JWTConfig
+	alg    string
+	now    func() time.Time
+}
+
+func NewJWTValidator(config JWTConfig) (*JWTValidator, error) {
+	v := &JWTValidator{config: config, now: time.Now}
+	switch {
+	case config.HMACKey != nil && config.RSAKey != nil:
+		return nil, errors.New("JWT validator needs either an HMAC or an RSA key, not both")
+	case config.HMACKey != nil:
+		if len(config.HMACKey) < 32 {
+			return nil, errors.New("JWT HMAC key is shorter than 32 bytes")
+		}
+		v.alg = "HS256"
+	case config.RSAKey != nil:
+		v.alg = "RS256"
+	default:
+		return nil, errors.New("JWT validator needs an HMAC or an RSA key")
+	}
+	return v, nil
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+}
+
+// Validate checks token's signature, its exp and nbf claims and the issuer
+// and audience, and returns an identity with the sub claim as Subject.
+func (v *JWTValidator) Validate(token string) (*Identity, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("%w: malformed JWT", ErrInvalidCredentials)
+	}
+
+	var header jwtHeader
+	if err := decodeJWTPart(parts[0], &header); err != nil {
+		return nil, err
+	}
+	// Only the configured algorithm is accepted, so "none" or an RSA public
+	// key used as an HMAC secret get nowhere.
+	if header.Alg != v.alg {
+		return nil, fmt.Errorf("%w: JWT algorithm %q, expected %s", ErrInvalidCredentials, header.Alg, v.alg)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: malformed JWT signature", ErrInvalidCredentials)
+	}
+	if !v.verify(parts[0]+"."+parts[1], signature) {
+		return nil, fmt.Errorf("%w: bad JWT signature", ErrInvalidCredentials)
+	}
+
+	var claims map[string]any
+	if err := decodeJWTPart(parts[1], &claims); err != nil {
+		return nil, err
+	}
+	if err := v.checkClaims(claims); err != nil {
+		return nil, err
+	}
+
+	subject, _ := claims["sub"].(string)
+	return &Identity{Subject: subject, Scheme: "Bearer", Claims: claims}, nil
+}
+
+func (v *JWTValidator) verify(signed string, signature []byte) bool {
+	if v.alg == "HS256" {
+		mac := hmac.New(sha256.New, v.config.HMACKey)
+		mac.Write([]byte(signed))
+		return hmac.Equal(signature, mac.Sum(nil))
+	}
+	digest := sha256.Sum256([]byte(signed))
+	return rsa.VerifyPKCS1v15(v.config.RSAKey, crypto.SHA256, digest[:], signature) == nil
+}
+
+func (v *JWTValidator) checkClaims(claims map[string]any) error {
+	now := v.now()
+	if exp, ok := claims["exp"]; ok {
+		seconds, ok := exp.(float64)
+		if !ok {
+			return fmt.Errorf("%w: JWT exp is not a number", ErrInvalidCredentials)
+		}
+		if !now.Before(time.Unix(int64(seconds), 0).Add(v.config.Leeway)) {
+			return fmt.Errorf("%w: JWT expired", ErrInvalidCredentials)
+		}
+	}
+	if nbf, ok := claims["nbf"]; ok {
+		seconds, ok := nbf.(float64)
+		if !ok {
+			return fmt.Errorf("%w: JWT nbf is not a number", ErrInvalidCredentials)
+		}
+		if now.Add(v.config.Leeway).Before(time.Unix(int64(seconds), 0)) {
+			return fmt.Errorf("%w: JWT not valid yet", ErrInvalidCredentials)
+		}
+	}
+
+	if v.config.Issuer != "" && claims["iss"] != v.config.Issuer {
+		return fmt.Errorf("%w: JWT issuer %v", ErrInvalidCredentials, claims["iss"])
+	}
+	if v.config.Audience != "" && !hasAudience(claims["aud"], v.config.Audience) {
+		return fmt.Errorf("%w: JWT audience %v", ErrInvalidCredentials, claims["aud"])
+	}
+	return nil
+}
+
+// hasAudience reports whether the aud claim, a string or an array of them,
+// names audience.
+func hasAudience(aud any, audience string) bool {
+	switch aud := aud.(type) {
+	case string:
+		return aud == audience
+	case []any:
+		return slices.Contains(aud, any(audience))
+	}
+	return false
+}
+
+func decodeJWTPart(part string, v any) error {
+	data, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return fmt.Errorf("%w: malformed JWT", ErrInvalidCredentials)
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("%w: malformed JWT", ErrInvalidCredentials)
+	}
+	return nil
+}
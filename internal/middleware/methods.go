@@ -0,0 +1,55 @@
+package middleware
+
+import (
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"httpfromtcp/internal/server"
+	"slices"
+	"strings"
+)
+
+// MethodsFunc returns the methods the resource a request targets supports,
+// or nil if that's up to the handler. For the "*" target of OPTIONS * it
+// returns the methods the server supports anywhere.
+type MethodsFunc func(req *request.Request) []string
+
+// AllowMethods wraps next so OPTIONS requests, including OPTIONS *, are
+// answered with 204 No Content and an Allow header listing the supported
+// methods. HEAD is listed wherever GET is, and OPTIONS everywhere. Requests
+// with other methods, and OPTIONS requests for which allowed returns nil, go
+// to next unchanged.
+func AllowMethods(allowed MethodsFunc, next server.Handler) server.Handler {
+	return func(w *response.Writer, req *request.Request) {
+		if req.RequestLine.Method != "OPTIONS" {
+			next(w, req)
+			return
+		}
+		methods := allowed(req)
+		if methods == nil {
+			next(w, req)
+			return
+		}
+
+		w.Header().Set("Allow", strings.Join(withImplicitMethods(methods), ", "))
+		writeNoContent(w)
+	}
+}
+
+// withImplicitMethods adds HEAD after GET and OPTIONS at the end, unless
+// they are listed already.
+func withImplicitMethods(methods []string) []string {
+	result := make([]string, 0, len(methods)+2)
+	for _, method := range methods {
+		if slices.Contains(result, method) {
+			continue
+		}
+		result = append(result, method)
+		if method == "GET" && !slices.Contains(methods, "HEAD") {
+			result = append(result, "HEAD")
+		}
+	}
+	if !slices.Contains(result, "OPTIONS") {
+		result = append(result, "OPTIONS")
+	}
+	return result
+}
@@ -0,0 +1,60 @@
+package middleware
+
+import (
+	"bytes"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAllowMethods(t *testing.T) {
+	allowed := func(req *request.Request) []string {
+		switch req.RequestLine.RequestTarget {
+		case "*":
+			return []string{"GET", "POST", "DELETE"}
+		case "/items":
+			return []string{"GET", "POST"}
+		case "/upload":
+			return []string{"PUT", "OPTIONS"}
+		}
+		return nil
+	}
+	handler := AllowMethods(allowed, okHandler)
+	send := func(method, target string) string {
+		out := &bytes.Buffer{}
+		handler(response.NewWriter(out), newTestRequest(t, method+" "+target+" HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+		return out.String()
+	}
+
+	// Test: Allowed methods, and HEAD wherever GET is, reach the handler
+	for _, method := range []string{"GET", "HEAD", "POST"} {
+		assert.True(t, strings.HasSuffix(send(method, "/items"), "hello"), method)
+	}
+
+	// Test: OPTIONS lists a route's methods with 204
+	res := send("OPTIONS", "/items")
+	assert.True(t, strings.HasPrefix(res, "HTTP/1.1 204 No Content\r\n"))
+	assert.Contains(t, res, "allow: GET, HEAD, POST, OPTIONS\r\n")
+	assert.NotContains(t, res, "hello")
+	res = send("OPTIONS", "/upload")
+	assert.Contains(t, res, "allow: PUT, OPTIONS\r\n")
+
+	// Test: OPTIONS * lists the server's methods
+	res = send("OPTIONS", "*")
+	assert.True(t, strings.HasPrefix(res, "HTTP/1.1 204 No Content\r\n"))
+	assert.Contains(t, res, "allow: GET, HEAD, POST, DELETE, OPTIONS\r\n")
+
+	// Test: Methods a route doesn't list are left to the handler
+	for _, target := range []string{"/items", "/upload", "*"} {
+		res = send("DELETE", target)
+		assert.True(t, strings.HasSuffix(res, "hello"), target)
+		assert.NotContains(t, res, "allow: ", target)
+	}
+
+	// Test: Targets without known methods go to the handler
+	assert.True(t, strings.HasSuffix(send("OPTIONS", "/other"), "hello"))
+	assert.True(t, strings.HasSuffix(send("PATCH", "/other"), "hello"))
+}
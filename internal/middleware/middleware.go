@@ -0,0 +1,31 @@
+// Package middleware holds server.Handler wrappers that add cross-cutting
+// behaviour such as logging, rate limiting and compression.
+package middleware
+
+import (
+	"httpfromtcp/internal/response"
+	"log"
+)
+
+// writeStatus answers with status and a short plain text body.
+func writeStatus(w *response.Writer, status response.StatusCode, text string) {
+	body := []byte(text)
+
+	err := w.WriteStatusLine(status)
+	if err != nil {
+		log.Printf("error sending response status line: %s", err)
+		return
+	}
+
+	err = w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+	if err != nil {
+		log.Printf("error sending headers: %s", err)
+		return
+	}
+
+	_, err = w.WriteBody(body)
+	if err != nil {
+		log.Printf("error writing body: %s", err)
+		return
+	}
+}
@@ -0,0 +1,186 @@
+package middleware
+
+import (
+	"container/list"
+	"fmt"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"httpfromtcp/internal/server"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const defaultMaxKeys = 10000
+
+// KeyFunc picks the bucket a request is counted against. Requests for which
+// it returns "" are not limited.
+type KeyFunc func(req *request.Request) string
+
+// KeyByRemoteAddr limits each client IP address separately.
+func KeyByRemoteAddr(req *request.Request) string {
+	return remoteHost(req.Context())
+}
+
+// KeyByHeader limits each value of the named header separately, e.g. an API
+// key. Requests without the header are not limited.
+func KeyByHeader(name string) KeyFunc {
+	return func(req *request.Request) string {
+		value, _ := req.Headers.Get(name)
+		return value
+	}
+}
+
+type RateLimitConfig struct {
+	// Limit requests are allowed per Window, refilled continuously.
+	Limit  int
+	Window time.Duration
+	// Burst is the bucket size, defaulting to Limit.
+	Burst int
+	// Key defaults to KeyByRemoteAddr.
+	Key KeyFunc
+	// MaxKeys bounds memory use; the least recently seen keys are dropped
+	// first. Defaults to 10000.
+	MaxKeys int
+}
+
+// RateLimit wraps next in a token bucket limiter per key. Rejected requests get
+// 429 Too Many Requests with Retry-After, and every response carries the
+// RateLimit-Limit, RateLimit-Remaining and RateLimit-Reset headers. Limit and
+// Window must be positive.
+func RateLimit(config RateLimitConfig, next server.Handler) (server.Handler, error) {
+	limiter, err := newRateLimiter(config, time.Now)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(w *response.Writer, req *request.Request) {
+		key := limiter.key(req)
+		if key == "" {
+			next(w, req)
+			return
+		}
+
+		result := limiter.take(key)
+
+		header := w.Header()
+		header.Set("RateLimit-Limit", strconv.Itoa(limiter.burst))
+		header.Set("RateLimit-Remaining", strconv.Itoa(result.remaining))
+		header.Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(result.reset)))
+
+		if result.allowed {
+			next(w, req)
+			return
+		}
+
+		header.Set("Retry-After", strconv.Itoa(ceilSeconds(result.retryAfter)))
+		writeStatus(w, response.TooManyRequests, "Too Many Requests")
+	}, nil
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+type bucket struct {
+	key     string
+	tokens  float64
+	updated time.Time
+}
+
+type rateResult struct {
+	allowed    bool
+	remaining  int
+	reset      time.Duration
+	retryAfter time.Duration
+}
+
+type rateLimiter struct {
+	key     KeyFunc
+	burst   int
+	perSec  float64
+	maxKeys int
+	now     func() time.Time
+
+	mu      sync.Mutex
+	buckets map[string]*list.Element
+	lru     *list.List
+}
+
+func newRateLimiter(config RateLimitConfig, now func() time.Time) (*rateLimiter, error) {
+	if config.Limit <= 0 {
+		return nil, fmt.Errorf("Rate limit must be positive, got %d", config.Limit)
+	}
+	if config.Window <= 0 {
+		return nil, fmt.Errorf("Rate limit window must be positive, got %s", config.Window)
+	}
+
+	limiter := &rateLimiter{
+		key:     config.Key,
+		burst:   config.Burst,
+		perSec:  float64(config.Limit) / config.Window.Seconds(),
+		maxKeys: config.MaxKeys,
+		now:     now,
+		buckets: map[string]*list.Element{},
+		lru:     list.New(),
+	}
+	if limiter.key == nil {
+		limiter.key = KeyByRemoteAddr
+	}
+	if limiter.burst <= 0 {
+		limiter.burst = config.Limit
+	}
+	if limiter.maxKeys <= 0 {
+		limiter.maxKeys = defaultMaxKeys
+	}
+	return limiter, nil
+}
+
+// take refills the key's bucket for the time since its last use and removes
+// one token if there is one.
+func (l *rateLimiter) take(key string) rateResult {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	b := l.bucket(key, now)
+
+	b.tokens = min(float64(l.burst), b.tokens+now.Sub(b.updated).Seconds()*l.perSec)
+	b.updated = now
+
+	result := rateResult{allowed: b.tokens >= 1}
+	if result.allowed {
+		b.tokens--
+	} else {
+		result.retryAfter = l.duration(1 - b.tokens)
+	}
+	result.remaining = int(b.tokens)
+	result.reset = l.duration(float64(l.burst) - b.tokens)
+
+	return result
+}
+
+// bucket returns the key's bucket, creating a full one and evicting the least
+// recently used bucket if the table is full.
+func (l *rateLimiter) bucket(key string, now time.Time) *bucket {
+	if element, ok := l.buckets[key]; ok {
+		l.lru.MoveToFront(element)
+		return element.Value.(*bucket)
+	}
+
+	if l.lru.Len() >= l.maxKeys {
+		oldest := l.lru.Back()
+		l.lru.Remove(oldest)
+		delete(l.buckets, oldest.Value.(*bucket).key)
+	}
+
+	b := &bucket{key: key, tokens: float64(l.burst), updated: now}
+	l.buckets[key] = l.lru.PushFront(b)
+	return b
+}
+
+// duration returns how long it takes to refill the given number of tokens.
+func (l *rateLimiter) duration(tokens float64) time.Duration {
+	return time.Duration(tokens / l.perSec * float64(time.Second))
+}
@@ -0,0 +1,100 @@
+package middleware
+
+import (
+	"bytes"
+	"httpfromtcp/internal/response"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRateLimit(t *testing.T) {
+	raw := "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"
+
+	// Test: Requests within the burst pass with RateLimit headers
+	handler, err := RateLimit(RateLimitConfig{Limit: 2, Window: time.Minute}, okHandler)
+	require.NoError(t, err)
+
+	out := &bytes.Buffer{}
+	handler(response.NewWriter(out), newTestRequest(t, raw))
+	res := out.String()
+	assert.True(t, strings.HasPrefix(res, "HTTP/1.1 200 OK\r\n"))
+	assert.Contains(t, res, "ratelimit-limit: 2\r\n")
+	assert.Contains(t, res, "ratelimit-remaining: 1\r\n")
+	assert.Contains(t, res, "ratelimit-reset: 30\r\n")
+
+	out.Reset()
+	handler(response.NewWriter(out), newTestRequest(t, raw))
+	assert.Contains(t, out.String(), "ratelimit-remaining: 0\r\n")
+
+	// Test: Request over the limit gets 429 with Retry-After
+	out.Reset()
+	handler(response.NewWriter(out), newTestRequest(t, raw))
+	res = out.String()
+	assert.True(t, strings.HasPrefix(res, "HTTP/1.1 429 Too Many Requests\r\n"))
+	assert.Contains(t, res, "retry-after: 30\r\n")
+	assert.Contains(t, res, "ratelimit-remaining: 0\r\n")
+
+	// Test: Header keys are limited separately, requests without the header are not limited
+	handler, err = RateLimit(RateLimitConfig{Limit: 1, Window: time.Minute, Key: KeyByHeader("X-API-Key")}, okHandler)
+	require.NoError(t, err)
+	for _, key := range []string{"a", "b"} {
+		out.Reset()
+		handler(response.NewWriter(out), newTestRequest(t, "GET / HTTP/1.1\r\nHost: localhost\r\nX-API-Key: "+key+"\r\n\r\n"))
+		assert.True(t, strings.HasPrefix(out.String(), "HTTP/1.1 200 OK\r\n"))
+	}
+	for range 3 {
+		out.Reset()
+		handler(response.NewWriter(out), newTestRequest(t, raw))
+		assert.True(t, strings.HasPrefix(out.String(), "HTTP/1.1 200 OK\r\n"))
+		assert.NotContains(t, out.String(), "ratelimit-limit")
+	}
+
+	// Test: Limits and windows that aren't positive are refused
+	for _, config := range []RateLimitConfig{
+		{Limit: 0, Window: time.Minute},
+		{Limit: -1, Window: time.Minute},
+		{Limit: 1},
+		{Limit: 1, Window: -time.Second},
+	} {
+		_, err = RateLimit(config, okHandler)
+		assert.Error(t, err, config)
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	now := time.Unix(1000, 0)
+	clock := func() time.Time { return now }
+
+	// Test: Tokens refill over time
+	limiter, err := newRateLimiter(RateLimitConfig{Limit: 10, Window: 10 * time.Second, Burst: 2}, clock)
+	require.NoError(t, err)
+	assert.True(t, limiter.take("a").allowed)
+	assert.True(t, limiter.take("a").allowed)
+	result := limiter.take("a")
+	require.False(t, result.allowed)
+	assert.Equal(t, time.Second, result.retryAfter)
+
+	now = now.Add(time.Second)
+	assert.True(t, limiter.take("a").allowed)
+	assert.False(t, limiter.take("a").allowed)
+
+	// Test: Buckets never exceed the burst
+	now = now.Add(time.Hour)
+	result = limiter.take("a")
+	assert.Equal(t, 1, result.remaining)
+
+	// Test: Key table is bounded, least recently used key is evicted
+	limiter, err = newRateLimiter(RateLimitConfig{Limit: 1, Window: time.Minute, MaxKeys: 2}, clock)
+	require.NoError(t, err)
+	assert.True(t, limiter.take("a").allowed)
+	assert.True(t, limiter.take("b").allowed)
+	assert.False(t, limiter.take("a").allowed)
+	assert.True(t, limiter.take("c").allowed)
+	assert.Equal(t, 2, limiter.lru.Len())
+	assert.NotContains(t, limiter.buckets, "b")
+	assert.True(t, limiter.take("b").allowed)
+}
@@ -0,0 +1,410 @@
+package middleware
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"httpfromtcp/internal/cookie"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"httpfromtcp/internal/server"
+	"log"
+	"maps"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultSessionCookie = "session"
+	defaultSessionMaxAge = 24 * time.Hour
+	// maxCookieBytes is the smallest cookie size browsers must support.
+	maxCookieBytes = 4096
+)
+
+type SessionConfig struct {
+	// Keys authenticate session cookies. The first key is used for new
+	// cookies and all of them are tried when reading, so keys are rotated by
+	// putting a new one first and dropping the oldest once its cookies have
+	// expired. Cookies signed with an older key are re-issued with the first.
+	Keys [][]byte
+	// Encrypt seals cookies with AES-GCM so clients can't read them, instead
+	// of signing them with HMAC-SHA256. Keys must then be 16, 24 or 32 bytes
+	// long; for signing they need at least 32.
+	Encrypt bool
+	// Store keeps session data on the server, with only the session ID in
+	// the cookie. Without a store the data travels in the cookie itself,
+	// which limits it to about 4 KB.
+	Store SessionStore
+	// MaxAge is how long a session lasts after it was last changed,
+	// defaulting to 24 hours.
+	MaxAge time.Duration
+
+	// CookieName defaults to "session"; the remaining fields are the cookie's
+	// attributes. Cookies are always HttpOnly.
+	CookieName string
+	Path       string
+	Domain     string
+	Secure     bool
+	SameSite   cookie.SameSite
+}
+
+type sessionContextKey struct{}
+
+// SessionFrom returns the session of the request whose context is ctx, as
+// set up by the Sessions middleware.
+func SessionFrom(ctx context.Context) (*Session, bool) {
+	s, ok := ctx.Value(sessionContextKey{}).(*Session)
+	return s, ok
+}
+
+// Session holds the values kept for one client across requests. It is safe
+// for concurrent use by the goroutines serving a request.
+type Session struct {
+	mu        sync.Mutex
+	id        string
+	oldID     string
+	values    map[string]string
+	expires   time.Time
+	isNew     bool
+	changed   bool
+	destroyed bool
+	// changes counts modifications and saved how many of them are in the
+	// store.
+	changes int
+	saved   int
+}
+
+// ID identifies the session; it changes with Rotate.
+func (s *Session) ID() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.id
+}
+
+// IsNew reports whether the client didn't send a valid session cookie.
+func (s *Session) IsNew() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.isNew
+}
+
+func (s *Session) Get(key string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	value, ok := s.values[key]
+	return value, ok
+}
+
+// Set stores a value; after Destroy it starts a new session.
+func (s *Session) Set(key, value string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.values[key] = value
+	s.destroyed = false
+	s.touch()
+}
+
+func (s *Session) Delete(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.values, key)
+	s.touch()
+}
+
+// Rotate gives the session a new ID while keeping its values, e.g. after a
+// login, so an ID an attacker may have planted or seen before is worthless.
+func (s *Session) Rotate() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rotate()
+	s.touch()
+}
+
+// Destroy drops the session's values and tells the client to delete its
+// cookie, e.g. on logout.
+func (s *Session) Destroy() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rotate()
+	s.values = map[string]string{}
+	s.destroyed = true
+	s.touch()
+}
+
+func (s *Session) rotate() {
+	if s.oldID == "" && !s.isNew {
+		s.oldID = s.id
+	}
+	s.id = newSessionID()
+}
+
+func (s *Session) touch() {
+	s.changed = true
+	s.changes++
+}
+
+// Sessions wraps next so handlers find a Session through SessionFrom. The
+// session cookie is sent along with the response headers whenever the
+// session changed, so changes made after the handler has written its headers
+// only reach a Store, never a cookie.
+func Sessions(config SessionConfig, next server.Handler) (server.Handler, error) {
+	codec, err := newSessionCodec(config.Keys, config.Encrypt)
+	if err != nil {
+		return nil, err
+	}
+	if config.CookieName == "" {
+		config.CookieName = defaultSessionCookie
+	}
+	if config.MaxAge <= 0 {
+		config.MaxAge = defaultSessionMaxAge
+	}
+	m := &sessionManager{config: config, codec: codec}
+
+	return func(w *response.Writer, req *request.Request) {
+		s := m.load(req)
+		w.BeforeHeaders(func() {
+			m.save(s)
+			m.setCookie(w, s)
+		})
+
+		next(w, req.WithContext(context.WithValue(req.Context(), sessionContextKey{}, s)))
+
+		// Changes made after the headers went out can still be stored.
+		m.save(s)
+	}, nil
+}
+
+type sessionManager struct {
+	config SessionConfig
+	codec  *sessionCodec
+}
+
+// sessionPayload is what a session cookie carries: the data itself, or just
+// the ID when a store holds the data.
+type sessionPayload struct {
+	ID      string            `json:"id"`
+	Expires int64             `json:"exp"`
+	Values  map[string]string `json:"values,omitempty"`
+}
+
+func (m *sessionManager) load(req *request.Request) *Session {
+	fresh := &Session{id: newSessionID(), values: map[string]string{}, isNew: true}
+
+	c, ok := req.Cookie(m.config.CookieName)
+	if !ok {
+		return fresh
+	}
+	plain, oldKey, err := m.codec.decode(m.config.CookieName, c.Value)
+	if err != nil {
+		return fresh
+	}
+	var payload sessionPayload
+	if err := json.Unmarshal(plain, &payload); err != nil || payload.ID == "" {
+		return fresh
+	}
+	expires := time.Unix(payload.Expires, 0)
+	if !time.Now().Before(expires) {
+		return fresh
+	}
+
+	values := payload.Values
+	if m.config.Store != nil {
+		values, err = m.config.Store.Get(payload.ID)
+		if err != nil {
+			if !errors.Is(err, ErrSessionNotFound) {
+				log.Printf("Error loading session: %s", err)
+			}
+			return fresh
+		}
+	}
+	if values == nil {
+		values = map[string]string{}
+	}
+
+	s := &Session{id: payload.ID, values: values, expires: expires}
+	if oldKey {
+		// Re-issue the cookie under the current key.
+		s.changed = true
+	}
+	return s
+}
+
+// save writes changed session data to the store.
+func (m *sessionManager) save(s *Session) {
+	store := m.config.Store
+	if store == nil {
+		return
+	}
+
+	s.mu.Lock()
+	if s.changes == s.saved {
+		s.mu.Unlock()
+		return
+	}
+	s.saved = s.changes
+	id, oldID, destroyed := s.id, s.oldID, s.destroyed
+	values := maps.Clone(s.values)
+	s.oldID = ""
+	expires := time.Now().Add(m.config.MaxAge)
+	s.mu.Unlock()
+
+	if oldID != "" {
+		if err := store.Delete(oldID); err != nil {
+			log.Printf("Error deleting rotated session: %s", err)
+		}
+	}
+	if destroyed {
+		return
+	}
+	if err := store.Set(id, values, expires); err != nil {
+		log.Printf("Error saving session: %s", err)
+	}
+}
+
+// setCookie adds the session cookie to the response if the session changed.
+func (m *sessionManager) setCookie(w *response.Writer, s *Session) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.changed {
+		return
+	}
+
+	c := &cookie.Cookie{
+		Name:     m.config.CookieName,
+		Path:     m.config.Path,
+		Domain:   m.config.Domain,
+		Secure:   m.config.Secure,
+		HttpOnly: true,
+		SameSite: m.config.SameSite,
+	}
+	if s.destroyed {
+		c.MaxAge = -1
+		w.SetCookie(c)
+		return
+	}
+
+	s.expires = time.Now().Add(m.config.MaxAge)
+	payload := sessionPayload{ID: s.id, Expires: s.expires.Unix()}
+	if m.config.Store == nil {
+		payload.Values = s.values
+	}
+	plain, err := json.Marshal(payload)
+	if err != nil {
+		log.Printf("Error encoding session: %s", err)
+		return
+	}
+
+	c.Value = m.codec.encode(m.config.CookieName, plain)
+	c.Expires = s.expires
+	if len(c.String()) > maxCookieBytes {
+		log.Printf("Error saving session: cookie of %d bytes over %d", len(c.String()), maxCookieBytes)
+		return
+	}
+	if err := w.SetCookie(c); err != nil {
+		log.Printf("Error saving session: %s", err)
+	}
+}
+
+func newSessionID() string {
+	id := make([]byte, 16)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// sessionCodec signs or seals cookie values with the configured keys. The
+// cookie name is authenticated too, so a value can't be moved to another
+// cookie.
+type sessionCodec struct {
+	keys  [][]byte
+	aeads []cipher.AEAD
+}
+
+func newSessionCodec(keys [][]byte, encrypt bool) (*sessionCodec, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("Sessions need at least one key")
+	}
+
+	codec := &sessionCodec{keys: keys}
+	for i, key := range keys {
+		if !encrypt {
+			if len(key) < 32 {
+				return nil, fmt.Errorf("Session signing key %d is shorter than 32 bytes", i)
+			}
+			continue
+		}
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, fmt.Errorf("Error using session key %d: %w", i, err)
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, fmt.Errorf("Error using session key %d: %w", i, err)
+		}
+		codec.aeads = append(codec.aeads, aead)
+	}
+	return codec, nil
+}
+
+func (c *sessionCodec) encode(name string, plain []byte) string {
+	if c.aeads != nil {
+		aead := c.aeads[0]
+		nonce := make([]byte, aead.NonceSize())
+		rand.Read(nonce)
+		return base64.RawURLEncoding.EncodeToString(aead.Seal(nonce, nonce, plain, []byte(name)))
+	}
+
+	data := base64.RawURLEncoding.EncodeToString(plain)
+	return data + "." + base64.RawURLEncoding.EncodeToString(c.mac(c.keys[0], name, data))
+}
+
+// decode returns the payload of value and whether it was made with a key
+// other than the first.
+func (c *sessionCodec) decode(name, value string) ([]byte, bool, error) {
+	if c.aeads != nil {
+		sealed, err := base64.RawURLEncoding.DecodeString(value)
+		if err != nil {
+			return nil, false, err
+		}
+		for i, aead := range c.aeads {
+			if len(sealed) < aead.NonceSize() {
+				break
+			}
+			nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+			if plain, err := aead.Open(nil, nonce, ciphertext, []byte(name)); err == nil {
+				return plain, i > 0, nil
+			}
+		}
+		return nil, false, errors.New("session cookie not sealed with a known key")
+	}
+
+	data, encodedMAC, ok := strings.Cut(value, ".")
+	if !ok {
+		return nil, false, errors.New("malformed session cookie")
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(encodedMAC)
+	if err != nil {
+		return nil, false, err
+	}
+	for i, key := range c.keys {
+		if hmac.Equal(mac, c.mac(key, name, data)) {
+			plain, err := base64.RawURLEncoding.DecodeString(data)
+			return plain, i > 0, err
+		}
+	}
+	return nil, false, errors.New("session cookie not signed with a known key")
+}
+
+func (c *sessionCodec) mac(key []byte, name, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(name + "=" + data))
+	return h.Sum(nil)
+}
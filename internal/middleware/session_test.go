@@ -0,0 +1,201 @@
+package middleware
+
+import (
+	"bufio"
+	"bytes"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"httpfromtcp/internal/server"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// counter counts the requests in a session and acts on ?rotate, ?logout and
+// ?late.
+func counter(w *response.Writer, req *request.Request) {
+	s, ok := SessionFrom(req.Context())
+	if !ok {
+		panic("no session")
+	}
+
+	switch req.RequestLine.RequestTarget {
+	case "/?logout":
+		s.Destroy()
+	case "/?rotate":
+		s.Rotate()
+	case "/?read":
+	default:
+		count, _ := s.Get("count")
+		n, _ := strconv.Atoi(count)
+		s.Set("count", strconv.Itoa(n+1))
+	}
+
+	count, _ := s.Get("count")
+	body := []byte(count)
+	w.WriteStatusLine(response.OK)
+	w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+	w.WriteBody(body)
+
+	if req.RequestLine.RequestTarget == "/?late" {
+		s.Set("late", "yes")
+	}
+}
+
+// sessionGet sends a request with the cookie and returns the body and the
+// session cookie set in the response, or "" if none was set.
+func sessionGet(t *testing.T, handler server.Handler, target, sessionCookie string) (string, *http.Cookie) {
+	raw := "GET " + target + " HTTP/1.1\r\nHost: localhost\r\n"
+	if sessionCookie != "" {
+		raw += "Cookie: other=1; session=" + sessionCookie + "\r\n"
+	}
+	out := &bytes.Buffer{}
+	handler(response.NewWriter(out), newTestRequest(t, raw+"\r\n"))
+
+	res, err := http.ReadResponse(bufio.NewReader(out), nil)
+	require.NoError(t, err)
+	body, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+	for _, c := range res.Cookies() {
+		if c.Name == "session" {
+			return string(body), c
+		}
+	}
+	return string(body), nil
+}
+
+func TestSessions(t *testing.T) {
+	oldKey := bytes.Repeat([]byte("o"), 32)
+	newKey := bytes.Repeat([]byte("n"), 32)
+
+	// Test: Signed cookies carry the values between requests
+	handler, err := Sessions(SessionConfig{Keys: [][]byte{oldKey}, Path: "/", Secure: true}, counter)
+	require.NoError(t, err)
+	body, c := sessionGet(t, handler, "/", "")
+	assert.Equal(t, "1", body)
+	require.NotNil(t, c)
+	assert.True(t, c.HttpOnly)
+	assert.True(t, c.Secure)
+	assert.Equal(t, "/", c.Path)
+	assert.WithinDuration(t, time.Now().Add(24*time.Hour), c.Expires, time.Minute)
+	body, c = sessionGet(t, handler, "/", c.Value)
+	assert.Equal(t, "2", body)
+	signed := c.Value
+
+	// Test: Unchanged sessions send no cookie
+	body, c = sessionGet(t, handler, "/?read", signed)
+	assert.Equal(t, "2", body)
+	assert.Nil(t, c)
+
+	// Test: Tampered cookies start a new session
+	data, mac, _ := strings.Cut(signed, ".")
+	body, _ = sessionGet(t, handler, "/?read", data+"x."+mac)
+	assert.Equal(t, "", body)
+
+	// Test: Cookies signed with an old key are accepted and re-issued
+	handler, err = Sessions(SessionConfig{Keys: [][]byte{newKey, oldKey}}, counter)
+	require.NoError(t, err)
+	body, c = sessionGet(t, handler, "/?read", signed)
+	assert.Equal(t, "2", body)
+	require.NotNil(t, c)
+	handler, err = Sessions(SessionConfig{Keys: [][]byte{newKey}}, counter)
+	require.NoError(t, err)
+	body, _ = sessionGet(t, handler, "/?read", c.Value)
+	assert.Equal(t, "2", body)
+	body, _ = sessionGet(t, handler, "/?read", signed)
+	assert.Equal(t, "", body)
+
+	// Test: Logout deletes the cookie
+	_, c = sessionGet(t, handler, "/?logout", signed)
+	require.NotNil(t, c)
+	assert.Equal(t, -1, c.MaxAge)
+
+	// Test: Expired sessions are dropped
+	handler, err = Sessions(SessionConfig{Keys: [][]byte{newKey}, MaxAge: time.Second}, counter)
+	require.NoError(t, err)
+	codec, err := newSessionCodec([][]byte{newKey}, false)
+	require.NoError(t, err)
+	expired := codec.encode("session", []byte(`{"id":"ab","exp":1,"values":{"count":"5"}}`))
+	body, _ = sessionGet(t, handler, "/?read", expired)
+	assert.Equal(t, "", body)
+
+	// Test: Encrypted cookies can't be read by the client and work across requests
+	handler, err = Sessions(SessionConfig{Keys: [][]byte{bytes.Repeat([]byte("k"), 16)}, Encrypt: true}, counter)
+	require.NoError(t, err)
+	_, c = sessionGet(t, handler, "/", "")
+	require.NotNil(t, c)
+	assert.NotContains(t, c.Value, ".")
+	body, _ = sessionGet(t, handler, "/", c.Value)
+	assert.Equal(t, "2", body)
+
+	// Test: Invalid keys are rejected
+	_, err = Sessions(SessionConfig{}, counter)
+	assert.Error(t, err)
+	_, err = Sessions(SessionConfig{Keys: [][]byte{[]byte("short")}}, counter)
+	assert.Error(t, err)
+	_, err = Sessions(SessionConfig{Keys: [][]byte{bytes.Repeat([]byte("k"), 20)}, Encrypt: true}, counter)
+	assert.Error(t, err)
+}
+
+func TestSessionStores(t *testing.T) {
+	dir := t.TempDir()
+	fileStore, err := NewFileStore(filepath.Join(dir, "sessions"))
+	require.NoError(t, err)
+
+	for name, store := range map[string]SessionStore{"memory": NewMemoryStore(), "file": fileStore} {
+		handler, err := Sessions(SessionConfig{Keys: [][]byte{bytes.Repeat([]byte("k"), 32)}, Store: store}, counter)
+		require.NoError(t, err)
+
+		// Test: The cookie only holds the ID and the values live in the store
+		_, c := sessionGet(t, handler, "/", "")
+		require.NotNil(t, c, name)
+		body, _ := sessionGet(t, handler, "/", c.Value)
+		assert.Equal(t, "2", body, name)
+		first := c.Value
+
+		// Test: Changes made after the headers were written are stored
+		sessionGet(t, handler, "/?late", first)
+		codec, err := newSessionCodec([][]byte{bytes.Repeat([]byte("k"), 32)}, false)
+		require.NoError(t, err)
+		data, _, err := codec.decode("session", first)
+		require.NoError(t, err)
+		id := strings.Split(string(data), `"`)[3]
+		values, err := store.Get(id)
+		require.NoError(t, err, name)
+		assert.Equal(t, map[string]string{"count": "3", "late": "yes"}, values, name)
+
+		// Test: Rotation moves the values to a new ID and forgets the old one
+		_, c = sessionGet(t, handler, "/?rotate", first)
+		require.NotNil(t, c, name)
+		body, _ = sessionGet(t, handler, "/?read", c.Value)
+		assert.Equal(t, "3", body, name)
+		body, _ = sessionGet(t, handler, "/?read", first)
+		assert.Equal(t, "", body, name)
+
+		// Test: Destroyed sessions are gone from the store
+		sessionGet(t, handler, "/?logout", c.Value)
+		body, _ = sessionGet(t, handler, "/?read", c.Value)
+		assert.Equal(t, "", body, name)
+
+		// Test: Expired entries are not returned
+		require.NoError(t, store.Set("abcd", map[string]string{"a": "b"}, time.Now().Add(-time.Second)))
+		_, err = store.Get("abcd")
+		assert.ErrorIs(t, err, ErrSessionNotFound, name)
+	}
+
+	// Test: File store rejects IDs that aren't hex
+	_, err = fileStore.Get("../etc/passwd")
+	assert.ErrorIs(t, err, ErrSessionNotFound)
+	assert.Error(t, fileStore.Set("../x", nil, time.Now().Add(time.Hour)))
+	entries, err := os.ReadDir(filepath.Join(dir, "sessions"))
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+}
@@ -0,0 +1,166 @@
+package middleware
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// ErrSessionNotFound is returned by a SessionStore for unknown or expired
+// session IDs.
+var ErrSessionNotFound = errors.New("session not found")
+
+// SessionStore keeps session values on the server, keyed by session ID.
+type SessionStore interface {
+	Get(id string) (map[string]string, error)
+	Set(id string, values map[string]string, expires time.Time) error
+	Delete(id string) error
+}
+
+type storedSession struct {
+	Values  map[string]string `json:"values"`
+	Expires time.Time         `json:"expires"`
+}
+
+// sweepInterval is how many writes a MemoryStore takes between removing
+// expired sessions.
+const sweepInterval = 100
+
+// MemoryStore keeps sessions in memory; they are lost on restart.
+type MemoryStore struct {
+	mu       sync.Mutex
+	sessions map[string]storedSession
+	writes   int
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{sessions: map[string]storedSession{}}
+}
+
+func (m *MemoryStore) Get(id string) (map[string]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, ok := m.sessions[id]
+	if !ok || !time.Now().Before(stored.Expires) {
+		delete(m.sessions, id)
+		return nil, ErrSessionNotFound
+	}
+	return maps.Clone(stored.Values), nil
+}
+
+func (m *MemoryStore) Set(id string, values map[string]string, expires time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.sessions[id] = storedSession{Values: maps.Clone(values), Expires: expires}
+	m.writes++
+	if m.writes%sweepInterval == 0 {
+		now := time.Now()
+		maps.DeleteFunc(m.sessions, func(_ string, stored storedSession) bool {
+			return !now.Before(stored.Expires)
+		})
+	}
+	return nil
+}
+
+func (m *MemoryStore) Delete(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.sessions, id)
+	return nil
+}
+
+// FileStore keeps each session in a JSON file in a directory, so sessions
+// survive restarts. Expired files are removed when they are next read.
+type FileStore struct {
+	dir string
+}
+
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("Error creating session directory %s: %w", dir, err)
+	}
+	return &FileStore{dir: dir}, nil
+}
+
+func (f *FileStore) path(id string) (string, error) {
+	if _, err := hex.DecodeString(id); err != nil || id == "" {
+		return "", fmt.Errorf("Invalid session ID %q", id)
+	}
+	return filepath.Join(f.dir, id+".json"), nil
+}
+
+func (f *FileStore) Get(id string) (map[string]string, error) {
+	path, err := f.path(id)
+	if err != nil {
+		return nil, ErrSessionNotFound
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrSessionNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Error reading session %s: %w", id, err)
+	}
+
+	var stored storedSession
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return nil, fmt.Errorf("Error decoding session %s: %w", id, err)
+	}
+	if !time.Now().Before(stored.Expires) {
+		os.Remove(path)
+		return nil, ErrSessionNotFound
+	}
+	return stored.Values, nil
+}
+
+// Set writes the session to a temporary file first, so readers never see a
+// partly written one.
+func (f *FileStore) Set(id string, values map[string]string, expires time.Time) error {
+	path, err := f.path(id)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(storedSession{Values: values, Expires: expires})
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(f.dir, ".session-")
+	if err != nil {
+		return fmt.Errorf("Error writing session %s: %w", id, err)
+	}
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("Error writing session %s: %w", id, err)
+	}
+	return nil
+}
+
+func (f *FileStore) Delete(id string) error {
+	path, err := f.path(id)
+	if err != nil {
+		return nil
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("Error deleting session %s: %w", id, err)
+	}
+	return nil
+}
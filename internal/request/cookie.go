@@ -0,0 +1,22 @@
+package request
+
+import "httpfromtcp/internal/cookie"
+
+// Cookies returns the cookies sent with the request, in order.
+func (r *Request) Cookies() []*cookie.Cookie {
+	header, ok := r.Headers.Get("Cookie")
+	if !ok {
+		return nil
+	}
+	return cookie.Parse(header)
+}
+
+// Cookie returns the first cookie called name.
+func (r *Request) Cookie(name string) (*cookie.Cookie, bool) {
+	for _, c := range r.Cookies() {
+		if c.Name == name {
+			return c, true
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,184 @@
+package request
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/url"
+	"strings"
+)
+
+// Errors returned by the form and JSON helpers, wrapped with the details.
+// Handlers usually answer ErrUnsupportedMediaType with 415 Unsupported Media
+// Type, ErrBodyTooLarge with 413 Content Too Large and ErrMalformedBody with
+// 400 Bad Request.
+var (
+	ErrUnsupportedMediaType = errors.New("unsupported media type")
+	ErrMalformedBody        = errors.New("malformed body")
+	ErrBodyTooLarge         = errors.New("body too large")
+)
+
+func bodyError(kind error, format string, args ...any) error {
+	return fmt.Errorf("%w: %s", kind, fmt.Sprintf(format, args...))
+}
+
+// Values holds form or query parameters. Keys keep the order in which they
+// first appeared, and each key its values in order. The zero value is empty
+// and ready to use.
+type Values struct {
+	keys   []string
+	values map[string][]string
+}
+
+// Add appends value to the values of key.
+func (v *Values) Add(key, value string) {
+	if v.values == nil {
+		v.values = map[string][]string{}
+	}
+	if _, ok := v.values[key]; !ok {
+		v.keys = append(v.keys, key)
+	}
+	v.values[key] = append(v.values[key], value)
+}
+
+// Get returns the first value of key, or "" if there is none.
+func (v *Values) Get(key string) string {
+	if values := v.values[key]; len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// All returns every value of key.
+func (v *Values) All(key string) []string {
+	return v.values[key]
+}
+
+func (v *Values) Has(key string) bool {
+	_, ok := v.values[key]
+	return ok
+}
+
+// Keys returns the keys in the order they first appeared.
+func (v *Values) Keys() []string {
+	return v.keys
+}
+
+// ParseQuery parses an application/x-www-form-urlencoded string such as a
+// query string.
+func ParseQuery(query string) (*Values, error) {
+	values := &Values{}
+	if err := values.parse(query); err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+func (v *Values) parse(query string) error {
+	for pair := range strings.SplitSeq(query, "&") {
+		if pair == "" {
+			continue
+		}
+		rawKey, rawValue, _ := strings.Cut(pair, "=")
+		key, err := url.QueryUnescape(rawKey)
+		if err != nil {
+			return fmt.Errorf("Error decoding %q: %w", rawKey, err)
+		}
+		value, err := url.QueryUnescape(rawValue)
+		if err != nil {
+			return fmt.Errorf("Error decoding %q: %w", rawValue, err)
+		}
+		v.Add(key, value)
+	}
+	return nil
+}
+
+// Query returns the parameters of the request target's query string.
+func (r *Request) Query() (*Values, error) {
+	_, query, _ := strings.Cut(r.RequestLine.RequestTarget, "?")
+	query, _, _ = strings.Cut(query, "#")
+
+	values, err := ParseQuery(query)
+	if err != nil {
+		return nil, bodyError(ErrMalformedBody, "query: %s", err)
+	}
+	return values, nil
+}
+
+// ParseForm decodes an application/x-www-form-urlencoded body. With
+// withQuery, the query string's parameters are added after the body's.
+func (r *Request) ParseForm(withQuery bool) (*Values, error) {
+	if err := r.requireMediaType(func(mediaType string) bool {
+		return mediaType == "application/x-www-form-urlencoded"
+	}); err != nil {
+		return nil, err
+	}
+
+	values := &Values{}
+	if err := values.parse(string(r.Body)); err != nil {
+		return nil, bodyError(ErrMalformedBody, "form: %s", err)
+	}
+
+	if withQuery {
+		query, err := r.Query()
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range query.Keys() {
+			for _, value := range query.All(key) {
+				values.Add(key, value)
+			}
+		}
+	}
+	return values, nil
+}
+
+// JSONOptions configures DecodeJSON.
+type JSONOptions struct {
+	// MaxBytes rejects larger bodies with ErrBodyTooLarge; zero means no
+	// limit.
+	MaxBytes int64
+	// DisallowUnknownFields rejects objects with fields v has no place for.
+	DisallowUnknownFields bool
+}
+
+// DecodeJSON decodes a JSON body, sent as application/json or another +json
+// media type, into v. The body must hold exactly one JSON value.
+func (r *Request) DecodeJSON(v any, opts JSONOptions) error {
+	if err := r.requireMediaType(func(mediaType string) bool {
+		return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+	}); err != nil {
+		return err
+	}
+
+	if opts.MaxBytes > 0 && int64(len(r.Body)) > opts.MaxBytes {
+		return bodyError(ErrBodyTooLarge, "JSON body over %d bytes", opts.MaxBytes)
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(r.Body))
+	if opts.DisallowUnknownFields {
+		decoder.DisallowUnknownFields()
+	}
+	if err := decoder.Decode(v); err != nil {
+		if errors.Is(err, io.EOF) {
+			return bodyError(ErrMalformedBody, "empty JSON body")
+		}
+		return bodyError(ErrMalformedBody, "JSON: %s", err)
+	}
+	if _, err := decoder.Token(); !errors.Is(err, io.EOF) {
+		return bodyError(ErrMalformedBody, "data after the JSON value")
+	}
+	return nil
+}
+
+func (r *Request) requireMediaType(accept func(mediaType string) bool) error {
+	contentType, _ := r.Headers.Get("Content-Type")
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil || !accept(mediaType) {
+		return bodyError(ErrUnsupportedMediaType, "content type %q", contentType)
+	}
+	return nil
+}
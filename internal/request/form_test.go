@@ -0,0 +1,86 @@
+package request
+
+import (
+	"httpfromtcp/internal/headers"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func bodyRequest(target, contentType, body string) *Request {
+	h := headers.NewHeaders()
+	if contentType != "" {
+		h.Set("Content-Type", contentType)
+	}
+	return NewRequest(RequestLine{Method: "POST", RequestTarget: target, HttpVersion: "1.1"}, h, []byte(body))
+}
+
+func TestForm(t *testing.T) {
+	// Test: Form values keep the order of keys and values
+	req := bodyRequest("/submit?b=query&z=last", "application/x-www-form-urlencoded; charset=utf-8", "b=2&a=1&b=3&name=J%C3%BCrgen+K&empty=&flag")
+	form, err := req.ParseForm(false)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"b", "a", "name", "empty", "flag"}, form.Keys())
+	assert.Equal(t, []string{"2", "3"}, form.All("b"))
+	assert.Equal(t, "Jürgen K", form.Get("name"))
+	assert.True(t, form.Has("flag"))
+	assert.Equal(t, "", form.Get("flag"))
+	assert.False(t, form.Has("missing"))
+
+	// Test: Query parameters are added after the body's when asked
+	form, err = req.ParseForm(true)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"b", "a", "name", "empty", "flag", "z"}, form.Keys())
+	assert.Equal(t, []string{"2", "3", "query"}, form.All("b"))
+
+	query, err := req.Query()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"b", "z"}, query.Keys())
+
+	// Test: Other content types are unsupported
+	_, err = bodyRequest("/", "text/plain", "a=1").ParseForm(false)
+	assert.ErrorIs(t, err, ErrUnsupportedMediaType)
+
+	// Test: Bad escapes are malformed
+	_, err = bodyRequest("/", "application/x-www-form-urlencoded", "a=%zz").ParseForm(false)
+	assert.ErrorIs(t, err, ErrMalformedBody)
+	_, err = bodyRequest("/?a=%", "application/x-www-form-urlencoded", "").ParseForm(true)
+	assert.ErrorIs(t, err, ErrMalformedBody)
+}
+
+func TestDecodeJSON(t *testing.T) {
+	type payload struct {
+		Name  string `json:"name"`
+		Count int    `json:"count"`
+	}
+
+	// Test: A JSON body is decoded into a struct
+	var p payload
+	err := bodyRequest("/", "application/json; charset=utf-8", `{"name": "x", "count": 2, "extra": true}`).DecodeJSON(&p, JSONOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, payload{Name: "x", Count: 2}, p)
+
+	// Test: +json media types are accepted
+	assert.NoError(t, bodyRequest("/", "application/problem+json", `{}`).DecodeJSON(&p, JSONOptions{}))
+
+	// Test: Unknown fields are rejected in strict mode
+	err = bodyRequest("/", "application/json", `{"name": "x", "extra": true}`).DecodeJSON(&p, JSONOptions{DisallowUnknownFields: true})
+	assert.ErrorIs(t, err, ErrMalformedBody)
+
+	// Test: Bodies over the limit are too large
+	err = bodyRequest("/", "application/json", `{"name": "a long name"}`).DecodeJSON(&p, JSONOptions{MaxBytes: 10})
+	assert.ErrorIs(t, err, ErrBodyTooLarge)
+
+	// Test: Wrong or missing content types are unsupported
+	for _, contentType := range []string{"", "text/plain", "application/jsonx"} {
+		err = bodyRequest("/", contentType, `{}`).DecodeJSON(&p, JSONOptions{})
+		assert.ErrorIs(t, err, ErrUnsupportedMediaType, contentType)
+	}
+
+	// Test: Broken, empty, mistyped and trailing data are malformed
+	for _, body := range []string{`{"name":`, ``, `{"count": "two"}`, `{} {}`, `{}x`} {
+		err = bodyRequest("/", "application/json", body).DecodeJSON(&p, JSONOptions{})
+		assert.ErrorIs(t, err, ErrMalformedBody, body)
+	}
+}
@@ -0,0 +1,78 @@
+package request
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+)
+
+// Host returns the request's Host header: the host name or address the
+// client wants, with the port if it sent one.
+func (r *Request) Host() string {
+	host, _ := r.Headers.Get("Host")
+	return host
+}
+
+// checkHost makes sure an HTTP/1.1 request carries a valid Host header, as
+// RFC 9112 requires so servers know which site a request is for. The value
+// may be empty, which is what clients send for targets without an authority.
+func (r *Request) checkHost() error {
+	if r.RequestLine.HttpVersion != "1.1" {
+		return nil
+	}
+	host, ok := r.Headers.Get("Host")
+	if !ok {
+		return fmt.Errorf("%w: missing Host header", ErrInvalidHost)
+	}
+	if host != "" && !validHost(host) {
+		return fmt.Errorf("%w: %q", ErrInvalidHost, host)
+	}
+	return nil
+}
+
+// validHost reports whether host is an RFC 3986 host, a registered name or
+// an IP address with IPv6 in brackets, followed by an optional port.
+func validHost(host string) bool {
+	if strings.HasPrefix(host, "[") {
+		end := strings.IndexByte(host, ']')
+		if end < 0 {
+			return false
+		}
+		addr, err := netip.ParseAddr(host[1:end])
+		if err != nil || !addr.Is6() {
+			return false
+		}
+		return validPort(host[end+1:])
+	}
+
+	name, port := host, ""
+	if i := strings.LastIndexByte(host, ':'); i >= 0 {
+		name, port = host[:i], host[i:]
+	}
+	if name == "" || !validPort(port) {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.IndexByte("-._~%!$&'()*+,;=", c) >= 0) {
+			return false
+		}
+	}
+	return true
+}
+
+// validPort reports whether port is empty or a colon followed by digits.
+func validPort(port string) bool {
+	if port == "" {
+		return true
+	}
+	if port[0] != ':' {
+		return false
+	}
+	for i := 1; i < len(port); i++ {
+		if port[i] < '0' || port[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,393 @@
+package request
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"httpfromtcp/internal/headers"
+	"io"
+	"mime"
+	"os"
+	"strings"
+)
+
+// Errors returned while reading multipart bodies, wrapped with details.
+var (
+	ErrNotMultipart       = errors.New("not a multipart request")
+	ErrMalformedMultipart = errors.New("malformed multipart body")
+	ErrPartTooLarge       = errors.New("multipart part too large")
+	ErrMultipartTooLarge  = errors.New("multipart body too large")
+	ErrTooManyParts       = errors.New("too many multipart parts")
+)
+
+const (
+	defaultMaxParts    = 1000
+	maxPartHeaderBytes = 16 << 10
+	multipartBufSize   = 4096
+)
+
+// MultipartReader iterates over the parts of a multipart body. Each part is
+// read straight from the underlying reader as it is consumed, so a part must
+// be read before moving on to the next.
+type MultipartReader struct {
+	// MaxPartBytes limits the content of a single part and MaxTotalBytes
+	// the content of all parts together; zero means no limit.
+	MaxPartBytes  int64
+	MaxTotalBytes int64
+	// MaxParts limits the number of parts and defaults to 1000.
+	MaxParts int
+
+	r         *bufio.Reader
+	boundary  string
+	delimiter []byte
+	current   *Part
+	parts     int
+	total     int64
+	started   bool
+	done      bool
+}
+
+// NewMultipartReader reads the parts of body separated by boundary.
+func NewMultipartReader(body io.Reader, boundary string) *MultipartReader {
+	return &MultipartReader{
+		MaxParts:  defaultMaxParts,
+		r:         bufio.NewReaderSize(body, multipartBufSize),
+		boundary:  boundary,
+		delimiter: []byte("\r\n--" + boundary),
+	}
+}
+
+// MultipartReader returns a reader over the parts of a multipart/form-data
+// or multipart/mixed request body, using the boundary from Content-Type. The
+// server reads the body in full before the handler runs, so the parts come
+// from r.Body in memory; use NewMultipartReader to read parts from any other
+// source as they arrive.
+func (r *Request) MultipartReader() (*MultipartReader, error) {
+	contentType, _ := r.Headers.Get("Content-Type")
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil || !strings.HasPrefix(mediaType, "multipart/") {
+		return nil, fmt.Errorf("%w: content type %q", ErrNotMultipart, contentType)
+	}
+
+	boundary := params["boundary"]
+	if boundary == "" || len(boundary) > 70 {
+		return nil, fmt.Errorf("%w: invalid boundary %q", ErrMalformedMultipart, boundary)
+	}
+	return NewMultipartReader(bytes.NewReader(r.Body), boundary), nil
+}
+
+// Part is one part of a multipart body; reading it returns its content.
+type Part struct {
+	Headers headers.Headers
+
+	mr   *MultipartReader
+	size int64
+	eof  bool
+}
+
+// NextPart skips what is left of the current part and returns the next one,
+// or io.EOF after the last.
+func (mr *MultipartReader) NextPart() (*Part, error) {
+	if mr.done {
+		return nil, io.EOF
+	}
+	if mr.current != nil {
+		if _, err := io.Copy(io.Discard, mr.current); err != nil {
+			return nil, err
+		}
+	}
+
+	var final bool
+	var err error
+	if mr.started {
+		final, err = mr.readDelimiterLine()
+	} else {
+		final, err = mr.skipPreamble()
+		mr.started = true
+	}
+	if err != nil {
+		return nil, err
+	}
+	if final {
+		mr.done = true
+		return nil, io.EOF
+	}
+
+	mr.parts++
+	if mr.MaxParts > 0 && mr.parts > mr.MaxParts {
+		return nil, fmt.Errorf("%w: more than %d", ErrTooManyParts, mr.MaxParts)
+	}
+
+	h, err := mr.readPartHeaders()
+	if err != nil {
+		return nil, err
+	}
+	mr.current = &Part{Headers: h, mr: mr}
+	return mr.current, nil
+}
+
+// skipPreamble reads up to and including the first boundary line.
+func (mr *MultipartReader) skipPreamble() (final bool, err error) {
+	dashBoundary := "--" + mr.boundary
+	for {
+		line, err := mr.r.ReadSlice('\n')
+		switch strings.TrimRight(string(line), " \t\r\n") {
+		case dashBoundary:
+			return false, nil
+		case dashBoundary + "--":
+			return true, nil
+		}
+		if err != nil && !errors.Is(err, bufio.ErrBufferFull) {
+			return false, fmt.Errorf("%w: no boundary found", ErrMalformedMultipart)
+		}
+	}
+}
+
+// readDelimiterLine consumes the delimiter that ended the previous part and
+// the rest of its line, reporting whether it was the closing one.
+func (mr *MultipartReader) readDelimiterLine() (final bool, err error) {
+	if _, err := mr.r.Discard(len(mr.delimiter)); err != nil {
+		return false, fmt.Errorf("%w: truncated boundary", ErrMalformedMultipart)
+	}
+
+	line, err := mr.r.ReadSlice('\n')
+	if bytes.HasPrefix(line, []byte("--")) {
+		return true, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("%w: truncated boundary", ErrMalformedMultipart)
+	}
+	if strings.TrimRight(string(line), " \t\r\n") != "" {
+		return false, fmt.Errorf("%w: unexpected data after boundary", ErrMalformedMultipart)
+	}
+	return false, nil
+}
+
+func (mr *MultipartReader) readPartHeaders() (headers.Headers, error) {
+	h := headers.NewHeaders()
+	read := 0
+	for {
+		line, err := mr.r.ReadSlice('\n')
+		read += len(line)
+		if err != nil || read > maxPartHeaderBytes {
+			return nil, fmt.Errorf("%w: truncated or oversized part headers", ErrMalformedMultipart)
+		}
+
+		// Tolerate bare LF line endings.
+		if !bytes.HasSuffix(line, []byte(crlf)) {
+			line = append(line[:len(line)-1:len(line)-1], crlf...)
+		}
+		_, done, err := h.Parse(line)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrMalformedMultipart, err)
+		}
+		if done {
+			return h, nil
+		}
+	}
+}
+
+// Read returns the part's content up to the next boundary.
+func (p *Part) Read(b []byte) (int, error) {
+	if p.eof {
+		return 0, io.EOF
+	}
+	if len(b) == 0 {
+		return 0, nil
+	}
+	mr := p.mr
+
+	peek, _ := mr.r.Peek(multipartBufSize)
+	var n int
+	if i := bytes.Index(peek, mr.delimiter); i >= 0 {
+		if i == 0 {
+			p.eof = true
+			return 0, io.EOF
+		}
+		n = min(len(b), i)
+	} else {
+		// Hold back what could be the start of a delimiter.
+		safe := len(peek) - len(mr.delimiter) + 1
+		if safe <= 0 {
+			return 0, fmt.Errorf("%w: missing closing boundary", ErrMalformedMultipart)
+		}
+		n = min(len(b), safe)
+	}
+
+	p.size += int64(n)
+	mr.total += int64(n)
+	if mr.MaxPartBytes > 0 && p.size > mr.MaxPartBytes {
+		return 0, fmt.Errorf("%w: over %d bytes", ErrPartTooLarge, mr.MaxPartBytes)
+	}
+	if mr.MaxTotalBytes > 0 && mr.total > mr.MaxTotalBytes {
+		return 0, fmt.Errorf("%w: over %d bytes", ErrMultipartTooLarge, mr.MaxTotalBytes)
+	}
+
+	copy(b, peek[:n])
+	mr.r.Discard(n)
+	return n, nil
+}
+
+// FormName returns the name parameter of the part's Content-Disposition,
+// or "" if it isn't form-data.
+func (p *Part) FormName() string {
+	disposition, params := p.disposition()
+	if disposition != "form-data" {
+		return ""
+	}
+	return params["name"]
+}
+
+// FileName returns the filename parameter of the part's Content-Disposition
+// without any directory components.
+func (p *Part) FileName() string {
+	_, params := p.disposition()
+	filename := params["filename"]
+	if i := strings.LastIndexAny(filename, `/\`); i >= 0 {
+		filename = filename[i+1:]
+	}
+	return filename
+}
+
+func (p *Part) disposition() (string, map[string]string) {
+	value, _ := p.Headers.Get("Content-Disposition")
+	disposition, params, err := mime.ParseMediaType(value)
+	if err != nil {
+		return "", nil
+	}
+	return disposition, params
+}
+
+// MultipartForm is a multipart/form-data body read as a whole. Files that
+// didn't fit in memory are kept in temporary files until RemoveAll.
+type MultipartForm struct {
+	Values map[string][]string
+	Files  map[string][]*FileHeader
+}
+
+// FileHeader describes an uploaded file.
+type FileHeader struct {
+	Filename string
+	Headers  headers.Headers
+	Size     int64
+
+	content []byte
+	tmpFile string
+}
+
+// Open returns the file's content.
+func (fh *FileHeader) Open() (io.ReadCloser, error) {
+	if fh.tmpFile != "" {
+		return os.Open(fh.tmpFile)
+	}
+	return io.NopCloser(bytes.NewReader(fh.content)), nil
+}
+
+// RemoveAll deletes the temporary files backing the form's files.
+func (f *MultipartForm) RemoveAll() error {
+	var errs []error
+	for _, files := range f.Files {
+		for _, fh := range files {
+			if fh.tmpFile == "" {
+				continue
+			}
+			if err := os.Remove(fh.tmpFile); err != nil && !errors.Is(err, os.ErrNotExist) {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// ReadForm reads all parts into a form. Up to maxMemory bytes of content are
+// kept in memory; file parts that don't fit are written to temporary files,
+// while values that don't fit fail with ErrMultipartTooLarge.
+func (mr *MultipartReader) ReadForm(maxMemory int64) (_ *MultipartForm, err error) {
+	form := &MultipartForm{
+		Values: map[string][]string{},
+		Files:  map[string][]*FileHeader{},
+	}
+	defer func() {
+		if err != nil {
+			form.RemoveAll()
+		}
+	}()
+
+	remaining := maxMemory
+	for {
+		part, err := mr.NextPart()
+		if errors.Is(err, io.EOF) {
+			return form, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		name := part.FormName()
+		if name == "" {
+			continue
+		}
+
+		filename := part.FileName()
+		if filename == "" {
+			var value bytes.Buffer
+			n, err := io.CopyN(&value, part, remaining+1)
+			if err != nil && !errors.Is(err, io.EOF) {
+				return nil, err
+			}
+			if n > remaining {
+				return nil, fmt.Errorf("%w: form values over %d bytes", ErrMultipartTooLarge, maxMemory)
+			}
+			remaining -= n
+			form.Values[name] = append(form.Values[name], value.String())
+			continue
+		}
+
+		fh := &FileHeader{Filename: filename, Headers: part.Headers}
+		form.Files[name] = append(form.Files[name], fh)
+		if err := fh.read(part, &remaining); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// read keeps the part's content in memory while it fits in remaining and
+// spills it to a temporary file otherwise.
+func (fh *FileHeader) read(part *Part, remaining *int64) error {
+	var buf bytes.Buffer
+	n, err := io.CopyN(&buf, part, *remaining+1)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	if n <= *remaining {
+		*remaining -= n
+		fh.content = buf.Bytes()
+		fh.Size = n
+		return nil
+	}
+
+	file, err := os.CreateTemp("", "multipart-")
+	if err != nil {
+		return fmt.Errorf("Error creating temporary file: %w", err)
+	}
+	defer file.Close()
+	fh.tmpFile = file.Name()
+
+	written, err := io.Copy(file, io.MultiReader(&buf, part))
+	if err != nil {
+		return err
+	}
+	fh.Size = written
+	return nil
+}
+
+// ParseMultipartForm reads a multipart/form-data body as described in
+// MultipartReader.ReadForm. Call RemoveAll on the form when done with it.
+func (r *Request) ParseMultipartForm(maxMemory int64) (*MultipartForm, error) {
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return nil, err
+	}
+	return mr.ReadForm(maxMemory)
+}
@@ -0,0 +1,158 @@
+package request
+
+import (
+	"bytes"
+	"httpfromtcp/internal/headers"
+	"io"
+	"mime/multipart"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func multipartRequest(t *testing.T, build func(w *multipart.Writer)) *Request {
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	build(w)
+	require.NoError(t, w.Close())
+
+	h := headers.NewHeaders()
+	h.Set("Content-Type", w.FormDataContentType())
+	return NewRequest(RequestLine{Method: "POST", RequestTarget: "/", HttpVersion: "1.1"}, h, body.Bytes())
+}
+
+func TestMultipart(t *testing.T) {
+	large := strings.Repeat("0123456789abcdef", 1000)
+	req := multipartRequest(t, func(w *multipart.Writer) {
+		w.WriteField("title", "hello")
+		w.WriteField("tag", "a")
+		w.WriteField("tag", "b")
+		f, _ := w.CreateFormFile("upload", "../../small.txt")
+		f.Write([]byte("small file"))
+		f, _ = w.CreateFormFile("upload", "large.bin")
+		f.Write([]byte(large))
+		w.WriteField("empty", "")
+	})
+
+	// Test: Parts are streamed with their own headers
+	mr, err := req.MultipartReader()
+	require.NoError(t, err)
+	part, err := mr.NextPart()
+	require.NoError(t, err)
+	assert.Equal(t, "title", part.FormName())
+	assert.Equal(t, "", part.FileName())
+	content, err := io.ReadAll(part)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(content))
+
+	// Test: Unread parts are skipped
+	part, err = mr.NextPart()
+	require.NoError(t, err)
+	assert.Equal(t, "tag", part.FormName())
+	part, err = mr.NextPart()
+	require.NoError(t, err)
+	part, err = mr.NextPart()
+	require.NoError(t, err)
+	assert.Equal(t, "small.txt", part.FileName())
+	contentType, _ := part.Headers.Get("Content-Type")
+	assert.Equal(t, "application/octet-stream", contentType)
+
+	// Test: Large parts are read across buffer boundaries
+	part, err = mr.NextPart()
+	require.NoError(t, err)
+	content, err = io.ReadAll(part)
+	require.NoError(t, err)
+	assert.Equal(t, large, string(content))
+	part, err = mr.NextPart()
+	require.NoError(t, err)
+	content, err = io.ReadAll(part)
+	require.NoError(t, err)
+	assert.Empty(t, content)
+	_, err = mr.NextPart()
+	assert.ErrorIs(t, err, io.EOF)
+
+	// Test: Forms keep small files in memory and spill large ones to disk
+	form, err := req.ParseMultipartForm(1024)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"hello"}, form.Values["title"])
+	assert.Equal(t, []string{"a", "b"}, form.Values["tag"])
+	assert.Equal(t, []string{""}, form.Values["empty"])
+	require.Len(t, form.Files["upload"], 2)
+	small, spilled := form.Files["upload"][0], form.Files["upload"][1]
+	assert.Equal(t, "small.txt", small.Filename)
+	assert.Equal(t, int64(10), small.Size)
+	assert.Empty(t, small.tmpFile)
+	assert.Equal(t, int64(len(large)), spilled.Size)
+	require.NotEmpty(t, spilled.tmpFile)
+	file, err := spilled.Open()
+	require.NoError(t, err)
+	content, err = io.ReadAll(file)
+	file.Close()
+	require.NoError(t, err)
+	assert.Equal(t, large, string(content))
+	require.NoError(t, form.RemoveAll())
+	_, err = os.Stat(spilled.tmpFile)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	// Test: Values that don't fit in memory are rejected
+	_, err = req.ParseMultipartForm(4)
+	assert.ErrorIs(t, err, ErrMultipartTooLarge)
+
+	// Test: Part and total limits
+	mr, err = req.MultipartReader()
+	require.NoError(t, err)
+	mr.MaxPartBytes = 100
+	_, err = mr.ReadForm(1 << 20)
+	assert.ErrorIs(t, err, ErrPartTooLarge)
+
+	mr, err = req.MultipartReader()
+	require.NoError(t, err)
+	mr.MaxTotalBytes = 50
+	_, err = mr.ReadForm(1 << 20)
+	assert.ErrorIs(t, err, ErrMultipartTooLarge)
+
+	mr, err = req.MultipartReader()
+	require.NoError(t, err)
+	mr.MaxParts = 3
+	_, err = mr.ReadForm(1 << 20)
+	assert.ErrorIs(t, err, ErrTooManyParts)
+
+	// Test: Preamble, epilogue and small reads from a stream
+	body := "preamble\r\n" +
+		"--xyz\r\n" +
+		"Content-Disposition: form-data; name=\"a\"\r\n" +
+		"\r\n" +
+		"first\r\n--xy not a boundary\r\n" +
+		"--xyz  \r\n" +
+		"Content-Disposition: form-data; name=\"b\"\r\n" +
+		"\r\n" +
+		"second\r\n" +
+		"--xyz--\r\n" +
+		"epilogue"
+	mr = NewMultipartReader(&chunkReader{data: body, numBytesPerRead: 3}, "xyz")
+	form, err = mr.ReadForm(1 << 20)
+	require.NoError(t, err)
+	assert.Equal(t, map[string][]string{"a": {"first\r\n--xy not a boundary"}, "b": {"second"}}, form.Values)
+
+	// Test: Malformed bodies
+	for _, body := range []string{
+		"no boundary at all",
+		"--xyz\r\nContent-Disposition: form-data; name=\"a\"\r\n\r\nnever closed",
+		"--xyz\r\nbroken header\r\n\r\nx\r\n--xyz--",
+		"--xyz\r\nContent-Disposition: form-data; name=\"a\"\r\n\r\nx\r\n--xyzjunk\r\n",
+	} {
+		_, err = NewMultipartReader(strings.NewReader(body), "xyz").ReadForm(1 << 20)
+		assert.ErrorIs(t, err, ErrMalformedMultipart, body)
+	}
+
+	// Test: Requests that aren't multipart
+	req.Headers.Update("content-type", "application/json")
+	_, err = req.MultipartReader()
+	assert.ErrorIs(t, err, ErrNotMultipart)
+	req.Headers.Update("content-type", "multipart/form-data")
+	_, err = req.MultipartReader()
+	assert.ErrorIs(t, err, ErrMalformedMultipart)
+}
@@ -2,6 +2,7 @@ package request
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"httpfromtcp/internal/headers"
@@ -13,6 +14,18 @@ import (
 const crlf = "\r\n"
 const bufferSize = 8
 
+// Errors returned by RequestFromReader, wrapped with details, so callers can
+// tell what kind of malformed input they received.
+var (
+	ErrIncompleteRequest    = errors.New("incomplete request")
+	ErrMalformedRequestLine = errors.New("malformed request line")
+	ErrInvalidMethod        = errors.New("invalid method")
+	ErrUnsupportedVersion   = errors.New("unsupported http version")
+	ErrMalformedHeader      = errors.New("malformed header")
+	ErrInvalidContentLength = errors.New("invalid content length")
+	ErrInvalidHost          = errors.New("invalid host")
+)
+
 type requestStatus int
 
 const (
@@ -27,6 +40,8 @@ type Request struct {
 	Headers       headers.Headers
 	Body          []byte
 	RequestStatus requestStatus
+
+	ctx context.Context
 }
 
 type RequestLine struct {
@@ -36,10 +51,17 @@ type RequestLine struct {
 }
 
 func RequestFromReader(reader io.Reader) (*Request, error) {
+	request, _, err := ReadRequest(reader)
+	return request, err
+}
+
+// ReadRequest parses a request like RequestFromReader and also returns the
+// bytes it read past the end of the request, such as the start of a
+// pipelined request or of another protocol after an upgrade.
+func ReadRequest(reader io.Reader) (*Request, []byte, error) {
 	parsedRequest := &Request{
 		Headers:       headers.NewHeaders(),
 		RequestStatus: requestInitialized,
-		Body:          make([]byte, 0),
 	}
 
 	buffer := make([]byte, bufferSize)
@@ -59,18 +81,18 @@ func RequestFromReader(reader io.Reader) (*Request, error) {
 		if err != nil {
 			if errors.Is(err, io.EOF) {
 				if parsedRequest.RequestStatus != requestDone {
-					return nil, fmt.Errorf("incomplete request")
+					return nil, nil, ErrIncompleteRequest
 				}
 				break
 			}
-			return nil, err
+			return nil, nil, err
 		}
 
 		bufferIdx += readBytes
 
 		parsedBytes, err := parsedRequest.parse(buffer[:bufferIdx])
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 
 		if parsedBytes > 0 {
@@ -78,7 +100,38 @@ func RequestFromReader(reader io.Reader) (*Request, error) {
 			bufferIdx -= parsedBytes
 		}
 	}
-	return parsedRequest, nil
+	return parsedRequest, buffer[:bufferIdx], nil
+}
+
+// NewRequest returns a complete request that was read by other means than
+// the HTTP/1.1 parser, such as an HTTP/2 stream.
+func NewRequest(line RequestLine, h headers.Headers, body []byte) *Request {
+	return &Request{
+		RequestLine:   line,
+		Headers:       h,
+		Body:          body,
+		RequestStatus: requestDone,
+	}
+}
+
+// Context returns the request's context. It is never nil: requests that were
+// not handed a context by the server fall back to context.Background.
+func (r *Request) Context() context.Context {
+	if r.ctx != nil {
+		return r.ctx
+	}
+	return context.Background()
+}
+
+// WithContext returns a shallow copy of r with its context changed to ctx.
+func (r *Request) WithContext(ctx context.Context) *Request {
+	if ctx == nil {
+		panic("nil context")
+	}
+	r2 := new(Request)
+	*r2 = *r
+	r2.ctx = ctx
+	return r2
 }
 
 func (r *Request) parse(data []byte) (int, error) {
@@ -115,14 +168,22 @@ func (r *Request) parseSingle(data []byte) (int, error) {
 		return parsedBytes, nil
 
 	case requestParsingHeaders:
+		previousHost, hadHost := r.Headers.Get("Host")
 		parsedBytes, done, err := r.Headers.Parse(data)
 		if err != nil {
-			return 0, err
+			return 0, fmt.Errorf("%w: %w", ErrMalformedHeader, err)
 		}
 		if parsedBytes == 0 {
 			return 0, nil
 		}
+		// A second Host line is appended to the first, changing the value.
+		if host, _ := r.Headers.Get("Host"); hadHost && host != previousHost {
+			return 0, fmt.Errorf("%w: more than one Host header", ErrInvalidHost)
+		}
 		if done {
+			if err := r.checkHost(); err != nil {
+				return 0, err
+			}
 			r.RequestStatus = requestParsingBody
 		}
 		return parsedBytes, nil
@@ -135,20 +196,23 @@ func (r *Request) parseSingle(data []byte) (int, error) {
 
 		contentLengthNum, err := strconv.Atoi(contentLength)
 		if err != nil {
-			return 0, fmt.Errorf("Error converting to int %s", contentLength)
+			return 0, fmt.Errorf("%w: Error converting to int %s", ErrInvalidContentLength, contentLength)
 		}
 
-		r.Body = append(r.Body, data...)
-
-		if len(r.Body) > contentLengthNum {
-			return 0, fmt.Errorf("Content is greater than provided length %d", contentLengthNum)
+		if contentLengthNum < 0 {
+			return 0, fmt.Errorf("%w: Negative content length %d", ErrInvalidContentLength, contentLengthNum)
 		}
+
+		// Anything past the declared length belongs to whatever follows the
+		// request on the connection.
+		n := min(len(data), contentLengthNum-len(r.Body))
+		r.Body = append(r.Body, data[:n]...)
+
 		if len(r.Body) == contentLengthNum {
 			r.RequestStatus = requestDone
-			return len(data), nil
 		}
 
-		return len(data), nil
+		return n, nil
 	case requestDone:
 		return 0, fmt.Errorf("Request has already been processed")
 	default:
@@ -176,7 +240,7 @@ func requestLineFromString(str string) (*RequestLine, error) {
 
 	requestLineParts := strings.Split(str, " ")
 	if len(requestLineParts) != 3 {
-		return nil, fmt.Errorf("Request line does not consist of 3 parts: %s", str)
+		return nil, fmt.Errorf("%w: Request line does not consist of 3 parts: %s", ErrMalformedRequestLine, str)
 	}
 
 	method := requestLineParts[0]
@@ -184,7 +248,7 @@ func requestLineFromString(str string) (*RequestLine, error) {
 	for _, char := range method {
 		if char > 'Z' || char < 'A' {
 			fmt.Println(char)
-			return nil, fmt.Errorf("Request method contains other than capital letters: %s", method)
+			return nil, fmt.Errorf("%w: Request method contains other than capital letters: %s", ErrInvalidMethod, method)
 		}
 	}
 
@@ -192,13 +256,13 @@ func requestLineFromString(str string) (*RequestLine, error) {
 
 	httpVersionParts := strings.Split(requestLineParts[2], "/")
 	if len(httpVersionParts) != 2 {
-		return nil, fmt.Errorf("Http version does not consist of 2 parts: %s", requestLineParts[2])
+		return nil, fmt.Errorf("%w: Http version does not consist of 2 parts: %s", ErrMalformedRequestLine, requestLineParts[2])
 	}
 
 	httpVersionNumber := httpVersionParts[1]
 
 	if httpVersionNumber != "1.1" {
-		return nil, fmt.Errorf("Http version not supported: %s", httpVersionNumber)
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedVersion, httpVersionNumber)
 	}
 
 	return &RequestLine{
@@ -89,25 +89,61 @@ func TestHeaderLineParse(t *testing.T) {
 	assert.Equal(t, "curl/7.81.0", r.Headers["user-agent"])
 	assert.Equal(t, "*/*", r.Headers["accept"])
 
-	// Test: Empty headers
+	// Test: Only the Host header
 	reader = &chunkReader{
-		data:            "GET / HTTP/1.1\r\n\r\n",
+		data:            "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n",
 		numBytesPerRead: 3,
 	}
 	r, err = RequestFromReader(reader)
 	require.NoError(t, err)
 	require.NotNil(t, r)
-	assert.Equal(t, 0, len(r.Headers))
+	assert.Equal(t, 1, len(r.Headers))
+	assert.Equal(t, "localhost", r.Host())
+
+	// Test: Missing Host header
+	reader = &chunkReader{
+		data:            "GET / HTTP/1.1\r\n\r\n",
+		numBytesPerRead: 3,
+	}
+	_, err = RequestFromReader(reader)
+	require.ErrorIs(t, err, ErrInvalidHost)
 
 	// Test: Duplicate headers
 	reader = &chunkReader{
-		data:            "GET / HTTP/1.1\r\nHost: localhost:42069\r\nHost: localhost:8080\r\n\r\n",
+		data:            "GET / HTTP/1.1\r\nHost: localhost:42069\r\nAccept: text/html\r\nAccept: */*\r\n\r\n",
 		numBytesPerRead: 3,
 	}
 	r, err = RequestFromReader(reader)
 	require.NoError(t, err)
 	require.NotNil(t, r)
-	assert.Equal(t, "localhost:42069, localhost:8080", r.Headers["host"])
+	assert.Equal(t, "text/html, */*", r.Headers["accept"])
+
+	// Test: Duplicate Host headers
+	for _, hosts := range []string{"Host: localhost:42069\r\nHost: localhost:8080", "Host: localhost\r\nHost: localhost", "Host:\r\nHost:"} {
+		_, err = RequestFromReader(&chunkReader{
+			data:            "GET / HTTP/1.1\r\n" + hosts + "\r\n\r\n",
+			numBytesPerRead: 3,
+		})
+		require.ErrorIs(t, err, ErrInvalidHost, hosts)
+	}
+
+	// Test: Host names, IPv4 and IPv6 addresses with optional ports
+	for _, host := range []string{"example.com", "EXAMPLE.com:8080", "192.0.2.1:80", "[2001:db8::1]", "[::1]:8443", "xn--bcher-kva.example"} {
+		r, err = RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\nHost: " + host + "\r\n\r\n"))
+		require.NoError(t, err, host)
+		assert.Equal(t, host, r.Host())
+	}
+
+	// Test: An empty Host is allowed
+	r, err = RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\nHost:\r\n\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, "", r.Host())
+
+	// Test: Invalid Host values
+	for _, host := range []string{":80", "exa mple.com", "example.com:http", "[2001:db8::1", "[192.0.2.1]", "user@example.com", "example.com/path"} {
+		_, err = RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\nHost: " + host + "\r\n\r\n"))
+		require.ErrorIs(t, err, ErrInvalidHost, host)
+	}
 
 	// Test: Duplicate headers
 	reader = &chunkReader{
@@ -196,6 +232,49 @@ func TestBodyParse(t *testing.T) {
 	}
 	r, err = RequestFromReader(reader)
 	require.Error(t, err)
+
+	// Test: Bytes past the body are returned by ReadRequest
+	reader = &chunkReader{
+		data: "POST /submit HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Content-Length: 5\r\n" +
+			"\r\n" +
+			"helloGET / HTTP/1.1\r\n",
+		numBytesPerRead: 64,
+	}
+	r, unread, err := ReadRequest(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(r.Body))
+	assert.NotEmpty(t, unread)
+	rest, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "GET / HTTP/1.1\r\n", string(unread)+string(rest))
 }
 
 // "No Content-Length but Body Exists" (shouldn't error, we're assuming Content-Length will be present if a body exists)hh
+
+func TestCookies(t *testing.T) {
+	// Test: Cookies from repeated Cookie headers are all returned
+	reader := &chunkReader{
+		data:            "GET / HTTP/1.1\r\nHost: localhost\r\nCookie: a=1; b=\"two\"\r\nCookie: c=3\r\n\r\n",
+		numBytesPerRead: 5,
+	}
+	r, err := RequestFromReader(reader)
+	require.NoError(t, err)
+	cookies := r.Cookies()
+	require.Len(t, cookies, 3)
+	assert.Equal(t, "a", cookies[0].Name)
+	assert.Equal(t, "two", cookies[1].Value)
+	assert.Equal(t, "3", cookies[2].Value)
+
+	c, ok := r.Cookie("b")
+	require.True(t, ok)
+	assert.Equal(t, "two", c.Value)
+	_, ok = r.Cookie("missing")
+	assert.False(t, ok)
+
+	// Test: No Cookie header
+	r, err = RequestFromReader(&chunkReader{data: "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n", numBytesPerRead: 5})
+	require.NoError(t, err)
+	assert.Empty(t, r.Cookies())
+}
@@ -0,0 +1,188 @@
+package response
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"httpfromtcp/internal/headers"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// NegotiateEncoding picks the content coding from supported that the client
+// prefers according to its Accept-Encoding header. Ties go to the earlier
+// entry in supported. It returns "" when the body should be sent as is.
+func NegotiateEncoding(acceptEncoding string, supported []string) string {
+	qualities := map[string]float64{}
+	wildcard := -1.0
+
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		coding, params, _ := strings.Cut(part, ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		if coding == "" {
+			continue
+		}
+
+		q := 1.0
+		for _, param := range strings.Split(params, ";") {
+			name, value, ok := strings.Cut(strings.TrimSpace(param), "=")
+			if ok && strings.ToLower(name) == "q" {
+				if parsed, err := strconv.ParseFloat(value, 64); err == nil {
+					q = parsed
+				}
+			}
+		}
+
+		if coding == "*" {
+			wildcard = q
+		} else {
+			qualities[coding] = q
+		}
+	}
+
+	best, bestQ := "", 0.0
+	for _, coding := range supported {
+		q, ok := qualities[coding]
+		if !ok {
+			q = max(wildcard, 0)
+		}
+		if q > bestQ {
+			best, bestQ = coding, q
+		}
+	}
+	return best
+}
+
+// Compressible reports whether a body of the given content type is worth
+// compressing; media that is already compressed is not.
+func Compressible(contentType string) bool {
+	mediaType, _, _ := strings.Cut(strings.ToLower(contentType), ";")
+	mediaType = strings.TrimSpace(mediaType)
+
+	switch {
+	case strings.HasPrefix(mediaType, "text/"),
+		strings.HasSuffix(mediaType, "+json"),
+		strings.HasSuffix(mediaType, "+xml"):
+		return true
+	}
+
+	switch mediaType {
+	case "application/json", "application/javascript", "application/xml", "application/wasm", "image/svg+xml":
+		return true
+	}
+	return false
+}
+
+// SetCompression makes the writer compress the body with encoding ("gzip" or
+// "deflate") if the response turns out to be compressible: its content type
+// passes Compressible, it isn't encoded already, and a fixed-length body is
+// at least minSize bytes. It must be called before WriteHeaders.
+func (w *Writer) SetCompression(encoding string, minSize int) error {
+	if encoding != "gzip" && encoding != "deflate" {
+		return fmt.Errorf("Unsupported content coding: %s", encoding)
+	}
+	w.compression = &compression{encoding: encoding, minSize: minSize}
+	return nil
+}
+
+type flushWriteCloser interface {
+	io.WriteCloser
+	Flush() error
+}
+
+type compression struct {
+	encoding string
+	minSize  int
+
+	// pending holds the headers of a fixed-length response until the body
+	// has been compressed and its new length is known.
+	pending headers.Headers
+
+	buf        bytes.Buffer
+	compressor flushWriteCloser
+}
+
+// applies decides from the handler's headers whether to compress at all.
+func (c *compression) applies(h headers.Headers) bool {
+	if _, encoded := h.Get("content-encoding"); encoded {
+		return false
+	}
+	contentType, _ := h.Get("content-type")
+	if !Compressible(contentType) {
+		return false
+	}
+	if isChunked(h) {
+		return true
+	}
+	contentLength, _ := h.Get("content-length")
+	length, err := strconv.Atoi(contentLength)
+	return err == nil && length >= c.minSize
+}
+
+func (c *compression) newCompressor(w io.Writer) flushWriteCloser {
+	if c.encoding == "deflate" {
+		return zlib.NewWriter(w)
+	}
+	return gzip.NewWriter(w)
+}
+
+// compress returns p compressed as a complete stream.
+func (c *compression) compress(p []byte) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	compressor := c.newCompressor(buf)
+	if _, err := compressor.Write(p); err != nil {
+		return nil, err
+	}
+	if err := compressor.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// compressChunk feeds p to the stream compressor and returns whatever
+// compressed output is ready, flushed so each chunk can be decoded as soon as
+// it arrives.
+func (c *compression) compressChunk(p []byte) ([]byte, error) {
+	if c.compressor == nil {
+		c.compressor = c.newCompressor(&c.buf)
+	}
+	if _, err := c.compressor.Write(p); err != nil {
+		return nil, err
+	}
+	if err := c.compressor.Flush(); err != nil {
+		return nil, err
+	}
+	return c.take(), nil
+}
+
+// finish ends the compressed stream and returns its last bytes.
+func (c *compression) finish() ([]byte, error) {
+	if c.compressor == nil {
+		c.compressor = c.newCompressor(&c.buf)
+	}
+	if err := c.compressor.Close(); err != nil {
+		return nil, err
+	}
+	return c.take(), nil
+}
+
+func (c *compression) take() []byte {
+	out := bytes.Clone(c.buf.Bytes())
+	c.buf.Reset()
+	return out
+}
+
+func isChunked(h headers.Headers) bool {
+	transferEncoding, _ := h.Get("transfer-encoding")
+	return strings.Contains(strings.ToLower(transferEncoding), "chunked")
+}
+
+func cloneHeaders(h headers.Headers) headers.Headers {
+	clone := headers.NewHeaders()
+	for key, value := range h {
+		clone.Update(key, value)
+	}
+	return clone
+}
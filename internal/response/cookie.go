@@ -0,0 +1,13 @@
+package response
+
+import "httpfromtcp/internal/cookie"
+
+// SetCookie adds a Set-Cookie header for c to the response, which must
+// happen before WriteHeaders. Every cookie is written on its own header line.
+func (w *Writer) SetCookie(c *cookie.Cookie) error {
+	if err := c.Valid(); err != nil {
+		return err
+	}
+	w.Header().Set("Set-Cookie", c.String())
+	return nil
+}
@@ -0,0 +1,45 @@
+package response
+
+import (
+	"errors"
+	"net"
+)
+
+var (
+	ErrNotHijackable = errors.New("connection cannot be hijacked")
+	ErrHijacked      = errors.New("connection has been hijacked")
+)
+
+// Hijacker is implemented by the destination of a Writer when the connection
+// behind it can be taken over by the handler.
+type Hijacker interface {
+	Hijack() (net.Conn, []byte, error)
+}
+
+// Hijack takes over the connection the response is written to. It returns the
+// connection together with bytes the server already read from it but did not
+// consume, which come before anything still to be read from the connection.
+// Afterwards the Writer can no longer be used and the server leaves the
+// connection alone: closing it is up to the caller.
+func (w *Writer) Hijack() (net.Conn, []byte, error) {
+	if w.WriterStatus == writeHijacked {
+		return nil, nil, ErrHijacked
+	}
+
+	hijacker, ok := w.Writer.(Hijacker)
+	if !ok {
+		return nil, nil, ErrNotHijackable
+	}
+
+	conn, buffered, err := hijacker.Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+	w.WriterStatus = writeHijacked
+	return conn, buffered, nil
+}
+
+// Hijacked reports whether the connection has been taken over with Hijack.
+func (w *Writer) Hijacked() bool {
+	return w.WriterStatus == writeHijacked
+}
@@ -7,23 +7,77 @@ import (
 type StatusCode int
 
 const (
-	OK                  StatusCode = 200
-	BadRequest          StatusCode = 400
-	NotFound            StatusCode = 404
-	InternalServerError StatusCode = 500
+	SwitchingProtocols   StatusCode = 101
+	EarlyHints           StatusCode = 103
+	OK                   StatusCode = 200
+	NoContent            StatusCode = 204
+	NotModified          StatusCode = 304
+	BadRequest           StatusCode = 400
+	Unauthorized         StatusCode = 401
+	Forbidden            StatusCode = 403
+	NotFound             StatusCode = 404
+	MethodNotAllowed     StatusCode = 405
+	RequestTimeout       StatusCode = 408
+	ContentTooLarge      StatusCode = 413
+	UnsupportedMediaType StatusCode = 415
+	TooManyRequests      StatusCode = 429
+	InternalServerError  StatusCode = 500
+	BadGateway           StatusCode = 502
+	ServiceUnavailable   StatusCode = 503
 )
 
 func statusToString(statusCode StatusCode) (string, error) {
 	switch statusCode {
+	case SwitchingProtocols:
+		return "Switching Protocols", nil
+	case EarlyHints:
+		return "Early Hints", nil
 	case OK:
 		return "OK", nil
+	case NoContent:
+		return "No Content", nil
+	case NotModified:
+		return "Not Modified", nil
 	case BadRequest:
 		return "Bad Request", nil
+	case Unauthorized:
+		return "Unauthorized", nil
+	case Forbidden:
+		return "Forbidden", nil
 	case NotFound:
 		return "Not Found", nil
+	case MethodNotAllowed:
+		return "Method Not Allowed", nil
+	case RequestTimeout:
+		return "Request Timeout", nil
+	case ContentTooLarge:
+		return "Content Too Large", nil
+	case UnsupportedMediaType:
+		return "Unsupported Media Type", nil
+	case TooManyRequests:
+		return "Too Many Requests", nil
 	case InternalServerError:
 		return "Internal Server Error", nil
+	case BadGateway:
+		return "Bad Gateway", nil
+	case ServiceUnavailable:
+		return "Service Unavailable", nil
+	}
+
+	// Any other three-digit code, such as one passed on by a proxy, gets the
+	// name of its class; clients go by the number, not the phrase.
+	switch {
+	case statusCode < 100 || statusCode > 599:
+		return "", fmt.Errorf("Unknown status code: %d", statusCode)
+	case statusCode < 200:
+		return "Informational", nil
+	case statusCode < 300:
+		return "Success", nil
+	case statusCode < 400:
+		return "Redirection", nil
+	case statusCode < 500:
+		return "Client Error", nil
 	default:
-		return "", fmt.Errorf("Unknown status code: %q", statusCode)
+		return "Server Error", nil
 	}
 }
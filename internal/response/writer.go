@@ -5,11 +5,21 @@ import (
 	"httpfromtcp/internal/headers"
 	"io"
 	"log"
+	"strconv"
+	"strings"
 )
 
 type Writer struct {
 	Writer       io.Writer
 	WriterStatus writerStatus
+
+	statusCode   StatusCode
+	bytesWritten int
+	closeAfter   bool
+	header       headers.Headers
+	beforeHeader []func()
+	compression  *compression
+	discardBody  bool
 }
 
 type writerStatus int
@@ -19,6 +29,7 @@ const (
 	writeHeaders                        //2
 	writeBody                           //3
 	writeDone                           //4
+	writeHijacked
 )
 
 func NewWriter(w io.Writer) *Writer {
@@ -28,6 +39,60 @@ func NewWriter(w io.Writer) *Writer {
 	}
 }
 
+// Header returns headers to be sent along with the ones passed to
+// WriteHeaders. Middleware uses it to add headers to responses written by the
+// handlers it wraps; it has no effect once the headers are written.
+func (w *Writer) Header() headers.Headers {
+	if w.header == nil {
+		w.header = headers.NewHeaders()
+	}
+	return w.header
+}
+
+// BeforeHeaders registers f to run when WriteHeaders is called, before
+// anything is written, so middleware can add headers through Header that
+// depend on what the handler did up to that point.
+func (w *Writer) BeforeHeaders(f func()) {
+	w.beforeHeader = append(w.beforeHeader, f)
+}
+
+// DiscardBody makes w drop the body, chunks and trailers it is given while
+// still sending the status line and headers as written, Content-Length
+// included, which is how a HEAD request is answered.
+func (w *Writer) DiscardBody() {
+	w.discardBody = true
+}
+
+// HeadersSent reports whether any part of the response head has already been
+// written, after which the status code can no longer change.
+func (w *Writer) HeadersSent() bool {
+	return w.WriterStatus != writeStatusLine
+}
+
+// Complete reports whether a full response has been written, i.e. the body or
+// the trailers that end a chunked body.
+func (w *Writer) Complete() bool {
+	return w.WriterStatus == writeDone
+}
+
+// CloseRequested reports whether the response headers asked for the
+// connection to be closed after this response.
+func (w *Writer) CloseRequested() bool {
+	return w.closeAfter
+}
+
+// StatusCode returns the status code sent with the status line, or 0 if none
+// has been written yet.
+func (w *Writer) StatusCode() StatusCode {
+	return w.statusCode
+}
+
+// BytesWritten returns the number of body bytes written so far, excluding
+// chunk framing and trailers.
+func (w *Writer) BytesWritten() int {
+	return w.bytesWritten
+}
+
 func (w *Writer) WriteStatusLine(statusCode StatusCode) error {
 
 	if w.WriterStatus != writeStatusLine {
@@ -46,7 +111,33 @@ func (w *Writer) WriteStatusLine(statusCode StatusCode) error {
 	}
 
 	w.WriterStatus = writeHeaders
+	w.statusCode = statusCode
+
+	return nil
+}
 
+// WriteEarlyHints sends a 103 Early Hints interim response carrying h,
+// usually Link headers naming resources the client can start loading while
+// the final response is prepared. It can be called any number of times before
+// WriteStatusLine. HTTP/1.0 clients don't understand interim responses, so
+// only send them to later versions.
+func (w *Writer) WriteEarlyHints(h headers.Headers) error {
+	if w.WriterStatus != writeStatusLine {
+		return fmt.Errorf("Incorrect status %q", w.WriterStatus)
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "HTTP/1.1 %d Early Hints\r\n", EarlyHints)
+	for key := range h {
+		for _, value := range h.Values(key) {
+			fmt.Fprintf(&b, "%s: %s\r\n", key, value)
+		}
+	}
+	b.WriteString("\r\n")
+
+	if _, err := w.Writer.Write([]byte(b.String())); err != nil {
+		return fmt.Errorf("Error writing early hints: %s", err)
+	}
 	return nil
 }
 
@@ -55,12 +146,55 @@ func (w *Writer) WriteHeaders(headers headers.Headers) error {
 		return fmt.Errorf("Incorrect status %q", w.WriterStatus)
 	}
 
-	for key, value := range headers {
-		header := fmt.Sprintf("%s: %s\r\n", key, value)
-		_, err := w.Writer.Write([]byte(header))
+	hooks := w.beforeHeader
+	w.beforeHeader = nil
+	for _, f := range hooks {
+		f()
+	}
 
-		if err != nil {
-			return fmt.Errorf("Error writing header %s: %s", header, err)
+	if len(w.header) > 0 {
+		headers = mergeHeaders(w.header, headers)
+	}
+
+	if w.compression != nil {
+		switch {
+		case !w.compression.applies(headers):
+			w.compression = nil
+		case isChunked(headers):
+			headers = cloneHeaders(headers)
+			headers.Update("content-encoding", w.compression.encoding)
+		default:
+			// Sent by WriteBody once the compressed length is known.
+			w.compression.pending = cloneHeaders(headers)
+			w.WriterStatus = writeBody
+			return nil
+		}
+	}
+
+	return w.writeHeaderBlock(headers)
+}
+
+// FlushHeaders sends the headers WriteHeaders held back to compress a
+// fixed-length body, unchanged, if no body followed them. It does nothing
+// otherwise.
+func (w *Writer) FlushHeaders() error {
+	if w.compression == nil || w.compression.pending == nil {
+		return nil
+	}
+	headers := w.compression.pending
+	w.compression = nil
+	return w.writeHeaderBlock(headers)
+}
+
+func (w *Writer) writeHeaderBlock(headers headers.Headers) error {
+	for key := range headers {
+		for _, value := range headers.Values(key) {
+			header := fmt.Sprintf("%s: %s\r\n", key, value)
+			_, err := w.Writer.Write([]byte(header))
+
+			if err != nil {
+				return fmt.Errorf("Error writing header %s: %s", header, err)
+			}
 		}
 	}
 	_, err := w.Writer.Write([]byte("\r\n"))
@@ -69,6 +203,10 @@ func (w *Writer) WriteHeaders(headers headers.Headers) error {
 		return fmt.Errorf("Error writing header \\r\\n: %s", err)
 	}
 
+	if connection, ok := headers.Get("connection"); ok && strings.Contains(strings.ToLower(connection), "close") {
+		w.closeAfter = true
+	}
+
 	w.WriterStatus = writeBody
 
 	return nil
@@ -79,21 +217,70 @@ func (w *Writer) WriteBody(p []byte) (int, error) {
 		return 0, fmt.Errorf("Incorrect status %q", w.WriterStatus)
 	}
 
+	length := len(p)
+
+	if w.compression != nil && w.compression.pending != nil {
+		headers := w.compression.pending
+		compressed, err := w.compression.compress(p)
+		if err == nil && len(compressed) < len(p) {
+			headers.Update("content-encoding", w.compression.encoding)
+			headers.Update("content-length", strconv.Itoa(len(compressed)))
+			p = compressed
+		}
+		w.compression = nil
+
+		if err := w.writeHeaderBlock(headers); err != nil {
+			return 0, err
+		}
+	}
+
+	if w.discardBody {
+		w.WriterStatus = writeDone
+		return length, nil
+	}
+
 	n, err := w.Writer.Write(p)
+	w.bytesWritten += n
 	if err != nil {
 		return 0, fmt.Errorf("Error writing body: %s", err)
 	}
 
 	w.WriterStatus = writeDone
 
-	return n, nil
+	return min(n, length), nil
 }
 
+// WriteChunkedBody writes p as one chunk. An empty p writes nothing, since a
+// zero-length chunk would end the body.
 func (w *Writer) WriteChunkedBody(p []byte) (int, error) {
 	if w.WriterStatus != writeBody {
 		return 0, fmt.Errorf("Incorrect status %q", w.WriterStatus)
 	}
 
+	length := len(p)
+
+	if w.compression != nil {
+		compressed, err := w.compression.compressChunk(p)
+		if err != nil {
+			return 0, fmt.Errorf("Error compressing body: %s", err)
+		}
+		p = compressed
+	}
+
+	if len(p) == 0 {
+		return length, nil
+	}
+
+	if _, err := w.writeChunk(p); err != nil {
+		return 0, err
+	}
+	return length, nil
+}
+
+func (w *Writer) writeChunk(p []byte) (int, error) {
+	if w.discardBody {
+		return len(p), nil
+	}
 	length := fmt.Sprintf("%x\r\n", len(p))
 	_, err := w.Writer.Write([]byte(length))
 	if err != nil {
@@ -101,13 +288,35 @@ func (w *Writer) WriteChunkedBody(p []byte) (int, error) {
 	}
 
 	n, err := w.Writer.Write(p)
+	w.bytesWritten += n
 	if err != nil {
 		return 0, fmt.Errorf("Error writing body: %s", err)
 	}
 	_, err = w.Writer.Write([]byte("\r\n"))
+	if err != nil {
+		return 0, fmt.Errorf("Error writing body: %s", err)
+	}
 	return n, nil
 }
+
 func (w *Writer) WriteChunkedBodyDone() (int, error) {
+	if w.compression != nil {
+		tail, err := w.compression.finish()
+		w.compression = nil
+		if err != nil {
+			return 0, fmt.Errorf("Error compressing body: %s", err)
+		}
+		if len(tail) > 0 {
+			if _, err := w.writeChunk(tail); err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	if w.discardBody {
+		return 0, nil
+	}
+
 	zeroLength := fmt.Sprintf("%x\r\n", 0)
 	n, err := w.Writer.Write([]byte(zeroLength))
 	if err != nil {
@@ -118,14 +327,20 @@ func (w *Writer) WriteChunkedBodyDone() (int, error) {
 }
 
 func (w *Writer) WriteTrailers(h headers.Headers) error {
+	if w.discardBody {
+		w.WriterStatus = writeDone
+		return nil
+	}
 
-	for key, value := range h {
-		header := fmt.Sprintf("%s: %s\r\n", key, value)
-		log.Printf("Adding trailer %s\n", header)
-		_, err := w.Writer.Write([]byte(header))
+	for key := range h {
+		for _, value := range h.Values(key) {
+			header := fmt.Sprintf("%s: %s\r\n", key, value)
+			log.Printf("Adding trailer %s\n", header)
+			_, err := w.Writer.Write([]byte(header))
 
-		if err != nil {
-			return fmt.Errorf("Error writing header %s: %s", header, err)
+			if err != nil {
+				return fmt.Errorf("Error writing header %s: %s", header, err)
+			}
 		}
 	}
 	_, err := w.Writer.Write([]byte("\r\n"))
@@ -134,5 +349,20 @@ func (w *Writer) WriteTrailers(h headers.Headers) error {
 		return fmt.Errorf("Error writing header \\r\\n: %s", err)
 	}
 
+	w.WriterStatus = writeDone
+
 	return nil
 }
+
+// mergeHeaders combines the headers added through Header with the ones a
+// handler writes; values for the same key are joined.
+func mergeHeaders(added, written headers.Headers) headers.Headers {
+	merged := headers.NewHeaders()
+	for key, value := range added {
+		merged.Set(key, value)
+	}
+	for key, value := range written {
+		merged.Set(key, value)
+	}
+	return merged
+}
@@ -0,0 +1,282 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"httpfromtcp/internal/response"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// aLongTimeAgo is a non-zero time in the past, used to unblock a pending read.
+var aLongTimeAgo = time.Unix(1, 0)
+
+// connReader reads from the client connection and counts the bytes received.
+// Between requests it can run a background read that notices the client
+// hanging up; a byte read that way is kept and returned by the next Read, as
+// are bytes the request parser read past the end of a request.
+type connReader struct {
+	conn    net.Conn
+	metrics *metrics
+
+	mu      sync.Mutex
+	cond    *sync.Cond
+	inRead  bool
+	aborted bool
+	pending []byte
+	byteBuf [1]byte
+	cancel  context.CancelFunc
+}
+
+func newConnReader(conn net.Conn, m *metrics) *connReader {
+	cr := &connReader{
+		conn:    conn,
+		metrics: m,
+	}
+	cr.cond = sync.NewCond(&cr.mu)
+	return cr
+}
+
+func (cr *connReader) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	cr.mu.Lock()
+	if cr.inRead {
+		cr.mu.Unlock()
+		return 0, errors.New("concurrent read on connection")
+	}
+	if len(cr.pending) > 0 {
+		n := copy(p, cr.pending)
+		cr.pending = cr.pending[n:]
+		cr.mu.Unlock()
+		return n, nil
+	}
+	cr.mu.Unlock()
+
+	n, err := cr.conn.Read(p)
+	cr.metrics.addBytesIn(n)
+	return n, err
+}
+
+// startBackgroundRead watches the connection for a hang-up while a handler
+// runs, calling cancel if the read fails.
+func (cr *connReader) startBackgroundRead(cancel context.CancelFunc) {
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+
+	if cr.inRead || len(cr.pending) > 0 {
+		return
+	}
+	cr.inRead = true
+	cr.cancel = cancel
+	go cr.backgroundRead()
+}
+
+func (cr *connReader) backgroundRead() {
+	n, err := cr.conn.Read(cr.byteBuf[:])
+	cr.metrics.addBytesIn(n)
+
+	cr.mu.Lock()
+	if n == 1 {
+		cr.pending = append(cr.pending, cr.byteBuf[0])
+	}
+	if err != nil && !(cr.aborted && errors.Is(err, os.ErrDeadlineExceeded)) {
+		cr.cancel()
+	}
+	cr.aborted = false
+	cr.inRead = false
+	cr.mu.Unlock()
+	cr.cond.Broadcast()
+}
+
+// abortPendingRead stops a background read started by startBackgroundRead and
+// waits for it to return, so the next request can be read from the connection.
+func (cr *connReader) abortPendingRead() {
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+
+	if !cr.inRead {
+		return
+	}
+	cr.aborted = true
+	cr.conn.SetReadDeadline(aLongTimeAgo)
+	for cr.inRead {
+		cr.cond.Wait()
+	}
+	cr.conn.SetReadDeadline(time.Time{})
+}
+
+// waitForData blocks until the client sends the first byte of another request
+// and reports false if the connection was closed instead.
+func (cr *connReader) waitForData() bool {
+	cr.mu.Lock()
+	if len(cr.pending) > 0 {
+		cr.mu.Unlock()
+		return true
+	}
+	cr.mu.Unlock()
+
+	n, _ := cr.conn.Read(cr.byteBuf[:])
+	cr.metrics.addBytesIn(n)
+	if n == 0 {
+		return false
+	}
+
+	cr.mu.Lock()
+	cr.pending = append(cr.pending, cr.byteBuf[0])
+	cr.mu.Unlock()
+	return true
+}
+
+// hasPrefix reports whether the connection starts with prefix. It reads only
+// as long as the bytes match, and everything it reads is returned by the
+// following reads.
+func (cr *connReader) hasPrefix(prefix string) bool {
+	for {
+		cr.mu.Lock()
+		pending := string(cr.pending)
+		cr.mu.Unlock()
+
+		if !strings.HasPrefix(prefix, pending) && !strings.HasPrefix(pending, prefix) {
+			return false
+		}
+		if len(pending) >= len(prefix) {
+			return true
+		}
+
+		buf := make([]byte, len(prefix)-len(pending))
+		n, err := cr.conn.Read(buf)
+		cr.metrics.addBytesIn(n)
+
+		cr.mu.Lock()
+		cr.pending = append(cr.pending, buf[:n]...)
+		cr.mu.Unlock()
+		if err != nil {
+			return false
+		}
+	}
+}
+
+// unread puts back bytes that were read from the connection but not used, to
+// be returned by the following reads.
+func (cr *connReader) unread(p []byte) {
+	if len(p) == 0 {
+		return
+	}
+
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+	cr.pending = append(append([]byte(nil), p...), cr.pending...)
+}
+
+// takePending returns and forgets the bytes that would be returned by the
+// next reads without touching the connection.
+func (cr *connReader) takePending() []byte {
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+
+	pending := cr.pending
+	cr.pending = nil
+	return pending
+}
+
+// setReadTimeout makes reads from conn fail once d has elapsed, or never for
+// a zero duration.
+func setReadTimeout(conn net.Conn, d time.Duration) {
+	var deadline time.Time
+	if d > 0 {
+		deadline = time.Now().Add(d)
+	}
+	conn.SetReadDeadline(deadline)
+}
+
+// headerReader clears the connection's read deadline once the blank line
+// ending the request headers has been read through it, so the header timeout
+// doesn't cut off a slow body.
+type headerReader struct {
+	r    io.Reader
+	conn net.Conn
+	// matched is how much of "\r\n\r\n" the bytes read so far end with.
+	matched int
+	done    bool
+}
+
+func (hr *headerReader) Read(p []byte) (int, error) {
+	n, err := hr.r.Read(p)
+	for _, b := range p[:n] {
+		if hr.done {
+			break
+		}
+		switch {
+		case b == "\r\n\r\n"[hr.matched]:
+			hr.matched++
+		case b == '\r':
+			hr.matched = 1
+		default:
+			hr.matched = 0
+		}
+		if hr.matched == 4 {
+			hr.done = true
+			hr.conn.SetReadDeadline(time.Time{})
+		}
+	}
+	return n, err
+}
+
+// countingWriter counts the bytes sent to the client.
+type countingWriter struct {
+	w       io.Writer
+	metrics *metrics
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.metrics.addBytesOut(n)
+	return n, err
+}
+
+// hijackableWriter is what the responses on a connection are written to. It
+// lets handlers take the connection over with response.Writer.Hijack.
+type hijackableWriter struct {
+	countingWriter
+	conn     net.Conn
+	reader   *connReader
+	hijacked bool
+}
+
+func (hw *hijackableWriter) Hijack() (net.Conn, []byte, error) {
+	if hw.hijacked {
+		return nil, nil, response.ErrHijacked
+	}
+	hw.reader.abortPendingRead()
+	hw.hijacked = true
+	return hw.conn, hw.reader.takePending(), nil
+}
+
+// ErrRequestTooLarge is returned when a request exceeds WithMaxRequestBytes.
+var ErrRequestTooLarge = errors.New("request too large")
+
+// limitedReader fails with ErrRequestTooLarge once more than remaining bytes
+// have been read.
+type limitedReader struct {
+	r         io.Reader
+	remaining int64
+}
+
+func (lr *limitedReader) Read(p []byte) (int, error) {
+	if lr.remaining <= 0 {
+		return 0, ErrRequestTooLarge
+	}
+	if int64(len(p)) > lr.remaining {
+		p = p[:lr.remaining]
+	}
+	n, err := lr.r.Read(p)
+	lr.remaining -= int64(n)
+	return n, err
+}
@@ -0,0 +1,118 @@
+package server
+
+import (
+	"bufio"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeepAlive(t *testing.T) {
+	s, err := Serve(testPort+7, func(w *response.Writer, req *request.Request) {
+		body := []byte(req.RequestLine.RequestTarget)
+		headers := response.GetDefaultHeaders(len(body))
+		headers.Delete("connection")
+		if req.RequestLine.RequestTarget == "/close" {
+			headers.Set("Connection", "close")
+		}
+		w.WriteStatusLine(response.OK)
+		w.WriteHeaders(headers)
+		w.WriteBody(body)
+	})
+	require.NoError(t, err)
+	defer s.Close()
+
+	// Test: Requests sent one after another share the connection
+	conn := dial(t, testPort+7)
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	for _, target := range []string{"/a", "/b"} {
+		_, err = conn.Write([]byte("GET " + target + " HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+		require.NoError(t, err)
+		res, err := http.ReadResponse(reader, nil)
+		require.NoError(t, err)
+		body, err := io.ReadAll(res.Body)
+		require.NoError(t, err)
+		assert.Equal(t, target, string(body))
+	}
+
+	// Test: A response asking to close ends the connection
+	_, err = conn.Write([]byte("GET /close HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	res, err := http.ReadResponse(reader, nil)
+	require.NoError(t, err)
+	io.ReadAll(res.Body)
+	rest, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	assert.Empty(t, rest)
+
+	// Test: A client asking to close gets its response and the connection ends
+	conn2 := dial(t, testPort+7)
+	defer conn2.Close()
+	_, err = conn2.Write([]byte("GET /a HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n"))
+	require.NoError(t, err)
+	all, err := io.ReadAll(conn2)
+	require.NoError(t, err)
+	assert.True(t, strings.HasSuffix(string(all), "\r\n\r\n/a"))
+}
+
+func TestTimeouts(t *testing.T) {
+	s, err := Serve(testPort+8, func(w *response.Writer, req *request.Request) {
+		body := req.Body
+		headers := response.GetDefaultHeaders(len(body))
+		headers.Delete("connection")
+		w.WriteStatusLine(response.OK)
+		w.WriteHeaders(headers)
+		w.WriteBody(body)
+	}, WithIdleTimeout(100*time.Millisecond), WithReadHeaderTimeout(200*time.Millisecond))
+	require.NoError(t, err)
+	defer s.Close()
+
+	// Test: An idle kept-alive connection is closed
+	conn := dial(t, testPort+8)
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	reader := bufio.NewReader(conn)
+	_, err = conn.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	res, err := http.ReadResponse(reader, nil)
+	require.NoError(t, err)
+	io.ReadAll(res.Body)
+	start := time.Now()
+	rest, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	assert.Empty(t, rest)
+	assert.Less(t, time.Since(start), 2*time.Second)
+
+	// Test: Headers that don't arrive in time get 408
+	conn2 := dial(t, testPort+8)
+	defer conn2.Close()
+	conn2.SetDeadline(time.Now().Add(5 * time.Second))
+	_, err = conn2.Write([]byte("GET / HTTP/1.1\r\nHost: local"))
+	require.NoError(t, err)
+	all, err := io.ReadAll(conn2)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(all), "HTTP/1.1 408 Request Timeout\r\n"))
+
+	// Test: A slow body is not cut off by the header timeout
+	conn3 := dial(t, testPort+8)
+	defer conn3.Close()
+	conn3.SetDeadline(time.Now().Add(5 * time.Second))
+	_, err = conn3.Write([]byte("POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 4\r\n\r\nab"))
+	require.NoError(t, err)
+	time.Sleep(300 * time.Millisecond)
+	_, err = conn3.Write([]byte("cd"))
+	require.NoError(t, err)
+	res, err = http.ReadResponse(bufio.NewReader(conn3), nil)
+	require.NoError(t, err)
+	body, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "abcd", string(body))
+}
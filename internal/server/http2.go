@@ -0,0 +1,77 @@
+package server
+
+import (
+	"crypto/tls"
+	"httpfromtcp/internal/http2"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"io"
+	"log"
+	"net"
+)
+
+// WithHTTP2 serves HTTP/2 as well as HTTP/1.1. TLS listeners offer h2
+// through ALPN; without TLS, connections opening with the HTTP/2 client
+// preface (prior knowledge) and requests carrying Upgrade: h2c switch to
+// HTTP/2. Every stream goes to the same handler as HTTP/1.1 requests.
+func WithHTTP2() Option {
+	return func(s *Server) {
+		s.http2 = true
+	}
+}
+
+// speaksHTTP2 reports whether a new connection is an HTTP/2 one: a TLS client
+// that picked h2 through ALPN, or any client opening with the HTTP/2 preface.
+func (s *Server) speaksHTTP2(conn net.Conn, reader *connReader) bool {
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		if err := tlsConn.HandshakeContext(s.ctx); err != nil {
+			return false
+		}
+		if tlsConn.ConnectionState().NegotiatedProtocol == "h2" {
+			return true
+		}
+	}
+	return reader.hasPrefix(http2.ClientPreface)
+}
+
+// upgradeHTTP2 answers an Upgrade: h2c request with 101 Switching Protocols
+// and serves the rest of the connection as HTTP/2, with req as stream 1.
+func (s *Server) upgradeHTTP2(conn net.Conn, reader *connReader, w *response.Writer, req *request.Request) {
+	if err := w.WriteStatusLine(response.SwitchingProtocols); err != nil {
+		return
+	}
+	h := response.GetDefaultHeaders(0)
+	h.Delete("Content-Length")
+	h.Delete("Content-Type")
+	h.Update("Connection", "Upgrade")
+	h.Set("Upgrade", "h2c")
+	if err := w.WriteHeaders(h); err != nil {
+		return
+	}
+
+	s.serveHTTP2(conn, reader, w.Writer, req)
+}
+
+func (s *Server) serveHTTP2(conn net.Conn, reader *connReader, out io.Writer, upgrade *request.Request) {
+	h2 := &http2.Server{
+		Handler:         s.serveStream,
+		MaxRequestBytes: s.maxRequestBytes,
+	}
+	rw := struct {
+		io.Reader
+		io.Writer
+	}{reader, out}
+
+	if err := h2.ServeConn(s.connContext(conn), conn, rw, upgrade); err != nil {
+		log.Printf("Error serving HTTP/2 connection from %s: %s", conn.RemoteAddr(), err)
+	}
+}
+
+// serveStream answers one HTTP/2 stream. The connection is shared with other
+// streams, so a panic after the headers went out resets only this stream.
+func (s *Server) serveStream(w *response.Writer, req *request.Request) {
+	ctx, cancel := s.withRequestTimeout(req.Context())
+	defer cancel()
+
+	s.runHandler(nil, w, req.WithContext(ctx), s.serveHandler)
+}
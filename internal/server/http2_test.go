@@ -0,0 +1,158 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"httpfromtcp/internal/headers"
+	"httpfromtcp/internal/http2"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHTTP2(t *testing.T) {
+	handler := func(w *response.Writer, req *request.Request) {
+		switch req.RequestLine.RequestTarget {
+		case "/echo":
+			w.WriteStatusLine(response.OK)
+			w.WriteHeaders(response.GetDefaultHeaders(len(req.Body)))
+			w.WriteBody(req.Body)
+		case "/chunked":
+			w.WriteStatusLine(response.OK)
+			h := headers.NewHeaders()
+			h.Set("Transfer-Encoding", "chunked")
+			h.Set("Trailer", "X-Done")
+			w.WriteHeaders(h)
+			w.WriteChunkedBody([]byte("one,"))
+			w.WriteChunkedBody([]byte("two"))
+			w.WriteChunkedBodyDone()
+			trailers := headers.NewHeaders()
+			trailers.Set("X-Done", "yes")
+			w.WriteTrailers(trailers)
+		case "/panic":
+			panic("boom")
+		default:
+			body := []byte(req.RequestLine.HttpVersion)
+			w.WriteStatusLine(response.OK)
+			w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+			w.WriteBody(body)
+		}
+	}
+	s := New(handler, WithHTTP2())
+	defer s.Close()
+	addr, err := s.Listen("127.0.0.1:0")
+	require.NoError(t, err)
+	base := "http://" + addr.String()
+
+	var protocols http.Protocols
+	protocols.SetUnencryptedHTTP2(true)
+	transport := &http.Transport{Protocols: &protocols}
+	defer transport.CloseIdleConnections()
+	client := &http.Client{Transport: transport, Timeout: 5 * time.Second}
+
+	// Test: Prior knowledge clients are served over HTTP/2
+	res, err := client.Get(base + "/")
+	require.NoError(t, err)
+	body, err := io.ReadAll(res.Body)
+	res.Body.Close()
+	require.NoError(t, err)
+	assert.Equal(t, 2, res.ProtoMajor)
+	assert.Equal(t, "2", string(body))
+	assert.Equal(t, "text/plain", res.Header.Get("Content-Type"))
+	assert.Empty(t, res.Header.Get("Connection"))
+
+	// Test: Request bodies larger than the flow control window are echoed
+	large := bytes.Repeat([]byte("0123456789"), 100_000)
+	res, err = client.Post(base+"/echo", "application/octet-stream", bytes.NewReader(large))
+	require.NoError(t, err)
+	body, err = io.ReadAll(res.Body)
+	res.Body.Close()
+	require.NoError(t, err)
+	assert.Equal(t, large, body)
+
+	// Test: Chunked responses lose their framing and keep their trailers
+	res, err = client.Get(base + "/chunked")
+	require.NoError(t, err)
+	body, err = io.ReadAll(res.Body)
+	res.Body.Close()
+	require.NoError(t, err)
+	assert.Equal(t, "one,two", string(body))
+	assert.Equal(t, "yes", res.Trailer.Get("X-Done"))
+
+	// Test: Concurrent requests share one connection
+	var wg sync.WaitGroup
+	for i := range 20 {
+		wg.Go(func() {
+			payload := strings.Repeat(fmt.Sprint(i), 1000)
+			res, err := client.Post(base+"/echo", "text/plain", strings.NewReader(payload))
+			if !assert.NoError(t, err) {
+				return
+			}
+			defer res.Body.Close()
+			body, err := io.ReadAll(res.Body)
+			assert.NoError(t, err)
+			assert.Equal(t, payload, string(body))
+		})
+	}
+	wg.Wait()
+
+	// Test: A panicking handler only fails its own stream
+	res, err = client.Get(base + "/panic")
+	require.NoError(t, err)
+	res.Body.Close()
+	assert.Equal(t, http.StatusInternalServerError, res.StatusCode)
+	res, err = client.Get(base + "/")
+	require.NoError(t, err)
+	res.Body.Close()
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+
+	// Test: HTTP/1.1 requests are still served
+	assert.True(t, strings.HasSuffix(get(t, "tcp", addr.String()), "1.1"))
+
+	// Test: Upgrade: h2c switches protocols and answers the request on stream 1
+	conn, err := net.Dial("tcp", addr.String())
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	_, err = conn.Write([]byte("POST /echo HTTP/1.1\r\n" +
+		"Host: localhost\r\n" +
+		"Connection: Upgrade, HTTP2-Settings\r\n" +
+		"Upgrade: h2c\r\n" +
+		"HTTP2-Settings: " + base64.RawURLEncoding.EncodeToString(nil) + "\r\n" +
+		"Content-Length: 5\r\n" +
+		"\r\n" +
+		"hello"))
+	require.NoError(t, err)
+	reader := bufio.NewReader(conn)
+	res, err = http.ReadResponse(reader, &http.Request{Method: "POST"})
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusSwitchingProtocols, res.StatusCode)
+	assert.Equal(t, "h2c", res.Header.Get("Upgrade"))
+
+	_, err = conn.Write([]byte(http2.ClientPreface))
+	require.NoError(t, err)
+	require.NoError(t, http2.WriteFrame(conn, http2.FrameSettings, 0, 0, nil))
+	var data []byte
+	for {
+		f, err := http2.ReadFrame(reader, 1<<24-1)
+		require.NoError(t, err)
+		if f.Type == http2.FrameData && f.StreamID == 1 {
+			data = append(data, f.Payload...)
+			if f.Has(http2.FlagEndStream) {
+				break
+			}
+		}
+	}
+	assert.Equal(t, "hello", string(data))
+}
@@ -0,0 +1,174 @@
+package server
+
+import (
+	"httpfromtcp/internal/response"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	minAcceptDelay = 5 * time.Millisecond
+	maxAcceptDelay = time.Second
+
+	// maxOverloadWriters caps the refused connections answered at once.
+	maxOverloadWriters   = 64
+	overloadWriteTimeout = 10 * time.Millisecond
+)
+
+// WithMaxConnections caps the number of connections served at once. Further
+// connections are answered with the overload response and closed.
+func WithMaxConnections(n int) Option {
+	return func(s *Server) {
+		s.limiter.max = n
+	}
+}
+
+// WithMaxConnectionsPerIP caps the number of connections served at once for a
+// single client IP address.
+func WithMaxConnectionsPerIP(n int) Option {
+	return func(s *Server) {
+		s.limiter.maxPerIP = n
+	}
+}
+
+// WithOverloadResponse sets the Retry-After delay and body of the 503 sent to
+// connections refused by WithMaxConnections or WithMaxConnectionsPerIP.
+func WithOverloadResponse(retryAfter time.Duration, body string) Option {
+	return func(s *Server) {
+		s.overload = overloadResponse{retryAfter, body}
+	}
+}
+
+type overloadResponse struct {
+	retryAfter time.Duration
+	body       string
+}
+
+var defaultOverloadResponse = overloadResponse{
+	retryAfter: time.Second,
+	body:       "Service Unavailable",
+}
+
+// refuse answers a connection over the limits with the overload response and
+// closes it. Up to maxOverloadWriters connections are answered in the
+// background, each holding its descriptor for up to a second while the
+// request is drained; past that, a flood of them would pile up goroutines and
+// descriptors, so the response is only written if the socket takes it at once
+// and the connection is closed right away.
+func (s *Server) refuse(conn net.Conn) {
+	select {
+	case s.overloadWriters <- struct{}{}:
+		go func() {
+			defer func() { <-s.overloadWriters }()
+			defer conn.Close()
+			s.overload.write(conn)
+		}()
+	default:
+		defer conn.Close()
+		conn.SetWriteDeadline(time.Now().Add(overloadWriteTimeout))
+		s.overload.send(conn)
+	}
+}
+
+// write answers a refused connection without reading its request. The unread
+// request is drained afterwards, since closing a socket with unread data
+// resets it and the client might lose the response.
+func (o overloadResponse) write(conn net.Conn) {
+	conn.SetDeadline(time.Now().Add(time.Second))
+	defer func() {
+		if closeWriter, ok := conn.(interface{ CloseWrite() error }); ok {
+			closeWriter.CloseWrite()
+		}
+		io.Copy(io.Discard, conn)
+	}()
+	o.send(conn)
+}
+
+// send writes the 503 response to conn.
+func (o overloadResponse) send(conn net.Conn) {
+	w := response.NewWriter(conn)
+	body := []byte(o.body)
+
+	headers := response.GetDefaultHeaders(len(body))
+	seconds := int((o.retryAfter + time.Second - 1) / time.Second)
+	headers.Set("Retry-After", strconv.Itoa(seconds))
+
+	if err := w.WriteStatusLine(response.ServiceUnavailable); err != nil {
+		return
+	}
+	if err := w.WriteHeaders(headers); err != nil {
+		return
+	}
+	w.WriteBody(body)
+}
+
+// connLimiter counts open connections, overall and per client IP.
+type connLimiter struct {
+	max      int
+	maxPerIP int
+
+	mu    sync.Mutex
+	total int
+	perIP map[string]int
+}
+
+// acquire reports whether another connection from addr may be served. Every
+// successful acquire must be paired with a release.
+func (l *connLimiter) acquire(addr net.Addr) bool {
+	if l.max <= 0 && l.maxPerIP <= 0 {
+		return true
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	ip := remoteIP(addr)
+	if l.max > 0 && l.total >= l.max {
+		return false
+	}
+	if l.maxPerIP > 0 && l.perIP[ip] >= l.maxPerIP {
+		return false
+	}
+
+	if l.perIP == nil {
+		l.perIP = map[string]int{}
+	}
+	l.total++
+	l.perIP[ip]++
+	return true
+}
+
+func (l *connLimiter) release(addr net.Addr) {
+	if l.max <= 0 && l.maxPerIP <= 0 {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	ip := remoteIP(addr)
+	l.total--
+	if l.perIP[ip]--; l.perIP[ip] <= 0 {
+		delete(l.perIP, ip)
+	}
+}
+
+func remoteIP(addr net.Addr) string {
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
+
+// acceptDelay doubles the previous delay after a failed Accept, e.g. when the
+// process is out of file descriptors, so the accept loop doesn't spin.
+func acceptDelay(previous time.Duration) time.Duration {
+	if previous == 0 {
+		return minAcceptDelay
+	}
+	return min(previous*2, maxAcceptDelay)
+}
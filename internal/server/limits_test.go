@@ -0,0 +1,127 @@
+package server
+
+import (
+	"errors"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"io"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// failingListener fails every Accept until it is closed.
+type failingListener struct {
+	net.Listener
+	accepts atomic.Int32
+}
+
+func (l *failingListener) Accept() (net.Conn, error) {
+	l.accepts.Add(1)
+	if _, err := l.Listener.Accept(); errors.Is(err, net.ErrClosed) {
+		return nil, err
+	}
+	return nil, errors.New("accept: too many open files")
+}
+
+func TestConnectionLimits(t *testing.T) {
+	release := make(chan struct{})
+	blocking := func(w *response.Writer, req *request.Request) {
+		<-release
+		okHandler(w, req)
+	}
+
+	// Test: Connections over the limit get 503 with Retry-After
+	s := New(blocking, WithMaxConnections(1), WithOverloadResponse(1500*time.Millisecond, "busy"))
+	defer s.Close()
+	addr, err := s.Listen("127.0.0.1:0")
+	require.NoError(t, err)
+
+	first, err := net.Dial("tcp", addr.String())
+	require.NoError(t, err)
+	defer first.Close()
+	_, err = first.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	time.Sleep(50 * time.Millisecond)
+
+	res := get(t, "tcp", addr.String())
+	assert.True(t, strings.HasPrefix(res, "HTTP/1.1 503 Service Unavailable\r\n"))
+	assert.Contains(t, res, "retry-after: 2\r\n")
+	assert.True(t, strings.HasSuffix(res, "busy"))
+
+	// Test: Slot is released when the connection is done
+	close(release)
+	body, err := io.ReadAll(first)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(body), "HTTP/1.1 200 OK\r\n"))
+	time.Sleep(50 * time.Millisecond)
+	assert.True(t, strings.HasSuffix(get(t, "tcp", addr.String()), "hello"))
+
+	// Test: Per-IP cap
+	release = make(chan struct{})
+	s2 := New(blocking, WithMaxConnectionsPerIP(1))
+	defer s2.Close()
+	addr, err = s2.Listen("127.0.0.1:0")
+	require.NoError(t, err)
+
+	first, err = net.Dial("tcp", addr.String())
+	require.NoError(t, err)
+	defer first.Close()
+	_, err = first.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	time.Sleep(50 * time.Millisecond)
+
+	res = get(t, "tcp", addr.String())
+	assert.True(t, strings.HasPrefix(res, "HTTP/1.1 503 Service Unavailable\r\n"))
+	assert.Contains(t, res, "retry-after: 1\r\n")
+
+	// Test: Once too many refusals are being answered, more are closed at once
+	for range maxOverloadWriters {
+		s2.overloadWriters <- struct{}{}
+	}
+	conn, err := net.Dial("tcp", addr.String())
+	require.NoError(t, err)
+	defer conn.Close()
+	start := time.Now()
+	body, err = io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(body), "HTTP/1.1 503 Service Unavailable\r\n"))
+	assert.Less(t, time.Since(start), 500*time.Millisecond)
+	close(release)
+}
+
+func TestAcceptBackoff(t *testing.T) {
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	// Test: Failing Accept is retried with growing delays instead of spinning
+	listener := &failingListener{Listener: inner}
+	go func() {
+		for {
+			conn, err := net.Dial("tcp", inner.Addr().String())
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+
+	s := New(okHandler)
+	require.NoError(t, s.ServeListener(listener))
+	time.Sleep(200 * time.Millisecond)
+	s.Close()
+
+	// 5ms, 10ms, 20ms, 40ms, 80ms fit in 200ms
+	assert.LessOrEqual(t, listener.accepts.Load(), int32(7))
+	assert.GreaterOrEqual(t, listener.accepts.Load(), int32(3))
+
+	// Test: Delay doubles up to the maximum
+	assert.Equal(t, minAcceptDelay, acceptDelay(0))
+	assert.Equal(t, 2*minAcceptDelay, acceptDelay(minAcceptDelay))
+	assert.Equal(t, maxAcceptDelay, acceptDelay(maxAcceptDelay))
+}
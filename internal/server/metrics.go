@@ -0,0 +1,302 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// RouteFunc maps a request onto the route label used in metrics. Every
+// distinct label adds series that are kept for the life of the server, so it
+// must return one of a small, fixed set of values, such as the matched route
+// prefix, and never the raw request path.
+type RouteFunc func(req *request.Request) string
+
+// WithMetrics makes the server keep request metrics and serve them at path in
+// the Prometheus text exposition format.
+func WithMetrics(path string) Option {
+	return func(s *Server) {
+		s.metrics = newMetrics(path)
+	}
+}
+
+// WithMetricsRoute replaces the default route label, which is "other" for
+// every request since the server can't tell the handler's routes apart. It
+// only has effect together with WithMetrics.
+func WithMetricsRoute(route RouteFunc) Option {
+	return func(s *Server) {
+		s.metricsRoute = route
+	}
+}
+
+const defaultRoute = "other"
+
+// requestPath returns the request target without its query string.
+func requestPath(req *request.Request) string {
+	path, _, _ := strings.Cut(req.RequestLine.RequestTarget, "?")
+	return path
+}
+
+var durationBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+type requestKey struct {
+	method string
+	route  string
+	status string
+}
+
+type durationKey struct {
+	method string
+	route  string
+}
+
+type histogram struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+func (h *histogram) observe(v float64) {
+	for i, bound := range durationBuckets {
+		if v <= bound {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += v
+}
+
+// metrics holds the server's counters. A nil *metrics records nothing, so
+// callers don't have to check whether metrics are enabled.
+type metrics struct {
+	path string
+
+	mu          sync.Mutex
+	requests    map[requestKey]uint64
+	durations   map[durationKey]*histogram
+	parseErrors map[string]uint64
+
+	bytesIn         atomic.Uint64
+	bytesOut        atomic.Uint64
+	activeConns     atomic.Int64
+	keepAliveReused atomic.Uint64
+	refusedConns    atomic.Uint64
+}
+
+func newMetrics(path string) *metrics {
+	return &metrics{
+		path:        path,
+		requests:    map[requestKey]uint64{},
+		durations:   map[durationKey]*histogram{},
+		parseErrors: map[string]uint64{},
+	}
+}
+
+func (m *metrics) addBytesIn(n int) {
+	if m != nil && n > 0 {
+		m.bytesIn.Add(uint64(n))
+	}
+}
+
+func (m *metrics) addBytesOut(n int) {
+	if m != nil && n > 0 {
+		m.bytesOut.Add(uint64(n))
+	}
+}
+
+func (m *metrics) connOpened() {
+	if m != nil {
+		m.activeConns.Add(1)
+	}
+}
+
+func (m *metrics) connClosed() {
+	if m != nil {
+		m.activeConns.Add(-1)
+	}
+}
+
+func (m *metrics) connReused() {
+	if m != nil {
+		m.keepAliveReused.Add(1)
+	}
+}
+
+func (m *metrics) connRefused() {
+	if m != nil {
+		m.refusedConns.Add(1)
+	}
+}
+
+func (m *metrics) parseError(err error) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.parseErrors[parseErrorKind(err)]++
+}
+
+func (m *metrics) observeRequest(method, route string, status response.StatusCode, duration time.Duration) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.requests[requestKey{method, route, strconv.Itoa(int(status))}]++
+
+	key := durationKey{method, route}
+	h, ok := m.durations[key]
+	if !ok {
+		h = &histogram{counts: make([]uint64, len(durationBuckets))}
+		m.durations[key] = h
+	}
+	h.observe(duration.Seconds())
+}
+
+func parseErrorKind(err error) string {
+	switch {
+	case errors.Is(err, request.ErrIncompleteRequest):
+		return "incomplete"
+	case errors.Is(err, request.ErrMalformedRequestLine):
+		return "request_line"
+	case errors.Is(err, request.ErrInvalidMethod):
+		return "method"
+	case errors.Is(err, request.ErrUnsupportedVersion):
+		return "version"
+	case errors.Is(err, request.ErrMalformedHeader):
+		return "header"
+	case errors.Is(err, request.ErrInvalidContentLength):
+		return "content_length"
+	case errors.Is(err, request.ErrInvalidHost):
+		return "host"
+	case errors.Is(err, ErrRequestTooLarge):
+		return "too_large"
+	case errors.Is(err, os.ErrDeadlineExceeded):
+		return "timeout"
+	default:
+		return "other"
+	}
+}
+
+// writeTo writes all metrics in the Prometheus text exposition format.
+func (m *metrics) writeTo(w io.Writer) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	b := &strings.Builder{}
+
+	b.WriteString("# HELP httpfromtcp_requests_total Requests served, by method, route and status.\n")
+	b.WriteString("# TYPE httpfromtcp_requests_total counter\n")
+	requestKeys := make([]requestKey, 0, len(m.requests))
+	for key := range m.requests {
+		requestKeys = append(requestKeys, key)
+	}
+	sort.Slice(requestKeys, func(i, j int) bool {
+		a, b := requestKeys[i], requestKeys[j]
+		if a.method != b.method {
+			return a.method < b.method
+		}
+		if a.route != b.route {
+			return a.route < b.route
+		}
+		return a.status < b.status
+	})
+	for _, key := range requestKeys {
+		fmt.Fprintf(b, "httpfromtcp_requests_total{method=%s,route=%s,status=%s} %d\n",
+			labelValue(key.method), labelValue(key.route), labelValue(key.status), m.requests[key])
+	}
+
+	b.WriteString("# HELP httpfromtcp_request_duration_seconds Time spent in the handler.\n")
+	b.WriteString("# TYPE httpfromtcp_request_duration_seconds histogram\n")
+	durationKeys := make([]durationKey, 0, len(m.durations))
+	for key := range m.durations {
+		durationKeys = append(durationKeys, key)
+	}
+	sort.Slice(durationKeys, func(i, j int) bool {
+		a, b := durationKeys[i], durationKeys[j]
+		if a.method != b.method {
+			return a.method < b.method
+		}
+		return a.route < b.route
+	})
+	for _, key := range durationKeys {
+		h := m.durations[key]
+		labels := fmt.Sprintf("method=%s,route=%s", labelValue(key.method), labelValue(key.route))
+		for i, bound := range durationBuckets {
+			fmt.Fprintf(b, "httpfromtcp_request_duration_seconds_bucket{%s,le=\"%s\"} %d\n",
+				labels, strconv.FormatFloat(bound, 'g', -1, 64), h.counts[i])
+		}
+		fmt.Fprintf(b, "httpfromtcp_request_duration_seconds_bucket{%s,le=\"+Inf\"} %d\n", labels, h.count)
+		fmt.Fprintf(b, "httpfromtcp_request_duration_seconds_sum{%s} %s\n", labels, strconv.FormatFloat(h.sum, 'g', -1, 64))
+		fmt.Fprintf(b, "httpfromtcp_request_duration_seconds_count{%s} %d\n", labels, h.count)
+	}
+
+	b.WriteString("# HELP httpfromtcp_parse_errors_total Requests rejected by the parser, by kind.\n")
+	b.WriteString("# TYPE httpfromtcp_parse_errors_total counter\n")
+	kinds := make([]string, 0, len(m.parseErrors))
+	for kind := range m.parseErrors {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	for _, kind := range kinds {
+		fmt.Fprintf(b, "httpfromtcp_parse_errors_total{kind=%s} %d\n", labelValue(kind), m.parseErrors[kind])
+	}
+
+	b.WriteString("# HELP httpfromtcp_received_bytes_total Bytes read from clients.\n")
+	b.WriteString("# TYPE httpfromtcp_received_bytes_total counter\n")
+	fmt.Fprintf(b, "httpfromtcp_received_bytes_total %d\n", m.bytesIn.Load())
+
+	b.WriteString("# HELP httpfromtcp_sent_bytes_total Bytes written to clients.\n")
+	b.WriteString("# TYPE httpfromtcp_sent_bytes_total counter\n")
+	fmt.Fprintf(b, "httpfromtcp_sent_bytes_total %d\n", m.bytesOut.Load())
+
+	b.WriteString("# HELP httpfromtcp_active_connections Connections currently open.\n")
+	b.WriteString("# TYPE httpfromtcp_active_connections gauge\n")
+	fmt.Fprintf(b, "httpfromtcp_active_connections %d\n", m.activeConns.Load())
+
+	b.WriteString("# HELP httpfromtcp_refused_connections_total Connections refused by the connection limits.\n")
+	b.WriteString("# TYPE httpfromtcp_refused_connections_total counter\n")
+	fmt.Fprintf(b, "httpfromtcp_refused_connections_total %d\n", m.refusedConns.Load())
+
+	b.WriteString("# HELP httpfromtcp_keepalive_reused_total Requests served on a reused connection.\n")
+	b.WriteString("# TYPE httpfromtcp_keepalive_reused_total counter\n")
+	fmt.Fprintf(b, "httpfromtcp_keepalive_reused_total %d\n", m.keepAliveReused.Load())
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// serve answers a scrape of the metrics path.
+func (m *metrics) serve(w *response.Writer) {
+	b := &strings.Builder{}
+	m.writeTo(b)
+	body := []byte(b.String())
+
+	if err := w.WriteStatusLine(response.OK); err != nil {
+		return
+	}
+	headers := response.GetDefaultHeaders(len(body))
+	headers.Update("content-type", "text/plain; version=0.0.4")
+	if err := w.WriteHeaders(headers); err != nil {
+		return
+	}
+	w.WriteBody(body)
+}
+
+func labelValue(v string) string {
+	v = strings.ReplaceAll(v, `\`, `\\`)
+	v = strings.ReplaceAll(v, "\n", `\n`)
+	v = strings.ReplaceAll(v, `"`, `\"`)
+	return `"` + v + `"`
+}
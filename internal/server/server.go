@@ -1,79 +1,480 @@
 package server
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
+	"httpfromtcp/internal/http2"
 	"httpfromtcp/internal/request"
 	"httpfromtcp/internal/response"
+	"io"
 	"log"
 	"net"
+	"os"
+	"runtime/debug"
+	"slices"
+	"strings"
+	"sync"
 	"sync/atomic"
+	"time"
 )
 
 type Handler func(w *response.Writer, req *request.Request)
 
 type Server struct {
-	listener net.Listener
-	handler  Handler
-	closed   atomic.Bool
+	mu        sync.Mutex
+	listeners []net.Listener
+	handler   atomic.Pointer[Handler]
+	closed    atomic.Bool
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	requestTimeout    time.Duration
+	idleTimeout       time.Duration
+	readHeaderTimeout time.Duration
+	maxRequestBytes   int64
+	panicHandler      PanicHandler
+
+	metrics      *metrics
+	metricsRoute RouteFunc
+
+	limiter         connLimiter
+	overload        overloadResponse
+	overloadWriters chan struct{}
+
+	http2 bool
+}
+
+// PanicHandler is called with the recovered value and stack trace whenever a
+// handler panics, e.g. to report the panic to an error tracker.
+type PanicHandler func(req *request.Request, recovered any, stack []byte)
+
+// Option configures optional Server behaviour in New and Serve.
+type Option func(*Server)
+
+// WithRequestTimeout cancels each request's context once d has elapsed.
+// A zero duration disables the timeout.
+func WithRequestTimeout(d time.Duration) Option {
+	return func(s *Server) {
+		s.requestTimeout = d
+	}
 }
 
+// WithIdleTimeout closes a kept-alive connection when the next request
+// doesn't start within d. It defaults to two minutes; a zero duration
+// disables the timeout.
+func WithIdleTimeout(d time.Duration) Option {
+	return func(s *Server) {
+		s.idleTimeout = d
+	}
+}
+
+// WithReadHeaderTimeout answers 408 Request Timeout and closes the
+// connection when a request's line and headers haven't arrived within d,
+// counted from the connection being accepted or, for a later request on a
+// kept-alive connection, from its first byte. The body is not covered. It
+// defaults to ten seconds; a zero duration disables the timeout.
+func WithReadHeaderTimeout(d time.Duration) Option {
+	return func(s *Server) {
+		s.readHeaderTimeout = d
+	}
+}
+
+// WithMaxRequestBytes rejects requests whose request line, headers and body
+// together exceed n bytes with 413 Content Too Large. Zero means no limit,
+// except for HTTP/2 request bodies, which are held to 10 MiB.
+func WithMaxRequestBytes(n int64) Option {
+	return func(s *Server) {
+		s.maxRequestBytes = n
+	}
+}
+
+// WithBaseContext makes every request context derive from ctx, so values
+// stored in it are visible to handlers and cancelling it cancels them all.
+func WithBaseContext(ctx context.Context) Option {
+	return func(s *Server) {
+		s.ctx = ctx
+	}
+}
+
+// WithPanicHandler registers h to be called after a handler panic has been
+// recovered and logged.
+func WithPanicHandler(h PanicHandler) Option {
+	return func(s *Server) {
+		s.panicHandler = h
+	}
+}
+
+type contextKey string
+
+// ServerContextKey holds the *Server that accepted the request.
+const ServerContextKey = contextKey("server")
+
+// RemoteAddrContextKey holds the net.Addr of the client connection.
+const RemoteAddrContextKey = contextKey("remote-addr")
+
 const protocol = "tcp"
 
-func Serve(port int, handler Handler) (*Server, error) {
+const (
+	defaultIdleTimeout       = 2 * time.Minute
+	defaultReadHeaderTimeout = 10 * time.Second
+)
+
+// Serve starts a server for handler listening on all interfaces at port.
+func Serve(port int, handler Handler, opts ...Option) (*Server, error) {
+	server := New(handler, opts...)
 
-	listener, err := net.Listen(protocol, fmt.Sprintf(":%d", port))
+	_, err := server.Listen(fmt.Sprintf(":%d", port))
 	if err != nil {
-		return nil, fmt.Errorf("Error creating listener via %s on %d", protocol, port)
+		return nil, err
 	}
 
+	return server, nil
+}
+
+// New returns a server for handler that isn't listening anywhere yet; add
+// listeners with Listen, ListenTLS or ServeListener.
+func New(handler Handler, opts ...Option) *Server {
 	server := &Server{
-		listener: listener,
-		handler:  handler,
+		ctx:               context.Background(),
+		idleTimeout:       defaultIdleTimeout,
+		readHeaderTimeout: defaultReadHeaderTimeout,
+		overload:          defaultOverloadResponse,
+		overloadWriters:   make(chan struct{}, maxOverloadWriters),
 	}
+	server.handler.Store(&handler)
+	for _, opt := range opts {
+		opt(server)
+	}
+	server.ctx, server.cancel = context.WithCancel(server.ctx)
+
 	// server = running
 	server.closed.Store(false)
 
-	go server.listen()
+	return server
+}
 
-	return server, nil
+// Listen binds addr and serves connections accepted on it, returning the
+// address actually bound, which is how the port chosen for ":0" is found.
+// See ParseAddress for the accepted forms of addr.
+func (s *Server) Listen(addr string) (net.Addr, error) {
+	network, address := ParseAddress(addr)
+
+	listener, err := net.Listen(network, address)
+	if err != nil {
+		return nil, fmt.Errorf("Error creating listener via %s on %s: %w", network, address, err)
+	}
+
+	if err := s.ServeListener(listener); err != nil {
+		return nil, err
+	}
+	return listener.Addr(), nil
+}
+
+// ServeListener serves connections accepted on an existing listener. The
+// server takes ownership of the listener and closes it on Close.
+func (s *Server) ServeListener(listener net.Listener) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed.Load() {
+		listener.Close()
+		return errors.New("Server is closed")
+	}
+	s.listeners = append(s.listeners, listener)
+
+	go s.listen(listener)
+
+	return nil
+}
+
+// SetHandler replaces the handler for all requests that start from now on.
+// Requests already running finish with the previous handler.
+func (s *Server) SetHandler(handler Handler) {
+	s.handler.Store(&handler)
+}
 
+// RemoveListener stops accepting connections on the listener bound to addr.
+// Connections already accepted on it are left to finish.
+func (s *Server) RemoveListener(addr net.Addr) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, listener := range s.listeners {
+		if listener.Addr().String() == addr.String() && listener.Addr().Network() == addr.Network() {
+			s.listeners = slices.Delete(s.listeners, i, i+1)
+			return listener.Close()
+		}
+	}
+	return fmt.Errorf("No listener on %s", addr)
+}
+
+// Addrs returns the addresses of all listeners, in the order they were added.
+func (s *Server) Addrs() []net.Addr {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	addrs := make([]net.Addr, 0, len(s.listeners))
+	for _, listener := range s.listeners {
+		addrs = append(addrs, listener.Addr())
+	}
+	return addrs
+}
+
+// ParseAddress splits addr into a network and an address for net.Listen.
+// "unix:/path/to.sock" is a Unix domain socket, "tcp4:host:port" and
+// "tcp6:host:port" bind IPv4 or IPv6 only, and anything else is TCP.
+func ParseAddress(addr string) (network, address string) {
+	for _, network := range []string{"unix", "tcp4", "tcp6", "tcp"} {
+		if address, ok := strings.CutPrefix(addr, network+":"); ok {
+			if network != "unix" {
+				address = strings.TrimPrefix(address, "//")
+			}
+			return network, address
+		}
+	}
+	return protocol, addr
 }
 
 func (s *Server) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
 	s.closed.Store(true)
-	err := s.listener.Close()
-	if err != nil {
-		return fmt.Errorf("Error closing listener")
+	s.cancel()
+
+	var errs []error
+	for _, listener := range s.listeners {
+		if err := listener.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("Error closing listener %s: %w", listener.Addr(), err))
+		}
 	}
-	return nil
+	return errors.Join(errs...)
 }
 
-func (s *Server) listen() {
+func (s *Server) listen(listener net.Listener) {
+	var delay time.Duration
+
 	for {
-		conn, err := s.listener.Accept()
+		conn, err := listener.Accept()
 		if err != nil {
-			if s.closed.Load() {
+			if s.closed.Load() || errors.Is(err, net.ErrClosed) {
 				return
 			}
-			log.Printf("Error accepting new connection")
+			delay = acceptDelay(delay)
+			log.Printf("Error accepting new connection: %s; retrying in %s", err, delay)
+			time.Sleep(delay)
 			continue
 		}
-		go s.handle(conn)
+		delay = 0
+
+		if !s.limiter.acquire(conn.RemoteAddr()) {
+			s.metrics.connRefused()
+			s.refuse(conn)
+			continue
+		}
+
+		go func() {
+			defer s.limiter.release(conn.RemoteAddr())
+			s.handle(conn)
+		}()
 	}
 }
 
 func (s *Server) handle(conn net.Conn) {
-	defer conn.Close()
+	s.metrics.connOpened()
+	defer s.metrics.connClosed()
+
+	reader := newConnReader(conn, s.metrics)
+	out := &hijackableWriter{
+		countingWriter: countingWriter{w: conn, metrics: s.metrics},
+		conn:           conn,
+		reader:         reader,
+	}
+	defer func() {
+		// A hijacked connection belongs to the handler that took it.
+		if !out.hijacked {
+			conn.Close()
+		}
+	}()
+
+	setReadTimeout(conn, s.readHeaderTimeout)
+	if s.http2 && s.speaksHTTP2(conn, reader) {
+		conn.SetReadDeadline(time.Time{})
+		s.serveHTTP2(conn, reader, out, nil)
+		return
+	}
+
+	for served := 0; ; served++ {
+		if served > 0 {
+			setReadTimeout(conn, s.idleTimeout)
+			if !reader.waitForData() {
+				return
+			}
+			setReadTimeout(conn, s.readHeaderTimeout)
+			s.metrics.connReused()
+		}
+		if !s.serveRequest(conn, reader, out) {
+			return
+		}
+	}
+}
 
-	writer := response.NewWriter(conn)
+// serveRequest reads and answers a single request and reports whether the
+// connection can be reused for another one.
+func (s *Server) serveRequest(conn net.Conn, reader *connReader, out io.Writer) (keepAlive bool) {
+	writer := response.NewWriter(out)
 
-	request, err := request.RequestFromReader(conn)
+	var source io.Reader = &headerReader{r: reader, conn: conn}
+	if s.maxRequestBytes > 0 {
+		source = &limitedReader{r: reader, remaining: s.maxRequestBytes}
+	}
+
+	request, unread, err := request.ReadRequest(source)
 	if err != nil {
-		writer.WriteStatusLine(response.BadRequest)
+		s.metrics.parseError(err)
+		status := response.BadRequest
+		if errors.Is(err, ErrRequestTooLarge) {
+			status = response.ContentTooLarge
+		} else if errors.Is(err, os.ErrDeadlineExceeded) {
+			status = response.RequestTimeout
+		}
+		writer.WriteStatusLine(status)
 		body := []byte(err.Error())
 		writer.WriteHeaders(response.GetDefaultHeaders(len(body)))
 		writer.WriteBody(body)
+		return false
+	}
+	reader.unread(unread)
+
+	ctx, cancel := s.requestContext(conn)
+	defer cancel()
+
+	request = request.WithContext(ctx)
+
+	if s.http2 && http2.IsUpgrade(request) {
+		s.upgradeHTTP2(conn, reader, writer, request)
+		return false
+	}
+
+	reader.startBackgroundRead(cancel)
+	defer reader.abortPendingRead()
+
+	if panicked := s.runHandler(conn, writer, request, s.serveHandler); panicked {
+		return false
+	}
+
+	return writer.Complete() && !writer.CloseRequested() && !wantsClose(request) && !s.closed.Load()
+}
+
+// runHandler calls serve for a request, recording it in the metrics and
+// recovering a panic. It reports whether serve panicked.
+func (s *Server) runHandler(conn net.Conn, writer *response.Writer, request *request.Request, serve Handler) (panicked bool) {
+	start := time.Now()
+	defer func() {
+		s.metrics.observeRequest(request.RequestLine.Method, s.route(request), writer.StatusCode(), time.Since(start))
+	}()
+
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			s.handlePanic(conn, writer, request, recovered)
+			panicked = true
+		}
+	}()
+
+	serve(writer, request)
+	return false
+}
+
+// serveHandler answers the metrics endpoint when enabled and passes every
+// other request to the current handler. HEAD requests are served like GET
+// ones with the body left out, so handlers needn't tell them apart.
+func (s *Server) serveHandler(w *response.Writer, req *request.Request) {
+	if req.RequestLine.Method == "HEAD" {
+		w.DiscardBody()
+	}
+	if s.metrics != nil && (req.RequestLine.Method == "GET" || req.RequestLine.Method == "HEAD") && requestPath(req) == s.metrics.path {
+		s.metrics.serve(w)
+		return
+	}
+	(*s.handler.Load())(w, req)
+}
+
+func wantsClose(req *request.Request) bool {
+	connection, ok := req.Headers.Get("Connection")
+	return ok && strings.Contains(strings.ToLower(connection), "close")
+}
+
+func (s *Server) route(req *request.Request) string {
+	if s.metricsRoute != nil {
+		return s.metricsRoute(req)
+	}
+	return defaultRoute
+}
+
+// handlePanic keeps a panicking handler from taking down the process. The
+// client gets a 500 if nothing has been written yet; otherwise the response is
+// already inconsistent and the connection is reset instead.
+func (s *Server) handlePanic(conn net.Conn, w *response.Writer, req *request.Request, recovered any) {
+	stack := debug.Stack()
+	log.Printf("panic serving %s %s for %s: %v\n%s",
+		req.RequestLine.Method, req.RequestLine.RequestTarget, req.Context().Value(RemoteAddrContextKey), recovered, stack)
+
+	if s.panicHandler != nil {
+		s.panicHandler(req, recovered, stack)
+	}
+
+	if w.Hijacked() {
+		return
+	}
+	if w.HeadersSent() {
+		// HTTP/2 streams come without a conn, since theirs is shared with
+		// other requests; the HTTP/2 server resets the stream instead.
+		if conn != nil {
+			resetConn(conn)
+		}
 		return
 	}
-	s.handler(writer, request)
+
+	body := []byte("Internal Server Error")
+	if err := w.WriteStatusLine(response.InternalServerError); err != nil {
+		return
+	}
+	if err := w.WriteHeaders(response.GetDefaultHeaders(len(body))); err != nil {
+		return
+	}
+	w.WriteBody(body)
+}
+
+// resetConn resets conn, so the client can tell a cut-off response from a
+// complete one. A TLS connection is reset through the connection it wraps,
+// as closing it normally would send a close_notify that ends the response
+// cleanly.
+func resetConn(conn net.Conn) {
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		conn = tlsConn.NetConn()
+	}
+	if lingerer, ok := conn.(interface{ SetLinger(sec int) error }); ok {
+		lingerer.SetLinger(0)
+	}
+	conn.Close()
+}
+
+func (s *Server) requestContext(conn net.Conn) (context.Context, context.CancelFunc) {
+	return s.withRequestTimeout(s.connContext(conn))
+}
+
+// connContext is the context everything served on conn derives from.
+func (s *Server) connContext(conn net.Conn) context.Context {
+	ctx := context.WithValue(s.ctx, ServerContextKey, s)
+	return context.WithValue(ctx, RemoteAddrContextKey, conn.RemoteAddr())
+}
+
+func (s *Server) withRequestTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if s.requestTimeout > 0 {
+		return context.WithTimeout(ctx, s.requestTimeout)
+	}
+	return context.WithCancel(ctx)
 }
@@ -0,0 +1,443 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"httpfromtcp/internal/cookie"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"io"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testPort = 42169
+
+func dial(t *testing.T, port int) net.Conn {
+	conn, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+	require.NoError(t, err)
+	return conn
+}
+
+func TestRequestContext(t *testing.T) {
+
+	// Test: Context cancelled on client disconnect
+	done := make(chan error, 1)
+	s, err := Serve(testPort, func(w *response.Writer, req *request.Request) {
+		<-req.Context().Done()
+		done <- req.Context().Err()
+	})
+	require.NoError(t, err)
+
+	conn := dial(t, testPort)
+	_, err = conn.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	conn.Close()
+
+	select {
+	case err := <-done:
+		assert.ErrorIs(t, err, context.Canceled)
+	case <-time.After(2 * time.Second):
+		t.Fatal("context was not cancelled on disconnect")
+	}
+	s.Close()
+
+	// Test: Context cancelled on timeout and carries base values
+	type key string
+	base := context.WithValue(context.Background(), key("app"), "httpfromtcp")
+	values := make(chan any, 1)
+	s, err = Serve(testPort+1, func(w *response.Writer, req *request.Request) {
+		values <- req.Context().Value(key("app"))
+		<-req.Context().Done()
+		done <- req.Context().Err()
+	}, WithRequestTimeout(50*time.Millisecond), WithBaseContext(base))
+	require.NoError(t, err)
+
+	conn = dial(t, testPort+1)
+	defer conn.Close()
+	_, err = conn.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+
+	assert.Equal(t, "httpfromtcp", <-values)
+	select {
+	case err := <-done:
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+	case <-time.After(2 * time.Second):
+		t.Fatal("context was not cancelled on timeout")
+	}
+	s.Close()
+
+	// Test: Context cancelled on server close
+	started := make(chan struct{})
+	s, err = Serve(testPort+2, func(w *response.Writer, req *request.Request) {
+		close(started)
+		<-req.Context().Done()
+		done <- req.Context().Err()
+	})
+	require.NoError(t, err)
+
+	conn = dial(t, testPort+2)
+	defer conn.Close()
+	_, err = conn.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+
+	<-started
+	s.Close()
+	select {
+	case err := <-done:
+		assert.ErrorIs(t, err, context.Canceled)
+	case <-time.After(2 * time.Second):
+		t.Fatal("context was not cancelled on server close")
+	}
+}
+
+func TestPanicRecovery(t *testing.T) {
+
+	// Test: Panic before headers answers 500 and reaches the hook
+	reported := make(chan any, 1)
+	s, err := Serve(testPort+3, func(w *response.Writer, req *request.Request) {
+		panic("boom")
+	}, WithPanicHandler(func(req *request.Request, recovered any, stack []byte) {
+		reported <- recovered
+	}))
+	require.NoError(t, err)
+	defer s.Close()
+
+	conn := dial(t, testPort+3)
+	defer conn.Close()
+	_, err = conn.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+
+	res, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(res), "HTTP/1.1 500 Internal Server Error\r\n"))
+	assert.Equal(t, "boom", <-reported)
+
+	// Test: Panic after headers does not send a second status line
+	s2, err := Serve(testPort+4, func(w *response.Writer, req *request.Request) {
+		w.WriteStatusLine(response.OK)
+		panic("boom")
+	})
+	require.NoError(t, err)
+	defer s2.Close()
+
+	conn2 := dial(t, testPort+4)
+	defer conn2.Close()
+	_, err = conn2.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+
+	res, _ = io.ReadAll(conn2)
+	assert.NotContains(t, string(res), "500")
+}
+
+func TestMetrics(t *testing.T) {
+	s, err := Serve(testPort+5, func(w *response.Writer, req *request.Request) {
+		body := []byte("hello")
+		headers := response.GetDefaultHeaders(len(body))
+		headers.Delete("connection")
+		w.WriteStatusLine(response.OK)
+		w.WriteHeaders(headers)
+		w.WriteBody(body)
+	}, WithMetrics("/metrics"))
+	require.NoError(t, err)
+	defer s.Close()
+
+	// Test: Two requests on one kept-alive connection
+	conn := dial(t, testPort+5)
+	reader := bufio.NewReader(conn)
+	for range 2 {
+		_, err = conn.Write([]byte("GET /coffee?size=large HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+		require.NoError(t, err)
+
+		status, err := reader.ReadString('\n')
+		require.NoError(t, err)
+		assert.Equal(t, "HTTP/1.1 200 OK\r\n", status)
+		for {
+			line, err := reader.ReadString('\n')
+			require.NoError(t, err)
+			if line == "\r\n" {
+				break
+			}
+		}
+		body := make([]byte, 5)
+		_, err = io.ReadFull(reader, body)
+		require.NoError(t, err)
+		assert.Equal(t, "hello", string(body))
+	}
+	conn.Close()
+
+	// Test: Parse error is counted by kind
+	conn = dial(t, testPort+5)
+	_, err = conn.Write([]byte("GET /coffee HTTP/2.0\r\n\r\n"))
+	require.NoError(t, err)
+	io.ReadAll(conn)
+	conn.Close()
+
+	// Test: A missing Host header is counted as its own kind
+	conn = dial(t, testPort+5)
+	_, err = conn.Write([]byte("GET /coffee HTTP/1.1\r\n\r\n"))
+	require.NoError(t, err)
+	io.ReadAll(conn)
+	conn.Close()
+
+	// Test: Scrape
+	conn = dial(t, testPort+5)
+	defer conn.Close()
+	_, err = conn.Write([]byte("GET /metrics HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	res, err := io.ReadAll(conn)
+	require.NoError(t, err)
+
+	scrape := string(res)
+	assert.Contains(t, scrape, "content-type: text/plain; version=0.0.4\r\n")
+	assert.Contains(t, scrape, `httpfromtcp_requests_total{method="GET",route="other",status="200"} 2`)
+	assert.Contains(t, scrape, `httpfromtcp_request_duration_seconds_count{method="GET",route="other"} 2`)
+	assert.Contains(t, scrape, `httpfromtcp_request_duration_seconds_bucket{method="GET",route="other",le="+Inf"} 2`)
+	assert.NotContains(t, scrape, "/coffee")
+	assert.Contains(t, scrape, `httpfromtcp_parse_errors_total{kind="version"} 1`)
+	assert.Contains(t, scrape, `httpfromtcp_parse_errors_total{kind="host"} 1`)
+	assert.Contains(t, scrape, "httpfromtcp_keepalive_reused_total 1\n")
+	assert.Regexp(t, `httpfromtcp_active_connections [1-9]\d*\n`, scrape)
+	assert.Regexp(t, `httpfromtcp_received_bytes_total [1-9]\d*\n`, scrape)
+	assert.Regexp(t, `httpfromtcp_sent_bytes_total [1-9]\d*\n`, scrape)
+}
+
+func okHandler(w *response.Writer, _ *request.Request) {
+	body := []byte("hello")
+	w.WriteStatusLine(response.OK)
+	w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+	w.WriteBody(body)
+}
+
+func get(t *testing.T, network, address string) string {
+	conn, err := net.Dial(network, address)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	_, err = conn.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+
+	res, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	return string(res)
+}
+
+func TestListeners(t *testing.T) {
+	s := New(okHandler)
+	defer s.Close()
+
+	// Test: Port 0 reports the chosen address
+	tcpAddr, err := s.Listen("127.0.0.1:0")
+	require.NoError(t, err)
+	assert.NotEqual(t, 0, tcpAddr.(*net.TCPAddr).Port)
+	assert.True(t, strings.HasSuffix(get(t, "tcp", tcpAddr.String()), "hello"))
+
+	// Test: IPv4-only bind
+	tcp4Addr, err := s.Listen("tcp4:127.0.0.1:0")
+	require.NoError(t, err)
+	assert.NotNil(t, tcp4Addr.(*net.TCPAddr).IP.To4())
+	assert.True(t, strings.HasSuffix(get(t, "tcp4", tcp4Addr.String()), "hello"))
+
+	// Test: Unix domain socket
+	socket := filepath.Join(t.TempDir(), "server.sock")
+	unixAddr, err := s.Listen("unix:" + socket)
+	require.NoError(t, err)
+	assert.Equal(t, "unix", unixAddr.Network())
+	assert.True(t, strings.HasSuffix(get(t, "unix", socket), "hello"))
+
+	// Test: Injected listener
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	require.NoError(t, s.ServeListener(listener))
+	assert.True(t, strings.HasSuffix(get(t, "tcp", listener.Addr().String()), "hello"))
+
+	// Test: All listeners are reported and closed together
+	assert.Equal(t, []net.Addr{tcpAddr, tcp4Addr, unixAddr, listener.Addr()}, s.Addrs())
+	require.NoError(t, s.Close())
+	_, err = net.Dial("tcp", tcpAddr.String())
+	assert.Error(t, err)
+	_, err = s.Listen("127.0.0.1:0")
+	assert.Error(t, err)
+
+	// Test: Address parsing
+	network, address := ParseAddress("tcp6:[::1]:8080")
+	assert.Equal(t, "tcp6", network)
+	assert.Equal(t, "[::1]:8080", address)
+	network, address = ParseAddress("localhost:42069")
+	assert.Equal(t, "tcp", network)
+	assert.Equal(t, "localhost:42069", address)
+}
+
+func TestHijack(t *testing.T) {
+	hijacked := make(chan error, 1)
+	s := New(func(w *response.Writer, req *request.Request) {
+		if req.RequestLine.RequestTarget != "/raw" {
+			headers := response.GetDefaultHeaders(2)
+			headers.Update("connection", "keep-alive")
+			w.WriteStatusLine(response.OK)
+			w.WriteHeaders(headers)
+			w.WriteBody([]byte("ok"))
+			return
+		}
+
+		conn, buffered, err := w.Hijack()
+		if err != nil {
+			hijacked <- err
+			return
+		}
+		_, _, err = w.Hijack()
+		hijacked <- err
+
+		// Echo lines until the client hangs up, long after the handler returned.
+		go func() {
+			defer conn.Close()
+			lines := bufio.NewScanner(io.MultiReader(strings.NewReader(string(buffered)), conn))
+			for lines.Scan() {
+				fmt.Fprintf(conn, "echo %s\n", lines.Text())
+			}
+		}()
+	})
+	defer s.Close()
+	addr, err := s.Listen("127.0.0.1:0")
+	require.NoError(t, err)
+
+	// Test: Handler takes over the connection, bytes after the request included
+	conn, err := net.Dial("tcp", addr.String())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("GET /raw HTTP/1.1\r\nHost: localhost\r\n\r\nfirst\n"))
+	require.NoError(t, err)
+	assert.ErrorIs(t, <-hijacked, response.ErrHijacked)
+
+	lines := bufio.NewReader(conn)
+	line, err := lines.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "echo first\n", line)
+
+	_, err = conn.Write([]byte("second\n"))
+	require.NoError(t, err)
+	line, err = lines.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "echo second\n", line)
+
+	// Test: Writers that aren't backed by a connection can't be hijacked
+	_, _, err = response.NewWriter(io.Discard).Hijack()
+	assert.ErrorIs(t, err, response.ErrNotHijackable)
+
+	// Test: Pipelined requests sent in one write are all answered
+	conn2, err := net.Dial("tcp", addr.String())
+	require.NoError(t, err)
+	defer conn2.Close()
+	_, err = conn2.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n" +
+		"GET / HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n"))
+	require.NoError(t, err)
+	res, err := io.ReadAll(conn2)
+	require.NoError(t, err)
+	assert.Equal(t, 2, strings.Count(string(res), "HTTP/1.1 200 OK\r\n"))
+}
+
+func TestSetCookie(t *testing.T) {
+	s := New(func(w *response.Writer, req *request.Request) {
+		w.SetCookie(&cookie.Cookie{Name: "a", Value: "1", Expires: time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)})
+		w.SetCookie(&cookie.Cookie{Name: "b", Value: "2", HttpOnly: true})
+		okHandler(w, req)
+	}, WithHTTP2())
+	defer s.Close()
+	addr, err := s.Listen("127.0.0.1:0")
+	require.NoError(t, err)
+
+	var protocols http.Protocols
+	protocols.SetHTTP1(true)
+	protocols.SetUnencryptedHTTP2(true)
+	for _, major := range []int{1, 2} {
+		if major == 2 {
+			protocols.SetHTTP1(false)
+		}
+		transport := &http.Transport{Protocols: &protocols}
+		req, err := http.NewRequest("GET", "http://"+addr.String()+"/", nil)
+		require.NoError(t, err)
+		res, err := transport.RoundTrip(req)
+		require.NoError(t, err)
+		res.Body.Close()
+		transport.CloseIdleConnections()
+
+		// Test: Every cookie gets its own Set-Cookie line
+		assert.Equal(t, major, res.ProtoMajor)
+		assert.ElementsMatch(t, []string{
+			"a=1; Expires=Tue, 01 Jan 2030 00:00:00 GMT",
+			"b=2; HttpOnly",
+		}, res.Header.Values("Set-Cookie"))
+	}
+}
+
+func TestHead(t *testing.T) {
+	s := New(func(w *response.Writer, req *request.Request) {
+		w.WriteStatusLine(response.OK)
+		h := response.GetDefaultHeaders(0)
+		h.Delete("connection")
+		if req.RequestLine.RequestTarget == "/chunked" {
+			h.Delete("content-length")
+			h.Set("Transfer-Encoding", "chunked")
+			w.WriteHeaders(h)
+			w.WriteChunkedBody([]byte("hello"))
+			w.WriteChunkedBodyDone()
+			w.WriteTrailers(response.GetTrailersFromHeader(h))
+			return
+		}
+		h.Update("content-length", "11")
+		w.WriteHeaders(h)
+		w.WriteBody([]byte("hello world"))
+	}, WithHTTP2())
+	defer s.Close()
+	addr, err := s.Listen("127.0.0.1:0")
+	require.NoError(t, err)
+
+	// Test: HEAD gets the GET headers without the body and the connection stays usable
+	conn, err := net.Dial("tcp", addr.String())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("HEAD / HTTP/1.1\r\nHost: localhost\r\n\r\n" +
+		"HEAD /chunked HTTP/1.1\r\nHost: localhost\r\n\r\n" +
+		"GET / HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n"))
+	require.NoError(t, err)
+	reader := bufio.NewReader(conn)
+
+	head, err := http.ReadResponse(reader, &http.Request{Method: "HEAD"})
+	require.NoError(t, err)
+	assert.Equal(t, int64(11), head.ContentLength)
+	head, err = http.ReadResponse(reader, &http.Request{Method: "HEAD"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"chunked"}, head.TransferEncoding)
+	res, err := http.ReadResponse(reader, &http.Request{Method: "GET"})
+	require.NoError(t, err)
+	body, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "hello world", string(body))
+	rest, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	assert.Empty(t, rest)
+
+	// Test: HEAD over HTTP/2 keeps Content-Length and sends no data
+	var protocols http.Protocols
+	protocols.SetUnencryptedHTTP2(true)
+	transport := &http.Transport{Protocols: &protocols}
+	defer transport.CloseIdleConnections()
+	req, err := http.NewRequest("HEAD", "http://"+addr.String()+"/", nil)
+	require.NoError(t, err)
+	res, err = transport.RoundTrip(req)
+	require.NoError(t, err)
+	defer res.Body.Close()
+	assert.Equal(t, 2, res.ProtoMajor)
+	assert.Equal(t, "11", res.Header.Get("Content-Length"))
+	body, err = io.ReadAll(res.Body)
+	require.NoError(t, err)
+	assert.Empty(t, body)
+}
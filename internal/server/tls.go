@@ -0,0 +1,199 @@
+package server
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"slices"
+	"sync"
+	"time"
+)
+
+// certCheckInterval limits how often certificate files are checked for changes.
+var certCheckInterval = time.Second
+
+// KeyPair names a PEM encoded certificate and its private key on disk.
+type KeyPair struct {
+	CertFile string
+	KeyFile  string
+}
+
+// ServeTLS is like Serve but speaks TLS, using the key pairs as described in
+// NewTLSConfig.
+func ServeTLS(port int, handler Handler, pairs []KeyPair, opts ...Option) (*Server, error) {
+	config, err := NewTLSConfig(pairs)
+	if err != nil {
+		return nil, err
+	}
+
+	return ServeTLSConfig(port, handler, config, opts...)
+}
+
+// NewTLSConfig returns a TLS config serving the given key pairs. When more
+// than one pair is given the certificate is chosen by the SNI server name, and
+// the files are reloaded whenever they change on disk.
+func NewTLSConfig(pairs []KeyPair) (*tls.Config, error) {
+	if len(pairs) == 0 {
+		return nil, errors.New("TLS needs at least one key pair")
+	}
+
+	reloaders := make([]*CertReloader, 0, len(pairs))
+	for _, pair := range pairs {
+		reloader, err := NewCertReloader(pair.CertFile, pair.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		reloaders = append(reloaders, reloader)
+	}
+
+	return &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: selectCertificate(reloaders),
+	}, nil
+}
+
+// ServeTLSConfig is like Serve but speaks TLS using config, which must provide
+// certificates through Certificates or GetCertificate.
+func ServeTLSConfig(port int, handler Handler, config *tls.Config, opts ...Option) (*Server, error) {
+	server := New(handler, opts...)
+
+	_, err := server.ListenTLS(fmt.Sprintf(":%d", port), config)
+	if err != nil {
+		return nil, err
+	}
+
+	return server, nil
+}
+
+// ListenTLS is like Listen but speaks TLS on the new listener using config.
+// With WithHTTP2, h2 is offered through ALPN ahead of http/1.1.
+func (s *Server) ListenTLS(addr string, config *tls.Config) (net.Addr, error) {
+	config = config.Clone()
+	if s.http2 && !slices.Contains(config.NextProtos, "h2") {
+		config.NextProtos = append([]string{"h2"}, config.NextProtos...)
+	}
+	if !slices.Contains(config.NextProtos, "http/1.1") {
+		config.NextProtos = append(config.NextProtos, "http/1.1")
+	}
+
+	network, address := ParseAddress(addr)
+
+	listener, err := net.Listen(network, address)
+	if err != nil {
+		return nil, fmt.Errorf("Error creating listener via %s on %s: %w", network, address, err)
+	}
+
+	if err := s.ServeListener(tls.NewListener(listener, config)); err != nil {
+		return nil, err
+	}
+	return listener.Addr(), nil
+}
+
+// selectCertificate picks the first certificate valid for the client's SNI
+// server name, falling back to the first one.
+func selectCertificate(reloaders []*CertReloader) func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+		var fallback *tls.Certificate
+
+		for _, reloader := range reloaders {
+			cert, err := reloader.Certificate()
+			if err != nil {
+				return nil, err
+			}
+			if fallback == nil {
+				fallback = cert
+			}
+			if hello.ServerName != "" && hello.SupportsCertificate(cert) == nil {
+				return cert, nil
+			}
+		}
+		return fallback, nil
+	}
+}
+
+// CertReloader serves a certificate loaded from disk and loads it again when
+// either file's modification time changes.
+type CertReloader struct {
+	certFile string
+	keyFile  string
+
+	mu        sync.Mutex
+	cert      *tls.Certificate
+	modTime   time.Time
+	checkedAt time.Time
+}
+
+func NewCertReloader(certFile, keyFile string) (*CertReloader, error) {
+	reloader := &CertReloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+	}
+	if err := reloader.load(); err != nil {
+		return nil, err
+	}
+	return reloader, nil
+}
+
+// Certificate returns the current certificate. If the files changed but can't
+// be loaded, e.g. because only one of them has been written yet, the previous
+// certificate keeps being served.
+func (c *CertReloader) Certificate() (*tls.Certificate, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if time.Since(c.checkedAt) < certCheckInterval {
+		return c.cert, nil
+	}
+	c.checkedAt = time.Now()
+
+	modTime, err := c.latestModTime()
+	if err != nil || !modTime.After(c.modTime) {
+		return c.cert, nil
+	}
+	c.loadLocked()
+
+	return c.cert, nil
+}
+
+func (c *CertReloader) load() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.loadLocked()
+}
+
+func (c *CertReloader) loadLocked() error {
+	modTime, err := c.latestModTime()
+	if err != nil {
+		return err
+	}
+
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return fmt.Errorf("Error loading key pair %s, %s: %w", c.certFile, c.keyFile, err)
+	}
+
+	c.cert = &cert
+	c.modTime = modTime
+	c.checkedAt = time.Now()
+
+	return nil
+}
+
+func (c *CertReloader) latestModTime() (time.Time, error) {
+	certInfo, err := os.Stat(c.certFile)
+	if err != nil {
+		return time.Time{}, err
+	}
+	keyInfo, err := os.Stat(c.keyFile)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	if keyInfo.ModTime().After(certInfo.ModTime()) {
+		return keyInfo.ModTime(), nil
+	}
+	return certInfo.ModTime(), nil
+}
@@ -0,0 +1,207 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"httpfromtcp/internal/headers"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptrace"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeSelfSigned writes a self-signed certificate for hosts and its key to dir.
+func writeSelfSigned(t *testing.T, dir, name string, hosts ...string) KeyPair {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: hosts[0]},
+		DNSNames:     hosts,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	pair := KeyPair{
+		CertFile: filepath.Join(dir, name+".crt"),
+		KeyFile:  filepath.Join(dir, name+".key"),
+	}
+	require.NoError(t, os.WriteFile(pair.CertFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600))
+	require.NoError(t, os.WriteFile(pair.KeyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0o600))
+
+	return pair
+}
+
+func tlsGet(t *testing.T, port int, serverName string) (*tls.ConnectionState, string) {
+	conn, err := tls.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", port), &tls.Config{
+		ServerName:         serverName,
+		InsecureSkipVerify: true,
+		NextProtos:         []string{"http/1.1"},
+	})
+	require.NoError(t, err)
+	defer conn.Close()
+
+	_, err = conn.Write([]byte("GET / HTTP/1.1\r\nHost: " + serverName + "\r\n\r\n"))
+	require.NoError(t, err)
+
+	res, err := io.ReadAll(conn)
+	require.NoError(t, err)
+
+	state := conn.ConnectionState()
+	return &state, string(res)
+}
+
+func TestServeTLS(t *testing.T) {
+	dir := t.TempDir()
+	first := writeSelfSigned(t, dir, "first", "first.example")
+	second := writeSelfSigned(t, dir, "second", "second.example")
+
+	s, err := ServeTLS(testPort+6, func(w *response.Writer, req *request.Request) {
+		body := []byte("secure")
+		w.WriteStatusLine(response.OK)
+		w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+		w.WriteBody(body)
+	}, []KeyPair{first, second})
+	require.NoError(t, err)
+	defer s.Close()
+
+	// Test: Request over TLS with ALPN http/1.1
+	state, res := tlsGet(t, testPort+6, "first.example")
+	assert.Equal(t, "http/1.1", state.NegotiatedProtocol)
+	assert.True(t, strings.HasPrefix(res, "HTTP/1.1 200 OK\r\n"))
+	assert.True(t, strings.HasSuffix(res, "secure"))
+	assert.Equal(t, []string{"first.example"}, state.PeerCertificates[0].DNSNames)
+
+	// Test: SNI selects the matching certificate
+	state, _ = tlsGet(t, testPort+6, "second.example")
+	assert.Equal(t, []string{"second.example"}, state.PeerCertificates[0].DNSNames)
+
+	// Test: Unknown server name falls back to the first certificate
+	state, _ = tlsGet(t, testPort+6, "unknown.example")
+	assert.Equal(t, []string{"first.example"}, state.PeerCertificates[0].DNSNames)
+
+	// Test: Certificate is reloaded when the files change
+	certCheckInterval = 0
+	defer func() { certCheckInterval = time.Second }()
+
+	time.Sleep(10 * time.Millisecond)
+	writeSelfSigned(t, dir, "first", "first.example", "renewed.example")
+	future := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(first.CertFile, future, future))
+
+	state, _ = tlsGet(t, testPort+6, "first.example")
+	assert.Equal(t, []string{"first.example", "renewed.example"}, state.PeerCertificates[0].DNSNames)
+}
+
+func TestServeTLSHTTP2(t *testing.T) {
+	pair := writeSelfSigned(t, t.TempDir(), "h2", "h2.example")
+	config, err := NewTLSConfig([]KeyPair{pair})
+	require.NoError(t, err)
+
+	s := New(func(w *response.Writer, req *request.Request) {
+		hints := headers.NewHeaders()
+		hints.Set("Link", "</style.css>; rel=preload; as=style")
+		require.NoError(t, w.WriteEarlyHints(hints))
+
+		body := []byte(req.RequestLine.HttpVersion)
+		w.WriteStatusLine(response.OK)
+		w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+		w.WriteBody(body)
+	}, WithHTTP2())
+	defer s.Close()
+	addr, err := s.ListenTLS("127.0.0.1:0", config)
+	require.NoError(t, err)
+
+	fetch := func(protocols *http.Protocols) (string, []string) {
+		transport := &http.Transport{
+			TLSClientConfig: &tls.Config{ServerName: "h2.example", InsecureSkipVerify: true},
+			Protocols:       protocols,
+		}
+		defer transport.CloseIdleConnections()
+
+		var links []string
+		trace := &httptrace.ClientTrace{
+			Got1xxResponse: func(code int, header textproto.MIMEHeader) error {
+				if code == http.StatusEarlyHints {
+					links = append(links, header.Get("Link"))
+				}
+				return nil
+			},
+		}
+		req, err := http.NewRequestWithContext(httptrace.WithClientTrace(t.Context(), trace), "GET", "https://"+addr.String()+"/", nil)
+		require.NoError(t, err)
+		res, err := transport.RoundTrip(req)
+		require.NoError(t, err)
+		defer res.Body.Close()
+		body, err := io.ReadAll(res.Body)
+		require.NoError(t, err)
+		return string(body), links
+	}
+
+	// Test: ALPN h2 selects HTTP/2
+	var protocols http.Protocols
+	protocols.SetHTTP2(true)
+	body, links := fetch(&protocols)
+	assert.Equal(t, "2", body)
+	assert.Equal(t, []string{"</style.css>; rel=preload; as=style"}, links)
+
+	// Test: Clients offering only http/1.1 get HTTP/1.1 from the same handler
+	protocols = http.Protocols{}
+	protocols.SetHTTP1(true)
+	body, links = fetch(&protocols)
+	assert.Equal(t, "1.1", body)
+	assert.Equal(t, []string{"</style.css>; rel=preload; as=style"}, links)
+}
+
+func TestServeTLSPanic(t *testing.T) {
+	pair := writeSelfSigned(t, t.TempDir(), "panic", "panic.example")
+	config, err := NewTLSConfig([]KeyPair{pair})
+	require.NoError(t, err)
+
+	s := New(func(w *response.Writer, req *request.Request) {
+		w.WriteStatusLine(response.OK)
+		panic("boom")
+	})
+	defer s.Close()
+	addr, err := s.ListenTLS("127.0.0.1:0", config)
+	require.NoError(t, err)
+
+	// Test: Panic after headers resets the connection under TLS
+	conn, err := tls.Dial("tcp", addr.String(), &tls.Config{InsecureSkipVerify: true})
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("GET / HTTP/1.1\r\nHost: panic.example\r\n\r\n"))
+	require.NoError(t, err)
+
+	_, err = io.ReadAll(conn)
+	assert.ErrorIs(t, err, syscall.ECONNRESET)
+}
@@ -0,0 +1,121 @@
+package server
+
+import (
+	"fmt"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"log"
+	"net"
+	"sort"
+	"strings"
+)
+
+// HostMux dispatches requests to a handler by the host name in their Host
+// header, so one server can serve several sites. Patterns are exact names
+// such as "example.com" or wildcards such as "*.example.com", which match
+// subdomains at any depth but not example.com itself. Exact names win over
+// wildcards, and longer wildcards over shorter ones. Names are matched
+// without the port and case-insensitively.
+//
+// A HostMux is set up before the server starts and not changed after.
+type HostMux struct {
+	exact    map[string]Handler
+	wildcard []wildcardHost
+	fallback Handler
+}
+
+type wildcardHost struct {
+	// suffix is the pattern without its "*", e.g. ".example.com".
+	suffix  string
+	handler Handler
+}
+
+func NewHostMux() *HostMux {
+	return &HostMux{exact: map[string]Handler{}}
+}
+
+// Handle routes requests for the hosts matching pattern to handler.
+func (m *HostMux) Handle(pattern string, handler Handler) error {
+	host := normalizeHost(pattern)
+	wildcard := strings.HasPrefix(host, "*.")
+	name := strings.TrimPrefix(host, "*.")
+	if name == "" || strings.ContainsAny(name, "*/:") && !strings.HasPrefix(name, "[") {
+		return fmt.Errorf("Invalid host pattern %q", pattern)
+	}
+
+	if !wildcard {
+		if _, ok := m.exact[host]; ok {
+			return fmt.Errorf("Host %q is already handled", pattern)
+		}
+		m.exact[host] = handler
+		return nil
+	}
+
+	suffix := host[1:]
+	for _, w := range m.wildcard {
+		if w.suffix == suffix {
+			return fmt.Errorf("Host %q is already handled", pattern)
+		}
+	}
+	m.wildcard = append(m.wildcard, wildcardHost{suffix: suffix, handler: handler})
+	sort.SliceStable(m.wildcard, func(i, j int) bool {
+		return len(m.wildcard[i].suffix) > len(m.wildcard[j].suffix)
+	})
+	return nil
+}
+
+// HandleDefault routes requests whose host matches no pattern, or that have
+// none, to handler. Without a default they get 404 Not Found.
+func (m *HostMux) HandleDefault(handler Handler) {
+	m.fallback = handler
+}
+
+// Serve is the mux's Handler.
+func (m *HostMux) Serve(w *response.Writer, req *request.Request) {
+	if handler := m.match(normalizeHost(hostname(req.Host()))); handler != nil {
+		handler(w, req)
+		return
+	}
+
+	body := []byte("Unknown host")
+	if err := w.WriteStatusLine(response.NotFound); err != nil {
+		log.Printf("error sending response status line: %s", err)
+		return
+	}
+	if err := w.WriteHeaders(response.GetDefaultHeaders(len(body))); err != nil {
+		log.Printf("error sending headers: %s", err)
+		return
+	}
+	w.WriteBody(body)
+}
+
+func (m *HostMux) match(host string) Handler {
+	if host != "" {
+		if handler, ok := m.exact[host]; ok {
+			return handler
+		}
+		for _, w := range m.wildcard {
+			if len(host) > len(w.suffix) && strings.HasSuffix(host, w.suffix) {
+				return w.handler
+			}
+		}
+	}
+	return m.fallback
+}
+
+// hostname strips the port from a Host header value.
+func hostname(host string) string {
+	if name, _, err := net.SplitHostPort(host); err == nil {
+		if strings.Contains(name, ":") {
+			return "[" + name + "]"
+		}
+		return name
+	}
+	return host
+}
+
+// normalizeHost lowercases a host name and drops the trailing dot of a fully
+// qualified one.
+func normalizeHost(host string) string {
+	return strings.TrimSuffix(strings.ToLower(host), ".")
+}
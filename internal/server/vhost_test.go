@@ -0,0 +1,72 @@
+package server
+
+import (
+	"bytes"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func named(name string) Handler {
+	return func(w *response.Writer, _ *request.Request) {
+		body := []byte(name)
+		w.WriteStatusLine(response.OK)
+		w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+		w.WriteBody(body)
+	}
+}
+
+func serveHost(t *testing.T, mux *HostMux, host string) string {
+	req, err := request.RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\nHost: " + host + "\r\n\r\n"))
+	require.NoError(t, err)
+	out := &bytes.Buffer{}
+	mux.Serve(response.NewWriter(out), req)
+	return out.String()
+}
+
+func TestHostMux(t *testing.T) {
+	mux := NewHostMux()
+	require.NoError(t, mux.Handle("example.com", named("apex")))
+	require.NoError(t, mux.Handle("api.example.com", named("api")))
+	require.NoError(t, mux.Handle("*.example.com", named("sites")))
+	require.NoError(t, mux.Handle("*.eu.example.com", named("eu")))
+	require.NoError(t, mux.Handle("[::1]", named("ipv6")))
+
+	// Test: Exact names win over wildcards, longer wildcards over shorter ones
+	for host, want := range map[string]string{
+		"example.com":         "apex",
+		"API.Example.com:443": "api",
+		"example.com.":        "apex",
+		"blog.example.com":    "sites",
+		"a.b.example.com":     "sites",
+		"shop.eu.example.com": "eu",
+		"[::1]:8080":          "ipv6",
+	} {
+		res := serveHost(t, mux, host)
+		assert.True(t, strings.HasSuffix(res, "\r\n\r\n"+want), "%s: %s", host, res)
+	}
+
+	// Test: Unknown hosts get 404 without a default
+	res := serveHost(t, mux, "other.org")
+	assert.True(t, strings.HasPrefix(res, "HTTP/1.1 404 Not Found\r\n"))
+	res = serveHost(t, mux, "badexample.com")
+	assert.True(t, strings.HasPrefix(res, "HTTP/1.1 404 Not Found\r\n"))
+
+	// Test: The default handler takes unknown hosts
+	mux.HandleDefault(named("default"))
+	res = serveHost(t, mux, "other.org")
+	assert.True(t, strings.HasSuffix(res, "default"))
+	res = serveHost(t, mux, "")
+	assert.True(t, strings.HasSuffix(res, "default"))
+
+	// Test: Duplicate and malformed patterns are refused
+	assert.Error(t, mux.Handle("EXAMPLE.com", named("again")))
+	assert.Error(t, mux.Handle("*.example.com", named("again")))
+	for _, pattern := range []string{"", "*.", "example.com:80", "a.*.example.com", "example.com/path"} {
+		assert.Error(t, mux.Handle(pattern, named("bad")), pattern)
+	}
+}
@@ -0,0 +1,104 @@
+package server
+
+import (
+	"bufio"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"httpfromtcp/internal/websocket"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWebSocket(t *testing.T) {
+	echo := func(w *response.Writer, req *request.Request) {
+		if !websocket.IsUpgrade(req) {
+			okHandler(w, req)
+			return
+		}
+		ws, err := websocket.Upgrade(w, req)
+		if err != nil {
+			return
+		}
+		defer ws.Close(websocket.CloseNormalClosure, "")
+		for {
+			messageType, data, err := ws.ReadMessage()
+			if err != nil {
+				return
+			}
+			ws.WriteMessage(messageType, append([]byte(req.RequestLine.RequestTarget+": "), data...))
+		}
+	}
+	// Handshakes go through whatever wraps the handler, like any request.
+	guarded := func(w *response.Writer, req *request.Request) {
+		if req.RequestLine.RequestTarget == "/private" {
+			w.WriteStatusLine(response.Forbidden)
+			w.WriteHeaders(response.GetDefaultHeaders(0))
+			w.WriteBody(nil)
+			return
+		}
+		w.Header().Set("X-Wrapped", "yes")
+		echo(w, req)
+	}
+	s := New(guarded)
+	defer s.Close()
+	addr, err := s.Listen("127.0.0.1:0")
+	require.NoError(t, err)
+
+	// Test: A handler upgrades the connection after its middleware ran
+	conn, err := net.Dial("tcp", addr.String())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("GET /echo HTTP/1.1\r\n" +
+		"Host: localhost\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n" +
+		"Sec-WebSocket-Version: 13\r\n" +
+		"Sec-WebSocket-Extensions: permessage-deflate\r\n" +
+		"\r\n"))
+	require.NoError(t, err)
+
+	reader := bufio.NewReader(conn)
+	res, err := http.ReadResponse(reader, &http.Request{Method: "GET"})
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusSwitchingProtocols, res.StatusCode)
+	assert.Equal(t, "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=", res.Header.Get("Sec-WebSocket-Accept"))
+	assert.Equal(t, "yes", res.Header.Get("X-Wrapped"))
+
+	ws := websocket.NewClientConn(conn, reader, true)
+	require.NoError(t, ws.WriteMessage(websocket.TextMessage, []byte("hi")))
+	messageType, data, err := ws.ReadMessage()
+	require.NoError(t, err)
+	assert.Equal(t, websocket.TextMessage, messageType)
+	assert.Equal(t, "/echo: hi", string(data))
+	assert.NoError(t, ws.Close(websocket.CloseNormalClosure, ""))
+
+	// Test: Middleware can refuse a handshake
+	conn3, err := net.Dial("tcp", addr.String())
+	require.NoError(t, err)
+	defer conn3.Close()
+	_, err = conn3.Write([]byte("GET /private HTTP/1.1\r\nHost: localhost\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n" +
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\n\r\n"))
+	require.NoError(t, err)
+	res, err = http.ReadResponse(bufio.NewReader(conn3), nil)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusForbidden, res.StatusCode)
+
+	// Test: Plain requests still reach the regular handler
+	assert.True(t, strings.HasSuffix(get(t, "tcp", addr.String()), "hello"))
+
+	// Test: Broken handshake gets 400
+	conn2, err := net.Dial("tcp", addr.String())
+	require.NoError(t, err)
+	defer conn2.Close()
+	_, err = conn2.Write([]byte("GET /echo HTTP/1.1\r\nHost: localhost\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n\r\n"))
+	require.NoError(t, err)
+	res, err = http.ReadResponse(bufio.NewReader(conn2), nil)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+}
@@ -0,0 +1,174 @@
+// Package sse streams Server-Sent Events (text/event-stream) to a client over
+// a chunked response.
+package sse
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"httpfromtcp/internal/headers"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrClosed = errors.New("sse: stream closed")
+
+// Event is a single message. Only Data is required; Event, ID and Retry are
+// sent when set.
+type Event struct {
+	// Event names the event type, dispatched by EventSource listeners.
+	Event string
+	// ID becomes the client's last event ID, sent back as Last-Event-ID when
+	// it reconnects.
+	ID string
+	// Data may span several lines; each is sent as its own data field.
+	Data string
+	// Retry tells the client how long to wait before reconnecting.
+	Retry time.Duration
+}
+
+// Stream writes events to one client. Its methods may be called from several
+// goroutines. Once the client disconnects, sends fail with the request
+// context's error.
+type Stream struct {
+	w           *response.Writer
+	ctx         context.Context
+	lastEventID string
+
+	mu     sync.Mutex
+	closed bool
+	stop   chan struct{}
+}
+
+// NewStream starts an event stream answering req, writing the status line and
+// headers right away.
+func NewStream(w *response.Writer, req *request.Request) (*Stream, error) {
+	h := response.GetDefaultHeaders(0)
+	h.Delete("content-length")
+	h.Set("Transfer-Encoding", "chunked")
+	h.Update("content-type", "text/event-stream")
+	h.Set("Cache-Control", "no-cache")
+
+	if err := w.WriteStatusLine(response.OK); err != nil {
+		return nil, err
+	}
+	if err := w.WriteHeaders(h); err != nil {
+		return nil, err
+	}
+
+	lastEventID, _ := req.Headers.Get("Last-Event-ID")
+	return &Stream{
+		w:           w,
+		ctx:         req.Context(),
+		lastEventID: strings.TrimSpace(lastEventID),
+		stop:        make(chan struct{}),
+	}, nil
+}
+
+// LastEventID returns the ID of the last event a reconnecting client saw, or
+// "" for a new client.
+func (s *Stream) LastEventID() string {
+	return s.lastEventID
+}
+
+// Done is closed when the client disconnects or the request times out.
+func (s *Stream) Done() <-chan struct{} {
+	return s.ctx.Done()
+}
+
+// Send writes e as one chunk, so the client receives it immediately.
+func (s *Stream) Send(e Event) error {
+	if strings.ContainsAny(e.Event, "\r\n") {
+		return fmt.Errorf("Error sending event: event type contains a line break: %q", e.Event)
+	}
+	if strings.ContainsAny(e.ID, "\r\n\x00") {
+		return fmt.Errorf("Error sending event: id contains a line break or NUL: %q", e.ID)
+	}
+
+	var b strings.Builder
+	if e.Event != "" {
+		b.WriteString("event: " + e.Event + "\n")
+	}
+	if e.ID != "" {
+		b.WriteString("id: " + e.ID + "\n")
+	}
+	if e.Retry > 0 {
+		b.WriteString("retry: " + strconv.FormatInt(e.Retry.Milliseconds(), 10) + "\n")
+	}
+	data := strings.ReplaceAll(e.Data, "\r\n", "\n")
+	data = strings.ReplaceAll(data, "\r", "\n")
+	for _, line := range strings.Split(data, "\n") {
+		b.WriteString("data: " + line + "\n")
+	}
+	b.WriteString("\n")
+
+	return s.write(b.String())
+}
+
+// Comment writes a comment line, which clients ignore.
+func (s *Stream) Comment(text string) error {
+	var b strings.Builder
+	for _, line := range strings.Split(strings.ReplaceAll(text, "\r", ""), "\n") {
+		b.WriteString(": " + line + "\n")
+	}
+	b.WriteString("\n")
+	return s.write(b.String())
+}
+
+// Heartbeat sends a comment every interval until the stream is closed or the
+// client disconnects, so proxies don't drop an idle connection.
+func (s *Stream) Heartbeat(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				if err := s.Comment("heartbeat"); err != nil {
+					return
+				}
+			case <-s.stop:
+				return
+			case <-s.ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// Close ends the stream. It must be called before the handler returns.
+func (s *Stream) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	close(s.stop)
+
+	if _, err := s.w.WriteChunkedBodyDone(); err != nil {
+		return err
+	}
+	return s.w.WriteTrailers(headers.NewHeaders())
+}
+
+func (s *Stream) write(chunk string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return ErrClosed
+	}
+	if err := s.ctx.Err(); err != nil {
+		return err
+	}
+
+	_, err := s.w.WriteChunkedBody([]byte(chunk))
+	return err
+}
@@ -0,0 +1,78 @@
+package sse
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestRequest(t *testing.T, raw string) *request.Request {
+	req, err := request.RequestFromReader(strings.NewReader(raw))
+	require.NoError(t, err)
+	return req
+}
+
+func readStream(t *testing.T, out *bytes.Buffer) (*http.Response, string) {
+	res, err := http.ReadResponse(bufio.NewReader(out), nil)
+	require.NoError(t, err)
+	body, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+	return res, string(body)
+}
+
+func TestStream(t *testing.T) {
+	req := newTestRequest(t, "GET /events HTTP/1.1\r\nHost: localhost\r\nLast-Event-ID: 41\r\n\r\n")
+
+	// Test: Events are written with all their fields
+	out := &bytes.Buffer{}
+	stream, err := NewStream(response.NewWriter(out), req)
+	require.NoError(t, err)
+	assert.Equal(t, "41", stream.LastEventID())
+
+	require.NoError(t, stream.Send(Event{Event: "update", ID: "42", Data: "line one\r\nline two", Retry: 3 * time.Second}))
+	require.NoError(t, stream.Send(Event{Data: "plain"}))
+	require.NoError(t, stream.Comment("keep going"))
+	require.NoError(t, stream.Close())
+	assert.ErrorIs(t, stream.Send(Event{Data: "late"}), ErrClosed)
+
+	res, body := readStream(t, out)
+	assert.Equal(t, "text/event-stream", res.Header.Get("Content-Type"))
+	assert.Equal(t, "no-cache", res.Header.Get("Cache-Control"))
+	assert.Equal(t, []string{"chunked"}, res.TransferEncoding)
+	assert.Equal(t, "event: update\nid: 42\nretry: 3000\ndata: line one\ndata: line two\n\n"+
+		"data: plain\n\n"+
+		": keep going\n\n", body)
+
+	// Test: Fields that would break the framing are rejected
+	assert.Error(t, stream.Send(Event{Event: "bad\nname", Data: "x"}))
+	assert.Error(t, stream.Send(Event{ID: "bad\x00id", Data: "x"}))
+
+	// Test: Heartbeats are sent while the stream is idle
+	out = &bytes.Buffer{}
+	stream, err = NewStream(response.NewWriter(out), newTestRequest(t, "GET /events HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, "", stream.LastEventID())
+	stream.Heartbeat(5 * time.Millisecond)
+	time.Sleep(30 * time.Millisecond)
+	require.NoError(t, stream.Close())
+	_, body = readStream(t, out)
+	assert.Contains(t, body, ": heartbeat\n\n")
+
+	// Test: Sends fail once the client has disconnected
+	ctx, cancel := context.WithCancel(context.Background())
+	stream, err = NewStream(response.NewWriter(io.Discard), req.WithContext(ctx))
+	require.NoError(t, err)
+	cancel()
+	<-stream.Done()
+	assert.ErrorIs(t, stream.Send(Event{Data: "gone"}), context.Canceled)
+}
@@ -0,0 +1,502 @@
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// Message types, which are also the frame opcodes.
+const (
+	TextMessage   = 1
+	BinaryMessage = 2
+	CloseMessage  = 8
+	PingMessage   = 9
+	PongMessage   = 10
+
+	continuationFrame = 0
+)
+
+// Close codes from RFC 6455 section 7.4.1.
+const (
+	CloseNormalClosure   = 1000
+	CloseGoingAway       = 1001
+	CloseProtocolError   = 1002
+	CloseUnsupportedData = 1003
+	CloseNoStatus        = 1005
+	CloseInvalidPayload  = 1007
+	CloseMessageTooBig   = 1009
+	CloseInternalError   = 1011
+)
+
+const (
+	finalBit = 0x80
+	rsv1Bit  = 0x40
+	rsvBits  = 0x70
+	maskBit  = 0x80
+
+	maxControlPayload = 125
+
+	defaultMaxMessageSize = 16 << 20
+	closeTimeout          = 5 * time.Second
+)
+
+// deflateTail is the empty stored block a sync flush ends with, which
+// permessage-deflate strips from every message.
+var deflateTail = []byte{0x00, 0x00, 0xff, 0xff}
+
+var ErrClosed = errors.New("websocket: connection closed")
+
+// CloseError is returned by ReadMessage once the peer has sent a close frame,
+// or once a protocol violation made us close the connection.
+type CloseError struct {
+	Code int
+	Text string
+}
+
+func (e *CloseError) Error() string {
+	return fmt.Sprintf("websocket: close %d %s", e.Code, e.Text)
+}
+
+// Conn is one side of a WebSocket connection. ReadMessage must be called from
+// a single goroutine; the write methods may be called concurrently with it and
+// with each other.
+type Conn struct {
+	conn     net.Conn
+	reader   *bufio.Reader
+	isServer bool
+	deflate  bool
+
+	// MaxMessageSize bounds a reassembled, decompressed message. Larger
+	// messages close the connection with 1009.
+	MaxMessageSize int64
+	// FragmentSize splits outgoing data messages into frames of at most this
+	// many payload bytes when positive.
+	FragmentSize int
+	// PingHandler is called with the payload of each ping after the pong is
+	// queued, and PongHandler with the payload of each pong.
+	PingHandler func(data []byte)
+	PongHandler func(data []byte)
+
+	writeMu    sync.Mutex
+	writer     io.Writer
+	closeSent  bool
+	readClosed bool
+}
+
+func newConn(conn net.Conn, reader *bufio.Reader, writer io.Writer, isServer, deflate bool) *Conn {
+	return &Conn{
+		conn:           conn,
+		reader:         reader,
+		writer:         writer,
+		isServer:       isServer,
+		deflate:        deflate,
+		MaxMessageSize: defaultMaxMessageSize,
+	}
+}
+
+// NetConn returns the underlying connection, e.g. to set deadlines.
+func (c *Conn) NetConn() net.Conn {
+	return c.conn
+}
+
+// Compressed reports whether permessage-deflate was negotiated.
+func (c *Conn) Compressed() bool {
+	return c.deflate
+}
+
+type frame struct {
+	final      bool
+	compressed bool
+	opcode     int
+	payload    []byte
+}
+
+// ReadMessage returns the next text or binary message, reassembling fragments
+// and answering pings and close frames along the way. Once the peer closes
+// the connection it returns a *CloseError.
+func (c *Conn) ReadMessage() (int, []byte, error) {
+	if c.readClosed {
+		return 0, nil, ErrClosed
+	}
+
+	var messageType int
+	var compressed bool
+	var message []byte
+
+	for {
+		f, err := c.readFrame()
+		if err != nil {
+			return 0, nil, c.fail(err)
+		}
+
+		switch f.opcode {
+		case PingMessage:
+			if err := c.writeFrame(PongMessage, f.payload, true, false); err != nil && !errors.Is(err, ErrClosed) {
+				return 0, nil, err
+			}
+			if c.PingHandler != nil {
+				c.PingHandler(f.payload)
+			}
+			continue
+		case PongMessage:
+			if c.PongHandler != nil {
+				c.PongHandler(f.payload)
+			}
+			continue
+		case CloseMessage:
+			return 0, nil, c.handleClose(f.payload)
+		case continuationFrame:
+			if messageType == 0 {
+				return 0, nil, c.fail(&CloseError{Code: CloseProtocolError, Text: "unexpected continuation frame"})
+			}
+		case TextMessage, BinaryMessage:
+			if messageType != 0 {
+				return 0, nil, c.fail(&CloseError{Code: CloseProtocolError, Text: "expected continuation frame"})
+			}
+			messageType = f.opcode
+			compressed = f.compressed
+		}
+
+		if int64(len(message)+len(f.payload)) > c.MaxMessageSize {
+			return 0, nil, c.fail(&CloseError{Code: CloseMessageTooBig, Text: "message too big"})
+		}
+		message = append(message, f.payload...)
+
+		if f.final {
+			break
+		}
+	}
+
+	if compressed {
+		inflated, err := c.inflate(message)
+		if err != nil {
+			return 0, nil, c.fail(err)
+		}
+		message = inflated
+	}
+
+	if messageType == TextMessage && !utf8.Valid(message) {
+		return 0, nil, c.fail(&CloseError{Code: CloseInvalidPayload, Text: "invalid UTF-8"})
+	}
+
+	return messageType, message, nil
+}
+
+func (c *Conn) readFrame() (frame, error) {
+	var header [2]byte
+	if _, err := io.ReadFull(c.reader, header[:]); err != nil {
+		return frame{}, err
+	}
+
+	f := frame{
+		final:      header[0]&finalBit != 0,
+		compressed: header[0]&rsv1Bit != 0,
+		opcode:     int(header[0] & 0x0f),
+	}
+	masked := header[1]&maskBit != 0
+	length := uint64(header[1] & 0x7f)
+
+	switch f.opcode {
+	case continuationFrame, TextMessage, BinaryMessage:
+	case CloseMessage, PingMessage, PongMessage:
+		if !f.final || length > maxControlPayload {
+			return frame{}, &CloseError{Code: CloseProtocolError, Text: "invalid control frame"}
+		}
+	default:
+		return frame{}, &CloseError{Code: CloseProtocolError, Text: fmt.Sprintf("unknown opcode %d", f.opcode)}
+	}
+
+	if header[0]&rsvBits&^rsv1Bit != 0 || (f.compressed && (!c.deflate || f.opcode == continuationFrame || f.opcode >= CloseMessage)) {
+		return frame{}, &CloseError{Code: CloseProtocolError, Text: "unexpected reserved bits"}
+	}
+	if masked != c.isServer {
+		return frame{}, &CloseError{Code: CloseProtocolError, Text: "incorrect masking"}
+	}
+
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.reader, ext[:]); err != nil {
+			return frame{}, err
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.reader, ext[:]); err != nil {
+			return frame{}, err
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+	if length > uint64(c.MaxMessageSize) {
+		return frame{}, &CloseError{Code: CloseMessageTooBig, Text: "message too big"}
+	}
+
+	var key [4]byte
+	if masked {
+		if _, err := io.ReadFull(c.reader, key[:]); err != nil {
+			return frame{}, err
+		}
+	}
+
+	f.payload = make([]byte, length)
+	if _, err := io.ReadFull(c.reader, f.payload); err != nil {
+		return frame{}, err
+	}
+	if masked {
+		maskBytes(key, f.payload)
+	}
+
+	return f, nil
+}
+
+// handleClose answers a close frame from the peer and closes the connection.
+func (c *Conn) handleClose(payload []byte) error {
+	closeErr := &CloseError{Code: CloseNoStatus}
+	reply := CloseNormalClosure
+
+	switch {
+	case len(payload) == 1:
+		closeErr.Code = CloseProtocolError
+		reply = CloseProtocolError
+	case len(payload) >= 2:
+		closeErr.Code = int(binary.BigEndian.Uint16(payload))
+		closeErr.Text = string(payload[2:])
+		if !validCloseCode(closeErr.Code) {
+			reply = CloseProtocolError
+		} else if !utf8.Valid(payload[2:]) {
+			reply = CloseInvalidPayload
+		} else {
+			reply = closeErr.Code
+		}
+	}
+
+	c.writeFrame(CloseMessage, closePayload(reply, ""), true, false)
+	c.readClosed = true
+	c.conn.Close()
+	return closeErr
+}
+
+// fail turns a read error into the error ReadMessage returns. Protocol
+// violations are reported to the peer with a close frame first.
+func (c *Conn) fail(err error) error {
+	var closeErr *CloseError
+	if errors.As(err, &closeErr) {
+		c.writeFrame(CloseMessage, closePayload(closeErr.Code, closeErr.Text), true, false)
+	}
+	c.readClosed = true
+	c.conn.Close()
+	return err
+}
+
+// WriteMessage sends a text or binary message, compressed if permessage-deflate
+// was negotiated and fragmented if FragmentSize is set.
+func (c *Conn) WriteMessage(messageType int, data []byte) error {
+	if messageType != TextMessage && messageType != BinaryMessage {
+		return fmt.Errorf("Error writing message: invalid message type %d", messageType)
+	}
+
+	compressed := false
+	if c.deflate {
+		deflated, err := deflate(data)
+		if err != nil {
+			return fmt.Errorf("Error compressing message: %s", err)
+		}
+		data = deflated
+		compressed = true
+	}
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	opcode := messageType
+	for {
+		n := len(data)
+		if c.FragmentSize > 0 && n > c.FragmentSize {
+			n = c.FragmentSize
+		}
+		final := n == len(data)
+
+		if err := c.writeFrameLocked(opcode, data[:n], final, compressed); err != nil {
+			return err
+		}
+		if final {
+			return nil
+		}
+
+		data = data[n:]
+		opcode = continuationFrame
+		compressed = false
+	}
+}
+
+// Ping sends a ping; the peer's pong is passed to PongHandler.
+func (c *Conn) Ping(data []byte) error {
+	if len(data) > maxControlPayload {
+		return fmt.Errorf("Error writing ping: payload longer than %d bytes", maxControlPayload)
+	}
+	return c.writeFrame(PingMessage, data, true, false)
+}
+
+// Close starts the close handshake: it sends a close frame with code and
+// reason, waits briefly for the peer's close frame and closes the connection.
+// Use it only when no other goroutine is reading from the connection.
+func (c *Conn) Close(code int, reason string) error {
+	if c.readClosed {
+		return nil
+	}
+
+	payload := closePayload(code, reason)
+	if len(payload) > maxControlPayload {
+		payload = payload[:maxControlPayload]
+	}
+	err := c.writeFrame(CloseMessage, payload, true, false)
+	if err == nil {
+		// Frames the peer sent before it saw ours are discarded. A peer that
+		// hangs up without answering has closed the connection all the same.
+		c.conn.SetReadDeadline(time.Now().Add(closeTimeout))
+		for {
+			f, readErr := c.readFrame()
+			if readErr != nil || f.opcode == CloseMessage {
+				break
+			}
+		}
+	}
+
+	c.readClosed = true
+	if closeErr := c.conn.Close(); err == nil {
+		err = closeErr
+	}
+	if errors.Is(err, ErrClosed) {
+		err = nil
+	}
+	return err
+}
+
+func (c *Conn) writeFrame(opcode int, payload []byte, final, compressed bool) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	return c.writeFrameLocked(opcode, payload, final, compressed)
+}
+
+// writeFrameLocked writes one frame, masking it when acting as the client.
+// Nothing may be written after a close frame.
+func (c *Conn) writeFrameLocked(opcode int, payload []byte, final, compressed bool) error {
+	if c.closeSent {
+		return ErrClosed
+	}
+
+	buf := make([]byte, 0, len(payload)+14)
+
+	b0 := byte(opcode)
+	if final {
+		b0 |= finalBit
+	}
+	if compressed {
+		b0 |= rsv1Bit
+	}
+	buf = append(buf, b0)
+
+	var b1 byte
+	if !c.isServer {
+		b1 = maskBit
+	}
+	switch {
+	case len(payload) < 126:
+		buf = append(buf, b1|byte(len(payload)))
+	case len(payload) <= 0xffff:
+		buf = append(buf, b1|126)
+		buf = binary.BigEndian.AppendUint16(buf, uint16(len(payload)))
+	default:
+		buf = append(buf, b1|127)
+		buf = binary.BigEndian.AppendUint64(buf, uint64(len(payload)))
+	}
+
+	if c.isServer {
+		buf = append(buf, payload...)
+	} else {
+		var key [4]byte
+		if _, err := rand.Read(key[:]); err != nil {
+			return fmt.Errorf("Error generating mask: %s", err)
+		}
+		buf = append(buf, key[:]...)
+		start := len(buf)
+		buf = append(buf, payload...)
+		maskBytes(key, buf[start:])
+	}
+
+	if opcode == CloseMessage {
+		c.closeSent = true
+	}
+
+	if _, err := c.writer.Write(buf); err != nil {
+		return fmt.Errorf("Error writing frame: %s", err)
+	}
+	return nil
+}
+
+func (c *Conn) inflate(data []byte) ([]byte, error) {
+	// The final empty stored block marks the end of the stream so the reader
+	// returns io.EOF instead of waiting for more input.
+	input := io.MultiReader(bytes.NewReader(data), bytes.NewReader(deflateTail), bytes.NewReader([]byte{0x01, 0x00, 0x00, 0xff, 0xff}))
+	reader := flate.NewReader(input)
+	defer reader.Close()
+
+	inflated, err := io.ReadAll(io.LimitReader(reader, c.MaxMessageSize+1))
+	if err != nil {
+		return nil, &CloseError{Code: CloseInvalidPayload, Text: "invalid compressed data"}
+	}
+	if int64(len(inflated)) > c.MaxMessageSize {
+		return nil, &CloseError{Code: CloseMessageTooBig, Text: "message too big"}
+	}
+	return inflated, nil
+}
+
+func deflate(data []byte) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	writer, err := flate.NewWriter(buf, flate.DefaultCompression)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := writer.Write(data); err != nil {
+		return nil, err
+	}
+	if err := writer.Flush(); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), deflateTail), nil
+}
+
+func maskBytes(key [4]byte, data []byte) {
+	for i := range data {
+		data[i] ^= key[i%4]
+	}
+}
+
+func closePayload(code int, reason string) []byte {
+	if code == CloseNoStatus {
+		return nil
+	}
+	payload := binary.BigEndian.AppendUint16(nil, uint16(code))
+	return append(payload, reason...)
+}
+
+// validCloseCode reports whether code may be sent in a close frame.
+func validCloseCode(code int) bool {
+	switch {
+	case code >= 1000 && code <= 1003, code >= 1007 && code <= 1011:
+		return true
+	case code >= 3000 && code <= 4999:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,148 @@
+// Package websocket implements the WebSocket protocol (RFC 6455) with the
+// permessage-deflate extension (RFC 7692) on top of a connection a handler
+// takes over from the server.
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"httpfromtcp/internal/headers"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"io"
+	"net"
+	"strings"
+)
+
+const acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+var ErrBadHandshake = errors.New("websocket: bad handshake")
+
+// IsUpgrade reports whether req asks to switch the connection to WebSocket.
+func IsUpgrade(req *request.Request) bool {
+	upgrade, _ := req.Headers.Get("Upgrade")
+	connection, _ := req.Headers.Get("Connection")
+	return strings.EqualFold(strings.TrimSpace(upgrade), "websocket") && headerContains(connection, "upgrade")
+}
+
+// AcceptKey computes the Sec-WebSocket-Accept value for a client's
+// Sec-WebSocket-Key.
+func AcceptKey(key string) string {
+	sum := sha1.Sum([]byte(key + acceptGUID))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// Upgrade validates the handshake in req, answers it on w with 101 Switching
+// Protocols and takes the connection over with w.Hijack, so it is meant to be
+// called from a handler, after whatever middleware wraps it. Invalid
+// handshakes get 400 and ErrBadHandshake. Connections that can't be taken
+// over, such as HTTP/2 streams, get no response and an error wrapping
+// response.ErrNotHijackable. Closing the returned Conn is up to the caller.
+func Upgrade(w *response.Writer, req *request.Request) (*Conn, error) {
+	key, err := validateHandshake(req)
+	if err != nil {
+		body := []byte(err.Error())
+		headers := response.GetDefaultHeaders(len(body))
+		headers.Set("Sec-WebSocket-Version", "13")
+		if err := w.WriteStatusLine(response.BadRequest); err == nil {
+			if err := w.WriteHeaders(headers); err == nil {
+				w.WriteBody(body)
+			}
+		}
+		return nil, err
+	}
+	if _, ok := w.Writer.(response.Hijacker); !ok {
+		return nil, fmt.Errorf("websocket: %w", response.ErrNotHijackable)
+	}
+
+	extensions, _ := req.Headers.Get("Sec-WebSocket-Extensions")
+	deflate := offersDeflate(extensions)
+
+	h := headers.NewHeaders()
+	h.Set("Upgrade", "websocket")
+	h.Set("Connection", "Upgrade")
+	h.Set("Sec-WebSocket-Accept", AcceptKey(key))
+	if deflate {
+		// Without context takeover every message is compressed on its own,
+		// so neither side has to keep a sliding window between messages.
+		h.Set("Sec-WebSocket-Extensions", "permessage-deflate; server_no_context_takeover; client_no_context_takeover")
+	}
+
+	if err := w.WriteStatusLine(response.SwitchingProtocols); err != nil {
+		return nil, err
+	}
+	if err := w.WriteHeaders(h); err != nil {
+		return nil, err
+	}
+
+	conn, buffered, err := w.Hijack()
+	if err != nil {
+		return nil, err
+	}
+	reader := io.MultiReader(bytes.NewReader(buffered), conn)
+	return newConn(conn, bufio.NewReader(reader), conn, true, deflate), nil
+}
+
+// NewClientConn wraps the client side of a connection whose handshake has
+// already completed, e.g. in tests. Frames it sends are masked.
+func NewClientConn(conn net.Conn, reader io.Reader, deflate bool) *Conn {
+	return newConn(conn, bufio.NewReader(reader), conn, false, deflate)
+}
+
+func validateHandshake(req *request.Request) (string, error) {
+	if req.RequestLine.Method != "GET" {
+		return "", fmt.Errorf("%w: method must be GET", ErrBadHandshake)
+	}
+	if !IsUpgrade(req) {
+		return "", fmt.Errorf("%w: missing Upgrade: websocket", ErrBadHandshake)
+	}
+	if version, _ := req.Headers.Get("Sec-WebSocket-Version"); strings.TrimSpace(version) != "13" {
+		return "", fmt.Errorf("%w: unsupported version %q", ErrBadHandshake, version)
+	}
+
+	key, _ := req.Headers.Get("Sec-WebSocket-Key")
+	key = strings.TrimSpace(key)
+	decoded, err := base64.StdEncoding.DecodeString(key)
+	if err != nil || len(decoded) != 16 {
+		return "", fmt.Errorf("%w: invalid Sec-WebSocket-Key", ErrBadHandshake)
+	}
+	return key, nil
+}
+
+// offersDeflate reports whether one of the offered extensions is a
+// permessage-deflate we can accept without parameters we don't support.
+func offersDeflate(extensions string) bool {
+	for _, offer := range strings.Split(extensions, ",") {
+		params := strings.Split(offer, ";")
+		if strings.TrimSpace(params[0]) != "permessage-deflate" {
+			continue
+		}
+
+		acceptable := true
+		for _, param := range params[1:] {
+			name, _, _ := strings.Cut(strings.TrimSpace(param), "=")
+			switch name {
+			case "client_max_window_bits", "server_no_context_takeover", "client_no_context_takeover":
+			default:
+				acceptable = false
+			}
+		}
+		if acceptable {
+			return true
+		}
+	}
+	return false
+}
+
+func headerContains(value, token string) bool {
+	for _, part := range strings.Split(value, ",") {
+		if strings.EqualFold(strings.TrimSpace(part), token) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,197 @@
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const handshake = "GET /chat HTTP/1.1\r\n" +
+	"Host: localhost\r\n" +
+	"Upgrade: websocket\r\n" +
+	"Connection: keep-alive, Upgrade\r\n" +
+	"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n" +
+	"Sec-WebSocket-Version: 13\r\n"
+
+// hijackableBuffer collects the handshake response and hands over conn.
+type hijackableBuffer struct {
+	bytes.Buffer
+	conn net.Conn
+}
+
+func (b *hijackableBuffer) Hijack() (net.Conn, []byte, error) {
+	return b.conn, nil, nil
+}
+
+func upgrade(t *testing.T, raw string) (*http.Response, *Conn, error) {
+	req, err := request.RequestFromReader(strings.NewReader(raw))
+	require.NoError(t, err)
+
+	server, client := net.Pipe()
+	defer server.Close()
+	defer client.Close()
+	out := &hijackableBuffer{conn: server}
+
+	ws, err := Upgrade(response.NewWriter(out), req)
+	res, readErr := http.ReadResponse(bufio.NewReader(&out.Buffer), nil)
+	require.NoError(t, readErr)
+	return res, ws, err
+}
+
+// pair returns both ends of an established connection over loopback TCP,
+// whose buffering lets both sides write at the same time.
+func pair(t *testing.T, deflate bool) (*Conn, *Conn) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+
+	client, err := net.Dial("tcp", listener.Addr().String())
+	require.NoError(t, err)
+	server, err := listener.Accept()
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		client.Close()
+		server.Close()
+	})
+
+	return newConn(server, bufio.NewReader(server), server, true, deflate), NewClientConn(client, client, deflate)
+}
+
+func TestHandshake(t *testing.T) {
+	// Test: Accept key from RFC 6455 section 1.3
+	assert.Equal(t, "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=", AcceptKey("dGhlIHNhbXBsZSBub25jZQ=="))
+
+	// Test: Valid handshake gets 101
+	res, ws, err := upgrade(t, handshake+"\r\n")
+	require.NoError(t, err)
+	assert.Equal(t, 101, res.StatusCode)
+	assert.Equal(t, "websocket", res.Header.Get("Upgrade"))
+	assert.Equal(t, "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=", res.Header.Get("Sec-WebSocket-Accept"))
+	assert.Empty(t, res.Header.Get("Sec-WebSocket-Extensions"))
+	assert.False(t, ws.Compressed())
+
+	// Test: permessage-deflate is negotiated
+	res, ws, err = upgrade(t, handshake+"Sec-WebSocket-Extensions: x-webkit-deflate-frame, permessage-deflate; client_max_window_bits\r\n\r\n")
+	require.NoError(t, err)
+	assert.Contains(t, res.Header.Get("Sec-WebSocket-Extensions"), "permessage-deflate")
+	assert.True(t, ws.Compressed())
+
+	// Test: Unsupported version gets 400
+	res, _, err = upgrade(t, strings.Replace(handshake, "Version: 13", "Version: 8", 1)+"\r\n")
+	assert.ErrorIs(t, err, ErrBadHandshake)
+	assert.Equal(t, 400, res.StatusCode)
+	assert.Equal(t, "13", res.Header.Get("Sec-WebSocket-Version"))
+
+	// Test: Invalid key gets 400
+	res, _, err = upgrade(t, strings.Replace(handshake, "dGhlIHNhbXBsZSBub25jZQ==", "short", 1)+"\r\n")
+	assert.ErrorIs(t, err, ErrBadHandshake)
+	assert.Equal(t, 400, res.StatusCode)
+
+	// Test: Connections that can't be taken over get no response
+	req, err := request.RequestFromReader(strings.NewReader(handshake + "\r\n"))
+	require.NoError(t, err)
+	w := response.NewWriter(&bytes.Buffer{})
+	_, err = Upgrade(w, req)
+	assert.ErrorIs(t, err, response.ErrNotHijackable)
+	assert.False(t, w.HeadersSent())
+}
+
+func TestMessages(t *testing.T) {
+	for _, deflate := range []bool{false, true} {
+		server, client := pair(t, deflate)
+
+		go func() {
+			for {
+				messageType, data, err := server.ReadMessage()
+				if err != nil {
+					return
+				}
+				server.WriteMessage(messageType, data)
+			}
+		}()
+
+		// Test: Text message is echoed
+		require.NoError(t, client.WriteMessage(TextMessage, []byte("hello")))
+		messageType, data, err := client.ReadMessage()
+		require.NoError(t, err)
+		assert.Equal(t, TextMessage, messageType)
+		assert.Equal(t, "hello", string(data))
+
+		// Test: Large fragmented binary message is reassembled
+		large := bytes.Repeat([]byte("0123456789"), 10000)
+		client.FragmentSize = 1000
+		require.NoError(t, client.WriteMessage(BinaryMessage, large))
+		messageType, data, err = client.ReadMessage()
+		require.NoError(t, err)
+		assert.Equal(t, BinaryMessage, messageType)
+		assert.Equal(t, large, data)
+
+		// Test: Pings are answered with pongs
+		pong := make(chan []byte, 1)
+		client.PongHandler = func(data []byte) { pong <- data }
+		require.NoError(t, client.Ping([]byte("are you there")))
+		require.NoError(t, client.WriteMessage(TextMessage, []byte("after ping")))
+		_, data, err = client.ReadMessage()
+		require.NoError(t, err)
+		assert.Equal(t, "after ping", string(data))
+		assert.Equal(t, "are you there", string(<-pong))
+
+		// Test: Close handshake completes
+		assert.NoError(t, client.Close(CloseGoingAway, "bye"))
+		_, _, err = client.ReadMessage()
+		assert.ErrorIs(t, err, ErrClosed)
+	}
+}
+
+func TestProtocolErrors(t *testing.T) {
+	server, client := pair(t, false)
+	errs := make(chan error, 1)
+	go func() {
+		_, _, err := server.ReadMessage()
+		errs <- err
+	}()
+
+	// Test: Unmasked client frame is rejected with 1002
+	client.isServer = true
+	require.NoError(t, client.writeFrame(TextMessage, []byte("unmasked"), true, false))
+	client.isServer = false
+
+	var closeErr *CloseError
+	require.ErrorAs(t, <-errs, &closeErr)
+	assert.Equal(t, CloseProtocolError, closeErr.Code)
+
+	f, err := client.readFrame()
+	require.NoError(t, err)
+	assert.Equal(t, CloseMessage, f.opcode)
+	assert.Equal(t, []byte{0x03, 0xea}, f.payload[:2])
+
+	// Test: Invalid UTF-8 in a text message is rejected with 1007
+	server, client = pair(t, false)
+	go func() {
+		_, _, err := server.ReadMessage()
+		errs <- err
+	}()
+	require.NoError(t, client.WriteMessage(TextMessage, []byte{0xff, 0xfe}))
+	require.ErrorAs(t, <-errs, &closeErr)
+	assert.Equal(t, CloseInvalidPayload, closeErr.Code)
+
+	// Test: Messages over MaxMessageSize are rejected with 1009
+	server, client = pair(t, false)
+	server.MaxMessageSize = 10
+	go func() {
+		_, _, err := server.ReadMessage()
+		errs <- err
+	}()
+	client.FragmentSize = 8
+	go client.WriteMessage(BinaryMessage, make([]byte, 16))
+	require.ErrorAs(t, <-errs, &closeErr)
+	assert.Equal(t, CloseMessageTooBig, closeErr.Code)
+}